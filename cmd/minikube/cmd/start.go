@@ -179,7 +179,7 @@ func initKubernetesFlags() {
 
 // initDriverFlags inits the commandline flags for vm drivers
 func initDriverFlags() {
-	startCmd.Flags().String(vmDriver, constants.DefaultVMDriver, fmt.Sprintf("VM driver is one of: %v", constants.SupportedVMDrivers))
+	startCmd.Flags().String(vmDriver, constants.DefaultVMDriver, fmt.Sprintf("VM driver is one of: %v", drivers.SupportedDrivers(runtime.GOOS, runtime.GOARCH)))
 	startCmd.Flags().Bool(disableDriverMounts, false, "Disables the filesystem mounts provided by the hypervisors")
 
 	// kvm2
@@ -574,12 +574,15 @@ func validateUser() {
 
 // validateConfig validates the supplied configuration against known bad combinations
 func validateConfig() {
-	diskSizeMB := pkgutil.CalculateSizeInMB(viper.GetString(humanReadableDiskSize))
-	if diskSizeMB < pkgutil.CalculateSizeInMB(constants.MinimumDiskSize) && !viper.GetBool(force) {
-		exit.WithCodeT(exit.Config, "Requested disk size {{.requested_size}} is less than minimum of {{.minimum_size}}", out.V{"requested_size": diskSizeMB, "minimum_size": pkgutil.CalculateSizeInMB(constants.MinimumDiskSize)})
+	diskSizeMB, err := drivers.ParseDiskSize(viper.GetString(humanReadableDiskSize))
+	if err != nil {
+		exit.WithCodeT(exit.Config, "Invalid disk size: {{.error}}", out.V{"error": err})
+	}
+	if minDiskSizeMB := drivers.MinimumDiskSizeMB(viper.GetString(vmDriver)); diskSizeMB < minDiskSizeMB && !viper.GetBool(force) {
+		exit.WithCodeT(exit.Config, "Requested disk size {{.requested_size}} is less than minimum of {{.minimum_size}}", out.V{"requested_size": diskSizeMB, "minimum_size": minDiskSizeMB})
 	}
 
-	err := autoSetOptions(viper.GetString(vmDriver))
+	err = autoSetOptions(viper.GetString(vmDriver))
 	if err != nil {
 		glog.Errorf("Error autoSetOptions : %v", err)
 	}
@@ -728,6 +731,11 @@ func generateCfgFromFlags(cmd *cobra.Command, k8sVersion string) (cfg.Config, er
 		out.T(out.SuccessType, "Using image repository {{.name}}", out.V{"name": repository})
 	}
 
+	diskSizeMB, err := drivers.ParseDiskSize(viper.GetString(humanReadableDiskSize))
+	if err != nil {
+		return cfg.Config{}, err
+	}
+
 	cfg := cfg.Config{
 		MachineConfig: cfg.MachineConfig{
 			KeepContext:         viper.GetBool(keepContext),
@@ -735,7 +743,7 @@ func generateCfgFromFlags(cmd *cobra.Command, k8sVersion string) (cfg.Config, er
 			MinikubeISO:         viper.GetString(isoURL),
 			Memory:              pkgutil.CalculateSizeInMB(viper.GetString(memory)),
 			CPUs:                viper.GetInt(cpus),
-			DiskSize:            pkgutil.CalculateSizeInMB(viper.GetString(humanReadableDiskSize)),
+			DiskSize:            diskSizeMB,
 			VMDriver:            viper.GetString(vmDriver),
 			ContainerRuntime:    viper.GetString(containerRuntime),
 			HyperkitVpnKitSock:  viper.GetString(vpnkitSock),
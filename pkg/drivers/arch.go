@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// peMachineArm64 is IMAGE_FILE_MACHINE_ARM64, which debug/pe doesn't define
+const peMachineArm64 = 0xaa64
+
+// binaryPlatform returns the GOOS and GOARCH that the executable at path was built for.
+// ELF binaries report an empty GOOS, as the format is shared by linux and the BSDs.
+// ok is false if path isn't a recognised executable, such as a script.
+func binaryPlatform(path string) (goos, goarch string, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", false, err
+	}
+	defer f.Close()
+
+	if e, err := elf.NewFile(f); err == nil {
+		switch e.Machine {
+		case elf.EM_X86_64:
+			goarch = "amd64"
+		case elf.EM_386:
+			goarch = "386"
+		case elf.EM_AARCH64:
+			goarch = "arm64"
+		case elf.EM_ARM:
+			goarch = "arm"
+		case elf.EM_PPC64:
+			goarch = "ppc64"
+			if e.ByteOrder == binary.LittleEndian {
+				goarch = "ppc64le"
+			}
+		case elf.EM_S390:
+			goarch = "s390x"
+		default:
+			goarch = e.Machine.String()
+		}
+		return "", goarch, true, nil
+	}
+	if m, err := macho.NewFile(f); err == nil {
+		switch m.Cpu {
+		case macho.CpuAmd64:
+			goarch = "amd64"
+		case macho.Cpu386:
+			goarch = "386"
+		case macho.CpuArm64:
+			goarch = "arm64"
+		case macho.CpuArm:
+			goarch = "arm"
+		default:
+			goarch = m.Cpu.String()
+		}
+		return "darwin", goarch, true, nil
+	}
+	if p, err := pe.NewFile(f); err == nil {
+		switch p.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			goarch = "amd64"
+		case pe.IMAGE_FILE_MACHINE_I386:
+			goarch = "386"
+		case peMachineArm64:
+			goarch = "arm64"
+		case pe.IMAGE_FILE_MACHINE_ARMNT:
+			goarch = "arm"
+		default:
+			goarch = fmt.Sprintf("%#x", p.Machine)
+		}
+		return "windows", goarch, true, nil
+	}
+	return "", "", false, nil
+}
+
+// checkDriverPlatform returns an error if the driver at path was built for another OS or architecture
+// than this minikube. Drivers in a format that can't be inspected, such as scripts, are let through.
+func checkDriverPlatform(path string) error {
+	goos, goarch, ok, err := binaryPlatform(path)
+	if err != nil {
+		return errors.Wrapf(err, "inspect %s", path)
+	}
+	if !ok {
+		return nil
+	}
+	if goos == "" {
+		// ELF runs on linux and the BSDs, but not on darwin or windows
+		goos = runtime.GOOS
+		if goos == "darwin" || goos == "windows" {
+			goos = "linux"
+		}
+	}
+	if goos != runtime.GOOS || goarch != runtime.GOARCH {
+		return errors.Errorf("%s is built for %s/%s, but minikube runs on %s/%s", path, goos, goarch, runtime.GOOS, runtime.GOARCH)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/blang/semver"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+// writeELFStub writes a minimal executable ELF header for machine to path
+func writeELFStub(t *testing.T, path string, machine elf.Machine) {
+	t.Helper()
+	hdr := elf.Header64{
+		Type:    uint16(elf.ET_EXEC),
+		Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  64,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, hdr); err != nil {
+		t.Fatalf("encode elf header: %v", err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+}
+
+// foreignMachine returns an ELF machine that this test binary doesn't run on
+func foreignMachine() elf.Machine {
+	if runtime.GOARCH == "arm64" {
+		return elf.EM_X86_64
+	}
+	return elf.EM_AARCH64
+}
+
+func TestCheckDriverPlatform(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	// the test binary itself is built for this platform
+	if err := checkDriverPlatform(os.Args[0]); err != nil {
+		t.Errorf("checkDriverPlatform(test binary) error = %v", err)
+	}
+
+	writeStubDriver(t, tmpdir, "script", "echo version: v1.3.0")
+	if err := checkDriverPlatform(filepath.Join(tmpdir, "script")); err != nil {
+		t.Errorf("checkDriverPlatform(script) error = %v, want scripts let through", err)
+	}
+
+	foreign := filepath.Join(tmpdir, "foreign")
+	writeELFStub(t, foreign, foreignMachine())
+	if err := checkDriverPlatform(foreign); err == nil {
+		t.Error("checkDriverPlatform() of a foreign arch binary succeeded")
+	}
+}
+
+func TestInstallOrUpdateReplacesForeignArch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	_, cleanup := serveDriver(t, "#!/bin/sh\necho version: v1.3.0\n")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(tmpdir, "empty"))
+
+	v := semver.MustParse("1.3.0")
+	opts := InstallOptions{Quiet: true, VersionedCache: true}
+	dir := VersionedDriverDir(tmpdir, v)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeELFStub(t, filepath.Join(dir, kvmDriver), foreignMachine())
+
+	got, err := InstallOrUpdateWithResult(kvmDriver, tmpdir, v, opts)
+	if err != nil {
+		t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+	}
+	if got.Action != DriverUpdated {
+		t.Errorf("InstallOrUpdateWithResult() action = %v, want %v", got.Action, DriverUpdated)
+	}
+	if err := checkDriverPlatform(filepath.Join(dir, kvmDriver)); err != nil {
+		t.Errorf("foreign arch driver was not replaced: %v", err)
+	}
+}
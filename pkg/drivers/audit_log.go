@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AuditEntry is a line of the driver install audit log
+type AuditEntry struct {
+	// Time is when the driver was installed
+	Time time.Time `json:"time"`
+	// Driver is the name of the driver
+	Driver string `json:"driver"`
+	// Action is "install" for a driver that was missing, or "update" for one that was replaced
+	Action string `json:"action"`
+	// Source is where the driver was downloaded from, without any credentials or query
+	Source string `json:"source"`
+	// Version is the driver version installed, if known
+	Version string `json:"version,omitempty"`
+	// SHA256 is the hex sha256 digest of the installed driver
+	SHA256 string `json:"sha256"`
+	// Path is where the driver was installed
+	Path string `json:"path"`
+}
+
+// redactURL returns rawurl without the user info, query or fragment, which may carry secrets
+// such as tokens for a private mirror
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		// don't risk recording something that only looks like a URL
+		return "<unparseable>"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	return u.String()
+}
+
+// appendAuditEntry appends e to the JSON lines audit log at path, creating it if absent
+func appendAuditEntry(path string, e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "marshal audit entry")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "create audit log dir")
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open audit log")
+	}
+	// a single write, so that concurrent installs don't interleave their lines
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return errors.Wrap(err, "write audit log")
+	}
+	return f.Close()
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestDownloadAuditLog(t *testing.T) {
+	body := "#!/bin/sh\necho version: v1.3.0\n"
+	srv, cleanup := serveDriver(t, body)
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	auditLog := filepath.Join(tmpdir, "audit", "drivers.jsonl")
+
+	// credentials for a private mirror must not end up in the log
+	mirror := strings.Replace(srv.URL, "http://", "http://user:secret@", 1) + "/{{.Driver}}?token=secret"
+	opts := InstallOptions{Quiet: true, URLTemplate: mirror, Version: "v1.3.0", AuditLog: auditLog}
+	if err := download(kvmDriver, tmpdir, opts); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	opts.Force = true
+	if err := download(kvmDriver, tmpdir, opts); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+
+	content, err := ioutil.ReadFile(auditLog)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if strings.Contains(string(content), "secret") {
+		t.Errorf("audit log records credentials: %s", content)
+	}
+	sum := sha256.Sum256([]byte(body))
+	var actions []string
+	s := bufio.NewScanner(strings.NewReader(string(content)))
+	for s.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("unmarshal %q: %v", s.Text(), err)
+		}
+		if e.Driver != kvmDriver || e.Source != srv.URL+"/"+kvmDriver || e.Version != "v1.3.0" || e.SHA256 != hex.EncodeToString(sum[:]) || e.Path != filepath.Join(tmpdir, kvmDriver) || e.Time.IsZero() {
+			t.Errorf("audit entry = %+v", e)
+		}
+		actions = append(actions, e.Action)
+	}
+	if strings.Join(actions, ",") != "install,update" {
+		t.Errorf("audit log actions = %v, want [install update]", actions)
+	}
+}
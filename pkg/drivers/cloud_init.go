@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cloudInitLabel is the volume label cloud-init looks for on a NoCloud seed
+const cloudInitLabel = "CIDATA"
+
+// isoSectorSize is the logical block size of ISO9660 images
+const isoSectorSize = 2048
+
+// seedFile is a file in a NoCloud seed
+type seedFile struct {
+	// name is the name cloud-init reads the file as, e.g. user-data
+	name string
+	// data is the file contents
+	data []byte
+}
+
+// CreateCloudInitSeed writes a NoCloud seed ISO to destPath holding userData and metaData, for
+// drivers to attach to the guest as a second disk so that cloud-init configures it. The ISO is
+// built in-process, with Rock Ridge names, so no mkisofs is needed.
+func CreateCloudInitSeed(destPath string, userData, metaData []byte) error {
+	iso := buildSeedISO(cloudInitLabel, []seedFile{
+		{name: "meta-data", data: metaData},
+		{name: "user-data", data: userData},
+	}, time.Now().UTC())
+	if err := ioutil.WriteFile(destPath, iso, 0644); err != nil {
+		return errors.Wrap(err, "write cloud-init seed")
+	}
+	return nil
+}
+
+// buildSeedISO lays out a single directory ISO9660 image holding files, which must be sorted by
+// their ISO9660 names. Sectors 0-15 are the system area, followed by the primary volume
+// descriptor, the terminator, the two path tables, the root directory and then file data.
+func buildSeedISO(label string, files []seedFile, now time.Time) []byte {
+	const (
+		pvdSector     = 16
+		lPathSector   = 18
+		mPathSector   = 19
+		rootDirSector = 20
+	)
+	extents := make([]uint32, len(files))
+	next := uint32(rootDirSector + 1)
+	for i, f := range files {
+		extents[i] = next
+		next += uint32((len(f.data) + isoSectorSize - 1) / isoSectorSize)
+	}
+	img := make([]byte, int(next)*isoSectorSize)
+	sector := func(n int) []byte { return img[n*isoSectorSize : (n+1)*isoSectorSize] }
+
+	// the root directory, whose "." record marks the use of Rock Ridge with a SUSP SP entry.
+	// Readers such as libarchive only take Rock Ridge to be in use if every record that has
+	// SUSP entries has a Rock Ridge one too, so each record carries a PX entry.
+	var root bytes.Buffer
+	sp := []byte{'S', 'P', 7, 1, 0xBE, 0xEF, 0}
+	root.Write(isoDirRecord([]byte{0}, rootDirSector, isoSectorSize, true, now, append(sp, rockRidgePX(0040555, 2)...)))
+	root.Write(isoDirRecord([]byte{1}, rootDirSector, isoSectorSize, true, now, rockRidgePX(0040555, 2)))
+	for i, f := range files {
+		nm := append([]byte{'N', 'M', byte(5 + len(f.name)), 1, 0}, f.name...)
+		su := append(rockRidgePX(0100444, 1), nm...)
+		root.Write(isoDirRecord([]byte(isoFileID(f.name)), extents[i], uint32(len(f.data)), false, now, su))
+		copy(img[int(extents[i])*isoSectorSize:], f.data)
+	}
+	copy(sector(rootDirSector), root.Bytes())
+
+	// a path table with the root directory as its only entry
+	pathTable := func(order binary.ByteOrder) []byte {
+		e := make([]byte, 10)
+		e[0] = 1
+		order.PutUint32(e[2:], rootDirSector)
+		order.PutUint16(e[6:], 1)
+		return e
+	}
+	copy(sector(lPathSector), pathTable(binary.LittleEndian))
+	copy(sector(mPathSector), pathTable(binary.BigEndian))
+
+	pvd := sector(pvdSector)
+	pvd[0] = 1
+	copy(pvd[1:], iso9660Magic)
+	pvd[6] = 1
+	copy(pvd[8:40], isoPad("", 32))
+	copy(pvd[40:72], isoPad(label, 32))
+	putBoth32(pvd[80:], next)
+	putBoth16(pvd[120:], 1)
+	putBoth16(pvd[124:], 1)
+	putBoth16(pvd[128:], isoSectorSize)
+	putBoth32(pvd[132:], 10)
+	binary.LittleEndian.PutUint32(pvd[140:], lPathSector)
+	binary.BigEndian.PutUint32(pvd[148:], mPathSector)
+	copy(pvd[156:190], isoDirRecord([]byte{0}, rootDirSector, isoSectorSize, true, now, nil))
+	copy(pvd[190:813], isoPad("", 813-190))
+	stamp := now.Format("20060102150405") + "00"
+	copy(pvd[813:], stamp)
+	copy(pvd[830:], stamp)
+	copy(pvd[847:], strings.Repeat("0", 16))
+	copy(pvd[864:], stamp)
+	pvd[881] = 1
+
+	term := sector(pvdSector + 1)
+	term[0] = 255
+	copy(term[1:], iso9660Magic)
+	term[6] = 1
+	return img
+}
+
+// isoDirRecord returns an ISO9660 directory record for id, with systemUse appended
+func isoDirRecord(id []byte, extent, size uint32, dir bool, t time.Time, systemUse []byte) []byte {
+	n := 33 + len(id)
+	if n%2 != 0 {
+		n++
+	}
+	r := make([]byte, n, n+len(systemUse)+1)
+	binary.LittleEndian.PutUint32(r[2:], extent)
+	binary.BigEndian.PutUint32(r[6:], extent)
+	putBoth32(r[10:], size)
+	r[18] = byte(t.Year() - 1900)
+	r[19] = byte(t.Month())
+	r[20] = byte(t.Day())
+	r[21] = byte(t.Hour())
+	r[22] = byte(t.Minute())
+	r[23] = byte(t.Second())
+	if dir {
+		r[25] = 2
+	}
+	putBoth16(r[28:], 1)
+	r[32] = byte(len(id))
+	copy(r[33:], id)
+	r = append(r, systemUse...)
+	if len(r)%2 != 0 {
+		r = append(r, 0)
+	}
+	r[0] = byte(len(r))
+	return r
+}
+
+// rockRidgePX returns a Rock Ridge PX entry, holding the POSIX mode and link count of a file
+// owned by root
+func rockRidgePX(mode, links uint32) []byte {
+	px := make([]byte, 36)
+	copy(px, []byte{'P', 'X', 36, 1})
+	putBoth32(px[4:], mode)
+	putBoth32(px[12:], links)
+	return px
+}
+
+// isoFileID returns the ISO9660 level 1 identifier of name, e.g. USER_DAT.;1 for user-data.
+// Guests read the Rock Ridge name instead.
+func isoFileID(name string) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return id + ".;1"
+}
+
+// isoPad space pads s to n bytes
+func isoPad(s string, n int) []byte {
+	return []byte(s + strings.Repeat(" ", n-len(s)))
+}
+
+// putBoth32 writes v in both byte orders, as ISO9660 does
+func putBoth32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// putBoth16 writes v in both byte orders, as ISO9660 does
+func putBoth16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
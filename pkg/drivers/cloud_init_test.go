@@ -0,0 +1,185 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/iso9660"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+// readSeedISO returns the files in the root directory of iso by their Rock Ridge names
+func readSeedISO(t *testing.T, iso []byte) map[string][]byte {
+	t.Helper()
+	pvd := iso[16*isoSectorSize:]
+	rootExtent := binary.LittleEndian.Uint32(pvd[156+2:])
+	dir := iso[int(rootExtent)*isoSectorSize : int(rootExtent+1)*isoSectorSize]
+	files := map[string][]byte{}
+	for off := 0; off < len(dir) && dir[off] != 0; off += int(dir[off]) {
+		r := dir[off : off+int(dir[off])]
+		if r[25]&2 != 0 {
+			continue
+		}
+		idLen := int(r[32])
+		su := r[33+idLen+(idLen+1)%2:]
+		i := bytes.Index(su, []byte{'N', 'M'})
+		if i < 0 {
+			t.Fatalf("no Rock Ridge name for %q", r[33:33+idLen])
+		}
+		name := string(su[i+5 : i+int(su[i+2])])
+		extent := binary.LittleEndian.Uint32(r[2:])
+		size := binary.LittleEndian.Uint32(r[10:])
+		files[name] = iso[int(extent)*isoSectorSize : int(extent)*isoSectorSize+int(size)]
+	}
+	return files
+}
+
+func TestCreateCloudInitSeed(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	seed := filepath.Join(tmpdir, "seed.iso")
+	userData := []byte("#cloud-config\n" + strings.Repeat("# padding past a sector\n", 100))
+	metaData := []byte("instance-id: minikube\nlocal-hostname: minikube\n")
+
+	if err := CreateCloudInitSeed(seed, userData, metaData); err != nil {
+		t.Fatalf("CreateCloudInitSeed() error = %v", err)
+	}
+	if err := verifyISO(seed); err != nil {
+		t.Fatalf("verifyISO() error = %v", err)
+	}
+	iso, err := ioutil.ReadFile(seed)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if label := strings.TrimRight(string(iso[16*isoSectorSize+40:16*isoSectorSize+72]), " "); label != "CIDATA" {
+		t.Errorf("volume label = %q, want CIDATA", label)
+	}
+	if size := binary.LittleEndian.Uint32(iso[16*isoSectorSize+80:]); int(size)*isoSectorSize != len(iso) {
+		t.Errorf("volume is %d sectors, but the image is %d bytes", size, len(iso))
+	}
+	files := readSeedISO(t, iso)
+	if !bytes.Equal(files["user-data"], userData) {
+		t.Errorf("user-data = %q, want %q", files["user-data"], userData)
+	}
+	if !bytes.Equal(files["meta-data"], metaData) {
+		t.Errorf("meta-data = %q, want %q", files["meta-data"], metaData)
+	}
+}
+
+func TestCloudInitSeedISO9660Reader(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	seed := filepath.Join(tmpdir, "seed.iso")
+	userData := []byte("#cloud-config\n")
+	metaData := []byte("instance-id: minikube\n")
+	if err := CreateCloudInitSeed(seed, userData, metaData); err != nil {
+		t.Fatalf("CreateCloudInitSeed() error = %v", err)
+	}
+
+	// the reader hyperkit extracts the kernel with, which only knows the ISO9660 names
+	f, err := os.Open(seed)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	r, err := iso9660.NewReader(f)
+	if err != nil {
+		t.Fatalf("iso9660.NewReader() error = %v", err)
+	}
+	files := map[string][]byte{}
+	for fi, err := r.Next(); err != io.EOF; fi, err = r.Next() {
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if fi.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadAll(fi.Sys().(io.Reader))
+		if err != nil {
+			t.Fatalf("read %s: %v", fi.Name(), err)
+		}
+		files[fi.Name()] = b
+	}
+	want := map[string][]byte{"/meta_dat.": metaData, "/user_dat.": userData}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+}
+
+func TestCloudInitSeedExternalTools(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	seed := filepath.Join(tmpdir, "seed.iso")
+	userData := []byte("#cloud-config\n" + strings.Repeat("# padding past a sector\n", 100))
+	metaData := []byte("instance-id: minikube\n")
+	if err := CreateCloudInitSeed(seed, userData, metaData); err != nil {
+		t.Fatalf("CreateCloudInitSeed() error = %v", err)
+	}
+
+	// each extracts name from the seed by its Rock Ridge name, as cloud-init sees it once mounted
+	tools := []struct {
+		tool    string
+		extract func(name, dir string) ([]byte, error)
+	}{
+		{"isoinfo", func(name, dir string) ([]byte, error) {
+			return exec.Command("isoinfo", "-R", "-i", seed, "-x", "/"+name).Output()
+		}},
+		{"xorriso", func(name, dir string) ([]byte, error) {
+			if out, err := exec.Command("xorriso", "-osirrox", "on", "-indev", seed, "-extract", "/"+name, filepath.Join(dir, name)).CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("%v: %s", err, out)
+			}
+			return ioutil.ReadFile(filepath.Join(dir, name))
+		}},
+		{"bsdtar", func(name, dir string) ([]byte, error) {
+			if out, err := exec.Command("bsdtar", "-xf", seed, "-C", dir, name).CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("%v: %s", err, out)
+			}
+			return ioutil.ReadFile(filepath.Join(dir, name))
+		}},
+	}
+	for _, tc := range tools {
+		t.Run(tc.tool, func(t *testing.T) {
+			if _, err := exec.LookPath(tc.tool); err != nil {
+				t.Skipf("%s is not installed", tc.tool)
+			}
+			for name, want := range map[string][]byte{"user-data": userData, "meta-data": metaData} {
+				dir, err := ioutil.TempDir(tmpdir, tc.tool)
+				if err != nil {
+					t.Fatalf("tempdir: %v", err)
+				}
+				got, err := tc.extract(name, dir)
+				if err != nil {
+					t.Fatalf("%s couldn't extract %s: %v", tc.tool, name, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s extracted %s = %q, want %q", tc.tool, name, got, want)
+				}
+			}
+		})
+	}
+}
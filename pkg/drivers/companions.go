@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-getter"
+	"github.com/pkg/errors"
+)
+
+// companion is a file a driver needs installed alongside it, such as udev or polkit rules
+type companion struct {
+	// name is the file name to save the companion as in the destination
+	name string
+	// url is where the companion is downloaded from
+	url string
+	// sha256 pins the expected digest. If empty, the checksum published alongside url is used.
+	sha256 string
+}
+
+// driverCompanions are the companion files of each driver, keyed by driver binary name.
+// Add an entry when a driver release starts depending on files it doesn't bundle.
+var driverCompanions = map[string][]companion{}
+
+// downloadCompanions downloads the companion files of driver into destination, verifying each
+// against its checksum and recording that checksum alongside it
+func (dl *Downloader) downloadCompanions(driver, destination string) error {
+	for _, c := range driverCompanions[driver] {
+		want := strings.ToLower(c.sha256)
+		if want == "" {
+			var err error
+			if want, err = fetchChecksum(dl.client, c.url); err != nil {
+				return errors.Wrapf(err, "checksum for %s", c.name)
+			}
+		}
+
+		stage, err := dl.opts.stage(destination)
+		if err != nil {
+			return errors.Wrap(err, "staging directory")
+		}
+		dl.temps = append(dl.temps, stage)
+		staged := filepath.Join(stage, c.name)
+		client := &getter.Client{
+			Src:     InstallOptions{SHA256: want}.pinned(c.url),
+			Dst:     staged,
+			Mode:    getter.ClientModeFile,
+			Getters: getters(dl.client),
+		}
+		if err := client.Get(); err != nil {
+			return errors.Wrapf(err, "can't download %s from: %s", c.name, c.url)
+		}
+
+		target := filepath.Join(destination, c.name)
+		if err := os.Chmod(staged, 0644); err != nil {
+			return errors.Wrap(err, "chmod error")
+		}
+		if err := moveFile(staged, target); err != nil {
+			return errors.Wrapf(err, "move %s into %s", c.name, destination)
+		}
+		if err := writeChecksum(target, want); err != nil {
+			return errors.Wrapf(err, "record checksum of %s", target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestDownloadCompanions(t *testing.T) {
+	const rules = "SUBSYSTEM==\"kvm\", GROUP=\"kvm\"\n"
+	sum := sha256.Sum256([]byte(rules))
+	digest := hex.EncodeToString(sum[:])
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+kvmDriver, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "driver")
+	})
+	mux.HandleFunc("/rules", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rules)
+	})
+	mux.HandleFunc("/rules.sha256", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  rules\n", digest)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	orig := driverKVMDownloadURL
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+	defer func() { driverKVMDownloadURL = orig }()
+	defer allowSmallDrivers()()
+
+	var tcs = []struct {
+		desc      string
+		companion companion
+		install   bool
+		wantErr   bool
+	}{
+		{desc: "published checksum", companion: companion{name: "99-kvm.rules", url: srv.URL + "/rules"}, install: true},
+		{desc: "pinned checksum", companion: companion{name: "99-kvm.rules", url: srv.URL + "/rules", sha256: digest}, install: true},
+		{desc: "checksum mismatch", companion: companion{name: "99-kvm.rules", url: srv.URL + "/rules", sha256: digest[1:] + "0"}, install: true, wantErr: true},
+		{desc: "not requested", companion: companion{name: "99-kvm.rules", url: srv.URL + "/rules"}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			driverCompanions[kvmDriver] = []companion{tc.companion}
+			defer delete(driverCompanions, kvmDriver)
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+
+			err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, Companions: tc.install})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("download() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			target := filepath.Join(tmpdir, tc.companion.name)
+			b, err := ioutil.ReadFile(target)
+			if !tc.install || tc.wantErr {
+				if err == nil {
+					t.Errorf("%s should not have been installed", target)
+				}
+				return
+			}
+			if err != nil || string(b) != rules {
+				t.Fatalf("companion = %q, %v, want %q", b, err, rules)
+			}
+			if _, err := os.Stat(target + checksumSuffix); err != nil {
+				t.Errorf("expected the companion checksum to be recorded: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import "github.com/blang/semver"
+
+// incompatibility is a known-bad combination of driver and minikube versions
+type incompatibility struct {
+	// driver is the driver binary name, e.g. docker-machine-driver-kvm2
+	driver string
+	// driverVersions is the range of affected driver versions
+	driverVersions semver.Range
+	// minikubeVersions is the range of minikube versions they misbehave with
+	minikubeVersions semver.Range
+	// reason explains what goes wrong, for the user
+	reason string
+}
+
+// incompatibilities are the driver and minikube combinations known not to work, even where
+// the driver is new enough for InstallOrUpdate. Add an entry when a release breaks a pairing.
+var incompatibilities = []incompatibility{}
+
+// CheckDriverCompatibility returns false and the reason if driverVer of driver is known not to
+// work with minikubeVer, otherwise true and an empty string.
+func CheckDriverCompatibility(driver string, driverVer, minikubeVer semver.Version) (bool, string) {
+	for _, i := range incompatibilities {
+		if i.driver == driver && i.driverVersions(driverVer) && i.minikubeVersions(minikubeVer) {
+			return false, i.reason
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+)
+
+func TestCheckDriverCompatibility(t *testing.T) {
+	orig := incompatibilities
+	defer func() { incompatibilities = orig }()
+	incompatibilities = []incompatibility{{
+		driver:           kvmDriver,
+		driverVersions:   semver.MustParseRange(">=1.2.0 <1.2.5"),
+		minikubeVersions: semver.MustParseRange(">=1.3.0"),
+		reason:           "the guest fails to boot",
+	}}
+
+	var tcs = []struct {
+		desc        string
+		driver      string
+		driverVer   string
+		minikubeVer string
+		want        bool
+	}{
+		{"known bad", kvmDriver, "1.2.3", "1.3.0", false},
+		{"fixed driver", kvmDriver, "1.2.5", "1.3.0", true},
+		{"older minikube", kvmDriver, "1.2.3", "1.2.0", true},
+		{"other driver", "docker-machine-driver-hyperkit", "1.2.3", "1.3.0", true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			ok, reason := CheckDriverCompatibility(tc.driver, semver.MustParse(tc.driverVer), semver.MustParse(tc.minikubeVer))
+			if ok != tc.want {
+				t.Errorf("CheckDriverCompatibility() = %v, want %v", ok, tc.want)
+			}
+			if ok == (reason != "") {
+				t.Errorf("CheckDriverCompatibility() reason = %q, want one only when incompatible", reason)
+			}
+		})
+	}
+}
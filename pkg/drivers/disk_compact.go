@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// errHolePunchUnsupported means the host can't deallocate a range of a file in place
+var errHolePunchUnsupported = errors.New("punching holes is not supported")
+
+// CompactDisk reclaims the host space taken by blocks the guest has freed in the machine disk,
+// which must not be in use. A qcow2 disk is rewritten by qemu-img into a fresh image that then
+// replaces it. A raw disk has holes punched wherever the guest has TRIMmed or zeroed a whole
+// chunk, or where the host can't punch holes, is rewritten sparsely and swapped in.
+func CompactDisk(d *drivers.BaseDriver) error {
+	diskPath := GetDiskPath(d)
+	_, before, err := DiskStat(diskPath)
+	if err != nil {
+		return errors.Wrap(err, "stat disk")
+	}
+	if diskPath == qcow2DiskPath(d) {
+		err = compactQcow2(diskPath)
+	} else {
+		err = compactRaw(diskPath)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "compact %s", diskPath)
+	}
+	if _, after, err := DiskStat(diskPath); err == nil {
+		glog.Infof("Compacted %s from %d to %d bytes allocated", diskPath, before, after)
+	}
+	return nil
+}
+
+// compactRaw punches holes over the zeroed chunks of the raw disk at path, falling back to rewriteSparse
+func compactRaw(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrap(err, "open disk")
+	}
+	err = punchZeroChunks(f)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		return errors.Wrap(cerr, "close disk")
+	}
+	if err == errHolePunchUnsupported {
+		glog.Infof("%v here, rewriting %s instead", err, path)
+		return rewriteSparse(path)
+	}
+	return err
+}
+
+// punchZeroChunks deallocates each chunk of f that reads as all zeros
+func punchZeroChunks(f *os.File) error {
+	buf := make([]byte, copyChunkSize)
+	for off := int64(0); ; {
+		n, err := f.ReadAt(buf, off)
+		if n > 0 && isZero(buf[:n]) {
+			if perr := punchHole(f, off, int64(n)); perr != nil {
+				return perr
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			return f.Sync()
+		}
+		if err != nil {
+			return errors.Wrap(err, "read disk")
+		}
+	}
+}
+
+// rewriteSparse copies the raw disk at path to a sparse copy, then renames it over the original
+func rewriteSparse(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open disk")
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat disk")
+	}
+	tmp := path + ".compact"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return errors.Wrap(err, "create compacted disk")
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+	if err := sparseCopy(out, in); err != nil {
+		return err
+	}
+	if err := out.Truncate(fi.Size()); err != nil {
+		return errors.Wrap(err, "truncate compacted disk")
+	}
+	if err := out.Sync(); err != nil {
+		return errors.Wrap(err, "sync compacted disk")
+	}
+	if err := out.Close(); err != nil {
+		return errors.Wrapf(err, "closing file %s", tmp)
+	}
+	in.Close()
+	return errors.Wrap(rename(tmp, path), "replace disk")
+}
+
+// compactQcow2 has qemu-img rewrite the qcow2 disk at path without its unused clusters, then
+// renames the result over the original
+func compactQcow2(path string) error {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		return errors.Wrapf(err, "compacting %s disks requires qemu-img", DiskFormatQcow2)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "stat disk")
+	}
+	tmp := path + ".compact"
+	if out, err := exec.Command(qemuImg, "convert", "-f", DiskFormatQcow2, "-O", DiskFormatQcow2, path, tmp).CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "qemu-img convert: %s", out)
+	}
+	if err := os.Chmod(tmp, fi.Mode().Perm()); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "chmod compacted disk")
+	}
+	if err := rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "replace disk")
+	}
+	return nil
+}
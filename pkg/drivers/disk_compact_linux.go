@@ -0,0 +1,37 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	// fallocKeepSize and fallocPunchHole are FALLOC_FL_KEEP_SIZE and FALLOC_FL_PUNCH_HOLE
+	fallocKeepSize  = 0x1
+	fallocPunchHole = 0x2
+)
+
+// punchHole deallocates length bytes of f from off, which then read as zeros
+func punchHole(f *os.File, off, length int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocPunchHole|fallocKeepSize, off, length)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return errHolePunchUnsupported
+	}
+	return err
+}
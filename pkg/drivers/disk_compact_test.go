@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+// writeAt writes n bytes of c to the file at path from off, then syncs it
+func writeAt(t *testing.T, path string, off int64, n int, c byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(bytes.Repeat([]byte{c}, n), off); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+}
+
+func TestCompactDiskRaw(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("allocated sizes are not reported on windows")
+	}
+	for _, tc := range []struct {
+		desc    string
+		compact func(d *drivers.BaseDriver) error
+	}{
+		{desc: "punch", compact: CompactDisk},
+		{desc: "rewrite", compact: func(d *drivers.BaseDriver) error { return rewriteSparse(GetDiskPath(d)) }},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			d, cleanup := newTestDisk(t, 10)
+			defer cleanup()
+			diskPath := GetDiskPath(d)
+			// data the guest keeps, then blocks it has written and since zeroed
+			writeAt(t, diskPath, copyChunkSize, 2*copyChunkSize, 'x')
+			writeAt(t, diskPath, 3*copyChunkSize, 4*copyChunkSize, 'y')
+			writeAt(t, diskPath, 3*copyChunkSize, 4*copyChunkSize, 0)
+			want, err := ioutil.ReadFile(diskPath)
+			if err != nil {
+				t.Fatalf("readfile: %v", err)
+			}
+			_, before, err := DiskStat(diskPath)
+			if err != nil {
+				t.Fatalf("DiskStat() error = %v", err)
+			}
+
+			if err := tc.compact(d); err != nil {
+				t.Fatalf("compact error = %v", err)
+			}
+			apparent, after, err := DiskStat(diskPath)
+			if err != nil {
+				t.Fatalf("DiskStat() error = %v", err)
+			}
+			if after > before-3*copyChunkSize {
+				t.Errorf("allocated %d bytes after compacting, want at most %d", after, before-3*copyChunkSize)
+			}
+			if apparent != int64(len(want)) {
+				t.Errorf("apparent size after compacting = %d, want %d", apparent, len(want))
+			}
+			got, err := ioutil.ReadFile(diskPath)
+			if err != nil {
+				t.Fatalf("readfile: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("compacting changed the contents of the disk")
+			}
+		})
+	}
+}
+
+func TestCompactDiskQcow2(t *testing.T) {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		t.Skip("qemu-img not installed")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	// -S 0 allocates the zeros too, like blocks a guest has freed
+	raw := filepath.Join(tmpdir, "zeros.raw")
+	if err := ioutil.WriteFile(raw, make([]byte, 8*copyChunkSize), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	if out, err := exec.Command(qemuImg, "convert", "-S", "0", "-f", DiskFormatRaw, "-O", DiskFormatQcow2, raw, qcow2DiskPath(d)).CombinedOutput(); err != nil {
+		t.Fatalf("qemu-img convert: %v: %s", err, out)
+	}
+	_, before, err := DiskStat(qcow2DiskPath(d))
+	if err != nil {
+		t.Fatalf("DiskStat() error = %v", err)
+	}
+
+	if err := CompactDisk(d); err != nil {
+		t.Fatalf("CompactDisk() error = %v", err)
+	}
+	_, after, err := DiskStat(qcow2DiskPath(d))
+	if err != nil {
+		t.Fatalf("DiskStat() error = %v", err)
+	}
+	if after >= before {
+		t.Errorf("allocated %d bytes after compacting, want less than %d", after, before)
+	}
+	if out, err := exec.Command(qemuImg, "compare", "-f", DiskFormatRaw, "-F", DiskFormatQcow2, raw, qcow2DiskPath(d)).CombinedOutput(); err != nil {
+		t.Errorf("compacted disk differs: %v: %s", err, out)
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"k8s.io/minikube/pkg/util"
+)
+
+const (
+	// DiskFormatRaw is a plain sparse disk image, as built by MakeDiskImage
+	DiskFormatRaw = "raw"
+	// DiskFormatQcow2 is the QEMU copy-on-write format
+	DiskFormatQcow2 = "qcow2"
+	// DiskFormatVMDK is the VMware disk format
+	DiskFormatVMDK = "vmdk"
+)
+
+// qemuImg is the tool used to produce disk formats other than raw
+const qemuImg = "qemu-img"
+
+// copyChunkSize is the unit in which disks are copied, and in which holes are preserved
+const copyChunkSize = 1024 * 1024
+
+// ExportOptions are the optional settings used when exporting a disk
+type ExportOptions struct {
+	// Passphrase, if set, returns the passphrase to encrypt the exported disk with using LUKS.
+	// Only qcow2 supports encryption. The passphrase is handed to qemu-img over a pipe, and
+	// is never logged or written to disk.
+	Passphrase func() ([]byte, error)
+}
+
+// ExportDisk copies the machine disk to destPath, converting it to format using qemu-img if the
+// machine disk is stored in another format.
+func ExportDisk(d *drivers.BaseDriver, destPath string, format string) error {
+	return ExportDiskWithOptions(d, destPath, format, ExportOptions{})
+}
+
+// ExportDiskWithOptions is ExportDisk with options
+func ExportDiskWithOptions(d *drivers.BaseDriver, destPath string, format string, opts ExportOptions) error {
+	src, srcFormat := GetDiskPath(d), diskFormat(d)
+	if _, err := os.Stat(destPath); err == nil {
+		return errors.Errorf("refusing to overwrite existing file %s", destPath)
+	}
+	if opts.Passphrase != nil && format != DiskFormatQcow2 {
+		return errors.Errorf("encryption is only supported for %s disks, not %s", DiskFormatQcow2, format)
+	}
+	switch format {
+	case DiskFormatRaw, DiskFormatQcow2, DiskFormatVMDK:
+	default:
+		return errors.Errorf("unsupported disk format %q", format)
+	}
+	// qemu-img can only read an encrypted disk with its passphrase, so it can only be copied as is
+	if m, err := ReadDiskMetadata(d); err == nil && m.Encrypted && (format != srcFormat || opts.Passphrase != nil) {
+		return errors.Errorf("%s is encrypted, and can only be exported as %s without a new passphrase", src, srcFormat)
+	}
+
+	switch {
+	case format == srcFormat && opts.Passphrase == nil:
+		glog.Infof("Exporting %s to %s ...", src, destPath)
+		return copyDisk(src, destPath)
+	case opts.Passphrase != nil:
+		return convertEncrypted(src, srcFormat, destPath, opts.Passphrase)
+	default:
+		return convertDisk(src, srcFormat, destPath, format)
+	}
+}
+
+// diskFormat returns the format the machine disk at GetDiskPath is stored in
+func diskFormat(d *drivers.BaseDriver) string {
+	if GetDiskPath(d) == qcow2DiskPath(d) {
+		return DiskFormatQcow2
+	}
+	return DiskFormatRaw
+}
+
+// SupportedDiskFormats returns the formats ExportDisk can produce on this host, so that callers can
+// validate a requested format up front. Raw is always supported; the others require qemu-img.
+func SupportedDiskFormats() []string {
+	formats := []string{DiskFormatRaw}
+	if _, err := exec.LookPath(qemuImg); err == nil {
+		formats = append(formats, DiskFormatQcow2, DiskFormatVMDK)
+	}
+	return formats
+}
+
+// WriteDiskTo streams the machine disk to w with progress, e.g. to upload it to a remote
+// hypervisor over SSH or HTTP, and returns the number of bytes written. The disk is written as
+// it is stored, which is DiskFormatQcow2 for a growable disk, and raw otherwise, with holes
+// written out as zeros. It is an error if fewer bytes than the size of the disk reach w.
+func WriteDiskTo(d *drivers.BaseDriver, w io.Writer) (int64, error) {
+	src := GetDiskPath(d)
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, errors.Wrap(err, "open disk")
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return 0, errors.Wrap(err, "stat disk")
+	}
+
+	glog.Infof("Streaming %s (%s, %d bytes) ...", src, diskFormat(d), fi.Size())
+	r := util.DefaultProgressBar.TrackProgress(src, 0, fi.Size(), in)
+	defer r.Close()
+	n, err := io.CopyBuffer(w, r, make([]byte, copyChunkSize))
+	if err != nil {
+		return n, errors.Wrapf(err, "streaming %s", src)
+	}
+	if n != fi.Size() {
+		return n, errors.Errorf("streamed %d bytes of %s, want %d", n, src, fi.Size())
+	}
+	return n, nil
+}
+
+// copyDisk copies a disk from src to dst byte for byte with progress, preserving holes, then verifies the copy
+func copyDisk(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "open source")
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat source")
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "create destination")
+	}
+	defer out.Close()
+
+	srcHash := sha256.New()
+	r := util.DefaultProgressBar.TrackProgress(src, 0, fi.Size(), in)
+	defer r.Close()
+	if err := sparseCopy(out, io.TeeReader(r, srcHash)); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Truncate(fi.Size()); err != nil {
+		return errors.Wrap(err, "truncate destination")
+	}
+	if err := out.Close(); err != nil {
+		return errors.Wrapf(err, "closing file %s", dst)
+	}
+
+	dstSum, err := fileSHA256(dst)
+	if err != nil {
+		return errors.Wrap(err, "hash destination")
+	}
+	if !bytes.Equal(srcHash.Sum(nil), dstSum) {
+		os.Remove(dst)
+		return errors.Errorf("verification failed: %s does not match %s", dst, src)
+	}
+	return nil
+}
+
+// sparseCopy copies r to w, seeking over all-zero chunks rather than writing them
+func sparseCopy(w *os.File, r io.Reader) error {
+	buf := make([]byte, copyChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if isZero(buf[:n]) {
+				if _, serr := w.Seek(int64(n), io.SeekCurrent); serr != nil {
+					return errors.Wrap(serr, "seek")
+				}
+			} else if _, werr := w.Write(buf[:n]); werr != nil {
+				return errors.Wrap(werr, "write")
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read")
+		}
+	}
+}
+
+// isZero returns whether b contains only zero bytes
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// fileSHA256 returns the sha256 digest of the file at path
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// convertDisk converts the disk at src, stored as srcFormat, to format at dst using qemu-img,
+// then verifies the contents match
+func convertDisk(src, srcFormat, dst, format string) error {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		return errors.Wrapf(err, "exporting %s to %s requires qemu-img", srcFormat, format)
+	}
+	glog.Infof("Converting %s (%s) to %s (%s) ...", src, srcFormat, dst, format)
+	if out, err := exec.Command(qemuImg, "convert", "-p", "-f", srcFormat, "-O", format, src, dst).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "qemu-img convert: %s", out)
+	}
+	if out, err := exec.Command(qemuImg, "compare", "-f", srcFormat, "-F", format, src, dst).CombinedOutput(); err != nil {
+		os.Remove(dst)
+		return errors.Wrapf(err, "verification failed: %s", out)
+	}
+	return nil
+}
+
+// convertEncrypted converts the disk at src, stored as srcFormat, to a LUKS encrypted qcow2 disk at
+// dst using qemu-img. The result can't be compared against src without the passphrase, so it is
+// not verified.
+func convertEncrypted(src, srcFormat, dst string, passphrase func() ([]byte, error)) error {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		return errors.Wrapf(err, "exporting to %s requires qemu-img", DiskFormatQcow2)
+	}
+	glog.Infof("Converting %s to encrypted %s (%s) ...", src, dst, DiskFormatQcow2)
+	if out, err := qemuImgWithSecret(passphrase, "convert", "-f", srcFormat, "-O", DiskFormatQcow2,
+		"--object", qemuSecretObject,
+		"-o", qemuLUKSOptions,
+		src, dst); err != nil {
+		os.Remove(dst)
+		return errors.Wrapf(err, "qemu-img convert: %s", out)
+	}
+	return nil
+}
+
+const (
+	// qemuSecretObject defines the secret sec0, read by qemu-img from the pipe qemuImgWithSecret passes it
+	qemuSecretObject = "secret,id=sec0,file=/dev/fd/3"
+	// qemuLUKSOptions encrypts a new qcow2 image with LUKS, keyed by the secret sec0
+	qemuLUKSOptions = "encrypt.format=luks,encrypt.key-secret=sec0"
+)
+
+// qemuImgWithSecret runs qemu-img with args, handing it the passphrase as fd 3 over an inherited
+// pipe rather than on the command line, so that it is never logged or written to disk. It
+// returns the combined output of qemu-img.
+func qemuImgWithSecret(passphrase func() ([]byte, error), args ...string) ([]byte, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("encrypted disks are not supported on windows")
+	}
+	secret, err := passphrase()
+	if err != nil {
+		return nil, errors.Wrap(err, "get passphrase")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "pipe")
+	}
+	defer r.Close()
+	// The first of ExtraFiles is fd 3 in the child
+	cmd := exec.Command(qemuImg, args...)
+	cmd.ExtraFiles = []*os.File{r}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	_, werr := w.Write(secret)
+	w.Close()
+	if err := cmd.Wait(); err != nil {
+		return output.Bytes(), err
+	}
+	if werr != nil {
+		return output.Bytes(), errors.Wrap(werr, "pass passphrase to qemu-img")
+	}
+	return output.Bytes(), nil
+}
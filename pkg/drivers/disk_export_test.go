@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+// newTestDisk creates a machine with a raw disk of sizeMb under a temp store path
+func newTestDisk(t *testing.T, sizeMb int) (*drivers.BaseDriver, func()) {
+	t.Helper()
+	tmpdir := tests.MakeTempDir()
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	sshPath := filepath.Join(tmpdir, "ssh")
+	if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	if err := createRawDiskImage(sshPath, GetDiskPath(d), sizeMb, 0, nil); err != nil {
+		t.Fatalf("createRawDiskImage() error = %v", err)
+	}
+	return d, func() { os.RemoveAll(tmpdir) }
+}
+
+func TestExportDiskRaw(t *testing.T) {
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+
+	// Some data past the tar, to check that it survives among the holes
+	f, err := os.OpenFile(GetDiskPath(d), os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("guest data"), 3*copyChunkSize+7); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	f.Close()
+
+	dest := filepath.Join(d.StorePath, "export.raw")
+	if err := ExportDisk(d, dest, DiskFormatRaw); err != nil {
+		t.Fatalf("ExportDisk() error = %v", err)
+	}
+	want, err := ioutil.ReadFile(GetDiskPath(d))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("exported disk differs from the source (%d vs %d bytes)", len(got), len(want))
+	}
+
+	if err := ExportDisk(d, dest, DiskFormatRaw); err == nil {
+		t.Error("ExportDisk() expected an error when the destination exists")
+	}
+	if err := ExportDisk(d, filepath.Join(d.StorePath, "export.bad"), "bogus"); err == nil {
+		t.Error("ExportDisk() expected an error for an unsupported format")
+	}
+}
+
+func TestSupportedDiskFormats(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub qemu-img is a shell script")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	origPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", origPath)
+	os.Setenv("PATH", tmpdir)
+
+	if got, want := SupportedDiskFormats(), []string{DiskFormatRaw}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SupportedDiskFormats() without qemu-img = %v, want %v", got, want)
+	}
+
+	writeStubDriver(t, tmpdir, qemuImg, "exit 0")
+	if got, want := SupportedDiskFormats(), []string{DiskFormatRaw, DiskFormatQcow2, DiskFormatVMDK}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SupportedDiskFormats() with qemu-img = %v, want %v", got, want)
+	}
+}
+
+func TestExportDiskQcow2Source(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub qemu-img is a shell script")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	qcow2 := []byte("QFI\xfb a growable disk")
+	if err := ioutil.WriteFile(qcow2DiskPath(d), qcow2, 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	// the stub records its arguments, and creates the file it converts to
+	bin := filepath.Join(tmpdir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	log := filepath.Join(tmpdir, "qemu-img.log")
+	writeStubDriver(t, bin, qemuImg, `echo "$1 $2 $3 $4 $5 $6" >> `+log+`
+if [ "$1" = convert ]; then for a; do last=$a; done; : > "$last"; fi`)
+	origPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", origPath)
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+origPath)
+
+	if err := ExportDisk(d, filepath.Join(tmpdir, "export.qcow2"), DiskFormatQcow2); err != nil {
+		t.Fatalf("ExportDisk() to %s error = %v", DiskFormatQcow2, err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(tmpdir, "export.qcow2")); err != nil || !bytes.Equal(got, qcow2) {
+		t.Errorf("ExportDisk() to the same format = %q, %v; want a copy of the disk", got, err)
+	}
+	for _, format := range []string{DiskFormatRaw, DiskFormatVMDK} {
+		if err := ExportDisk(d, filepath.Join(tmpdir, "export."+format), format); err != nil {
+			t.Fatalf("ExportDisk() to %s error = %v", format, err)
+		}
+	}
+	got, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	want := "convert -p -f qcow2 -O raw\ncompare -f qcow2 -F raw " + qcow2DiskPath(d) + "\n" +
+		"convert -p -f qcow2 -O vmdk\ncompare -f qcow2 -F vmdk " + qcow2DiskPath(d) + "\n"
+	if string(got) != want {
+		t.Errorf("qemu-img was run as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestExportDiskEncrypted(t *testing.T) {
+	d, cleanup := newTestDisk(t, 10)
+	defer cleanup()
+	passphrase := func() ([]byte, error) { return []byte("correct horse battery staple"), nil }
+
+	opts := ExportOptions{Passphrase: passphrase}
+	if err := ExportDiskWithOptions(d, filepath.Join(d.StorePath, "disk.raw"), DiskFormatRaw, opts); err == nil {
+		t.Error("ExportDiskWithOptions() expected an error encrypting a raw disk")
+	}
+
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		t.Skip("qemu-img is not installed")
+	}
+	dest := filepath.Join(d.StorePath, "disk.qcow2")
+	if err := ExportDiskWithOptions(d, dest, DiskFormatQcow2, opts); err != nil {
+		t.Fatalf("ExportDiskWithOptions() error = %v", err)
+	}
+	out, err := exec.Command(qemuImg, "info", "--output=json", dest).Output()
+	if err != nil {
+		t.Fatalf("qemu-img info: %v", err)
+	}
+	var info struct {
+		Encrypted bool `json:"encrypted"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		t.Fatalf("parse qemu-img info: %v", err)
+	}
+	if !info.Encrypted {
+		t.Errorf("exported disk is not encrypted: %s", out)
+	}
+}
+
+func TestWriteDiskTo(t *testing.T) {
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+
+	buf := new(bytes.Buffer)
+	n, err := WriteDiskTo(d, buf)
+	if err != nil {
+		t.Fatalf("WriteDiskTo() error = %v", err)
+	}
+	want, err := ioutil.ReadFile(GetDiskPath(d))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteDiskTo() wrote %d bytes, want the %d bytes of the disk", n, len(want))
+	}
+
+	missing := &drivers.BaseDriver{MachineName: "missing", StorePath: d.StorePath}
+	if _, err := WriteDiskTo(missing, ioutil.Discard); err == nil {
+		t.Error("WriteDiskTo() of a missing disk succeeded")
+	}
+}
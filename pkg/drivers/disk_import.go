@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// minDiskImageSize is the smallest plausible disk image: a tar header plus its end-of-archive marker
+const minDiskImageSize = 3 * 512
+
+// DetectDiskFormat returns the format of the disk image at path, based on its header
+func DetectDiskFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 32)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", errors.Wrap(err, "read header")
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte("QFI\xfb")):
+		return DiskFormatQcow2, nil
+	case bytes.HasPrefix(header, []byte("KDMV")), bytes.HasPrefix(header, []byte("# Disk DescriptorFile")):
+		return DiskFormatVMDK, nil
+	default:
+		return DiskFormatRaw, nil
+	}
+}
+
+// ImportDisk seeds a machine with an existing raw disk image rather than building a new one.
+func ImportDisk(d *drivers.BaseDriver, srcPath string) error {
+	fi, err := os.Stat(srcPath)
+	if err != nil {
+		return errors.Wrap(err, "stat source")
+	}
+	if !fi.Mode().IsRegular() {
+		return errors.Errorf("%s is not a regular file", srcPath)
+	}
+	if fi.Size() < minDiskImageSize {
+		return errors.Errorf("%s is only %d bytes, too small to be a disk image", srcPath, fi.Size())
+	}
+	format, err := DetectDiskFormat(srcPath)
+	if err != nil {
+		return errors.Wrap(err, "detect format")
+	}
+	if format != DiskFormatRaw {
+		return errors.Errorf("%s is a %s image, but only %s images can be imported", srcPath, format, DiskFormatRaw)
+	}
+
+	diskPath := GetDiskPath(d)
+	exists, err := DiskExists(d)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.Errorf("machine disk %s already exists", diskPath)
+	}
+	machPath := d.ResolveStorePath(".")
+	if err := os.MkdirAll(machPath, 0700); err != nil {
+		return errors.Wrap(err, "create machine dir")
+	}
+
+	glog.Infof("Importing %s to %s ...", srcPath, diskPath)
+	if err := copyDisk(srcPath, diskPath); err != nil {
+		return errors.Wrap(err, "copy disk")
+	}
+	if err := os.Chmod(diskPath, 0644); err != nil {
+		return errors.Wrap(err, "chmod disk")
+	}
+	uid, gid := DiskImageOptions{}.ownership()
+	if err := fixPermissions(machPath, uid, gid, PermissionsDefault); err != nil {
+		return errors.Wrapf(err, "fixing permissions on %s", machPath)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestImportDisk(t *testing.T) {
+	src, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+
+	d := &drivers.BaseDriver{MachineName: "imported", StorePath: src.StorePath}
+	if err := ImportDisk(d, GetDiskPath(src)); err != nil {
+		t.Fatalf("ImportDisk() error = %v", err)
+	}
+	fi, err := os.Stat(GetDiskPath(d))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Size() != 5*bytesPerMB {
+		t.Errorf("imported disk size = %d, want %d", fi.Size(), 5*bytesPerMB)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0644 {
+		t.Errorf("imported disk mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0644))
+	}
+
+	if err := ImportDisk(d, GetDiskPath(src)); err == nil {
+		t.Error("ImportDisk() expected an error when the machine disk exists")
+	}
+}
+
+func TestImportDiskRejectsFormat(t *testing.T) {
+	src, cleanup := newTestDisk(t, 1)
+	defer cleanup()
+
+	qcow := filepath.Join(src.StorePath, "disk.qcow2")
+	header := make([]byte, minDiskImageSize)
+	copy(header, "QFI\xfb")
+	if err := ioutil.WriteFile(qcow, header, 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	d := &drivers.BaseDriver{MachineName: "imported", StorePath: src.StorePath}
+	if err := ImportDisk(d, qcow); err == nil {
+		t.Error("ImportDisk() expected an error for a qcow2 image")
+	}
+	if _, err := os.Stat(GetDiskPath(d)); !os.IsNotExist(err) {
+		t.Errorf("expected no disk to be imported, stat error = %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// diskExtensions maps the file extensions of machine disk images to their format
+var diskExtensions = map[string]string{
+	".rawdisk": DiskFormatRaw,
+	".raw":     DiskFormatRaw,
+	".qcow2":   DiskFormatQcow2,
+	".vmdk":    DiskFormatVMDK,
+}
+
+// DiskInfo describes a disk image in a store path
+type DiskInfo struct {
+	// MachineName is the machine the disk belongs to
+	MachineName string
+	// Path is the location of the disk image
+	Path string
+	// Format is the disk format, derived from the file extension
+	Format string
+	// ApparentSize is the size of the disk as seen by the guest, in bytes
+	ApparentSize int64
+	// AllocatedSize is the space the disk takes up on the host, in bytes, which is smaller for sparse disks
+	AllocatedSize int64
+}
+
+// ListDiskImages returns the disk images of every machine under storePath
+func ListDiskImages(storePath string) ([]DiskInfo, error) {
+	machinesDir := filepath.Join(storePath, "machines")
+	machines, err := ioutil.ReadDir(machinesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", machinesDir)
+	}
+
+	var disks []DiskInfo
+	for _, m := range machines {
+		if !m.IsDir() {
+			continue
+		}
+		dir := filepath.Join(machinesDir, m.Name())
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", dir)
+		}
+		for _, f := range files {
+			format, ok := diskExtensions[strings.ToLower(filepath.Ext(f.Name()))]
+			if !ok && f.Name() == diskFilename(m.Name()) {
+				format, ok = DiskFormatRaw, true
+			}
+			if !ok || !f.Mode().IsRegular() {
+				continue
+			}
+			disks = append(disks, DiskInfo{
+				MachineName:   m.Name(),
+				Path:          filepath.Join(dir, f.Name()),
+				Format:        format,
+				ApparentSize:  f.Size(),
+				AllocatedSize: allocatedSize(f),
+			})
+		}
+	}
+	return disks, nil
+}
+
+// TotalDiskFootprint returns the combined apparent and allocated sizes of the disk images of
+// every machine under storePath, in bytes
+func TotalDiskFootprint(storePath string) (apparent, allocated int64, err error) {
+	disks, err := ListDiskImages(storePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, d := range disks {
+		a, al, err := DiskStat(d.Path)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "stat %s", d.Path)
+		}
+		apparent += a
+		allocated += al
+	}
+	return apparent, allocated, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestListDiskImages(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	// A sparse raw disk, a qcow2 image from an export, and files that are not disks
+	files := map[string]int64{
+		"machines/minikube/minikube.rawdisk": 10 * bytesPerMB,
+		"machines/minikube/boot2docker.iso":  2048,
+		"machines/minikube/id_rsa":           1024,
+		"machines/other/other.qcow2":         4096,
+	}
+	for name, size := range files {
+		path := filepath.Join(tmpdir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("writefile: %v", err)
+		}
+		if err := os.Truncate(path, size); err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+	}
+
+	disks, err := ListDiskImages(tmpdir)
+	if err != nil {
+		t.Fatalf("ListDiskImages() error = %v", err)
+	}
+	var got []DiskInfo
+	for _, d := range disks {
+		if d.AllocatedSize <= 0 {
+			t.Errorf("%s allocated size = %d, want > 0", d.Path, d.AllocatedSize)
+		}
+		if runtime.GOOS != "windows" && d.Format == DiskFormatRaw && d.AllocatedSize >= d.ApparentSize {
+			t.Errorf("%s allocated size = %d, want less than the %d of a sparse disk", d.Path, d.AllocatedSize, d.ApparentSize)
+		}
+		d.AllocatedSize = 0
+		got = append(got, d)
+	}
+	want := []DiskInfo{
+		{MachineName: "minikube", Path: filepath.Join(tmpdir, "machines/minikube/minikube.rawdisk"), Format: DiskFormatRaw, ApparentSize: 10 * bytesPerMB},
+		{MachineName: "other", Path: filepath.Join(tmpdir, "machines/other/other.qcow2"), Format: DiskFormatQcow2, ApparentSize: 4096},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListDiskImages() = %+v, want %+v", got, want)
+	}
+
+	if disks, err := ListDiskImages(filepath.Join(tmpdir, "missing")); err != nil || len(disks) != 0 {
+		t.Errorf("ListDiskImages() of a missing store = %v, %v; want none", disks, err)
+	}
+}
+
+func TestTotalDiskFootprint(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	// sparse disks, so that only the written byte is allocated
+	sizes := map[string]int64{
+		"machines/one/one.rawdisk":       10 * bytesPerMB,
+		"machines/two/two.rawdisk":       20 * bytesPerMB,
+		"machines/three/three.qcow2":     4096,
+		"machines/three/boot2docker.iso": 2048,
+	}
+	for name, size := range sizes {
+		path := filepath.Join(tmpdir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("writefile: %v", err)
+		}
+		if err := os.Truncate(path, size); err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+	}
+
+	apparent, allocated, err := TotalDiskFootprint(tmpdir)
+	if err != nil {
+		t.Fatalf("TotalDiskFootprint() error = %v", err)
+	}
+	if want := int64(30*bytesPerMB + 4096); apparent != want {
+		t.Errorf("apparent = %d, want %d", apparent, want)
+	}
+	if allocated <= 0 || allocated > apparent {
+		t.Errorf("allocated = %d, want between 0 and %d", allocated, apparent)
+	}
+
+	if apparent, allocated, err := TotalDiskFootprint(filepath.Join(tmpdir, "empty")); err != nil || apparent != 0 || allocated != 0 {
+		t.Errorf("TotalDiskFootprint() of an empty store = %d, %d, %v, want 0, 0, nil", apparent, allocated, err)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// DiskMetadata records how a machine disk was built, for drivers to consume
+type DiskMetadata struct {
+	// SectorSize is the logical sector size the guest expects, in bytes
+	SectorSize int `json:"sectorSize"`
+	// GuestGrownSize is the disk size, in bytes, that the guest filesystem was last grown to fill.
+	// Zero if unknown, e.g. for disks built before it was recorded.
+	GuestGrownSize int64 `json:"guestGrownSize,omitempty"`
+	// Size is the disk size, in bytes, that minikube last built or grew the disk to.
+	// Zero if unknown, e.g. for disks built before it was recorded.
+	Size int64 `json:"size,omitempty"`
+	// Format is the disk image format, DiskFormatRaw or DiskFormatQcow2
+	Format string `json:"format,omitempty"`
+	// ISOVersion is the version of the boot ISO the disk was built for, e.g. v1.3.0, if its URL names one
+	ISOVersion string `json:"isoVersion,omitempty"`
+	// CreatedAt is when the disk was built
+	CreatedAt time.Time `json:"createdAt"`
+	// SwapSize is how much of the disk, in bytes, is set aside for guest swap, if any
+	SwapSize int64 `json:"swapSize,omitempty"`
+	// Encrypted is whether the disk is encrypted at rest. The passphrase is not recorded.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// isoVersionRE matches the version in a release ISO name, e.g. minikube-v1.3.0.iso
+var isoVersionRE = regexp.MustCompile(`v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?`)
+
+// isoVersion returns the version named by the file in isoURL, or "" if it names none
+func isoVersion(isoURL string) string {
+	name := path.Base(isoURL)
+	return isoVersionRE.FindString(strings.TrimSuffix(name, path.Ext(name)))
+}
+
+// diskMetadataPath returns where the metadata of the machine disk is kept
+func diskMetadataPath(d *drivers.BaseDriver) string {
+	return GetDiskPath(d) + ".json"
+}
+
+// ReadDiskMetadata returns the metadata recorded when the machine disk was built
+func ReadDiskMetadata(d *drivers.BaseDriver) (DiskMetadata, error) {
+	return readMetadataFile(diskMetadataPath(d))
+}
+
+// readMetadataFile reads disk metadata from path
+func readMetadataFile(path string) (DiskMetadata, error) {
+	var m DiskMetadata
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return m, errors.Wrap(err, "read disk metadata")
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, errors.Wrap(err, "parse disk metadata")
+	}
+	return m, nil
+}
+
+// writeDiskMetadata records m alongside the machine disk
+func writeDiskMetadata(d *drivers.BaseDriver, m DiskMetadata) error {
+	return writeMetadataFile(diskMetadataPath(d), m)
+}
+
+// writeMetadataFile writes disk metadata to path
+func writeMetadataFile(path string, m DiskMetadata) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// NeedsFilesystemGrow returns whether the machine's disk has grown since the guest filesystem was
+// last grown to fill it, e.g. after ResizeDiskImage, so that growpart should be run in the guest.
+// Disks without a recorded guest size are assumed not to need it, as are qcow2 disks, which
+// minikube never resizes.
+func NeedsFilesystemGrow(d *drivers.BaseDriver) (bool, error) {
+	m, err := ReadDiskMetadata(d)
+	if err != nil {
+		return false, err
+	}
+	if m.GuestGrownSize == 0 || diskFormat(d) != DiskFormatRaw {
+		return false, nil
+	}
+	apparent, _, err := DiskStat(GetDiskPath(d))
+	if err != nil {
+		return false, errors.Wrap(err, "stat disk")
+	}
+	return apparent > m.GuestGrownSize, nil
+}
+
+// RecordFilesystemGrown records that the guest filesystem now fills the machine's disk
+func RecordFilesystemGrown(d *drivers.BaseDriver) error {
+	m, err := ReadDiskMetadata(d)
+	if err != nil {
+		return err
+	}
+	// the size of a qcow2 file is not the size the guest sees, which was recorded when it was made
+	if diskFormat(d) != DiskFormatRaw {
+		return nil
+	}
+	if m.GuestGrownSize, _, err = DiskStat(GetDiskPath(d)); err != nil {
+		return errors.Wrap(err, "stat disk")
+	}
+	return writeDiskMetadata(d, m)
+}
+
+// recordDiskSize records the machine disk's current size as the one minikube sized it to, if it
+// has metadata
+func recordDiskSize(d *drivers.BaseDriver) error {
+	m, err := ReadDiskMetadata(d)
+	if err != nil {
+		return nil
+	}
+	if m.Size, _, err = DiskStat(GetDiskPath(d)); err != nil {
+		return errors.Wrap(err, "stat disk")
+	}
+	return writeDiskMetadata(d, m)
+}
+
+// checkRecordedSize warns if the machine disk is no longer the size minikube last made it
+func checkRecordedSize(d *drivers.BaseDriver, currentSize int64) {
+	m, err := ReadDiskMetadata(d)
+	if err != nil || m.Size == 0 || m.Size == currentSize {
+		return
+	}
+	glog.Warningf("Disk %s is %d bytes, but was built as %d bytes: it was resized outside of minikube", GetDiskPath(d), currentSize, m.Size)
+}
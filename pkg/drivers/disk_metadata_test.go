@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestDiskMetadataRoundTrip(t *testing.T) {
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+	want := DiskMetadata{
+		SectorSize:     4096,
+		GuestGrownSize: 5 * bytesPerMB,
+		Size:           5 * bytesPerMB,
+		Format:         DiskFormatRaw,
+		ISOVersion:     "v1.3.0",
+		CreatedAt:      time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := writeDiskMetadata(d, want); err != nil {
+		t.Fatalf("writeDiskMetadata() error = %v", err)
+	}
+	got, err := ReadDiskMetadata(d)
+	if err != nil {
+		t.Fatalf("ReadDiskMetadata() error = %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+	got.CreatedAt = want.CreatedAt
+	if got != want {
+		t.Errorf("ReadDiskMetadata() = %+v, want %+v", got, want)
+	}
+}
+
+func TestMakeDiskImageRecordsMetadata(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	iso := filepath.Join(tmpdir, "minikube-v1.3.0.iso")
+	writeISOFixture(t, iso)
+
+	before := time.Now()
+	sizeMb := defaultMinDiskSizeMB + 1
+	if err := MakeDiskImageContext(context.Background(), d, "file://"+iso, sizeMb, DiskImageOptions{}); err != nil {
+		t.Fatalf("MakeDiskImageContext() error = %v", err)
+	}
+	m, err := ReadDiskMetadata(d)
+	if err != nil {
+		t.Fatalf("ReadDiskMetadata() error = %v", err)
+	}
+	if m.Size != int64(sizeMb)*bytesPerMB || m.Format != DiskFormatRaw || m.ISOVersion != "v1.3.0" || m.CreatedAt.Before(before) {
+		t.Errorf("recorded metadata = %+v, want a %dMB %s disk for v1.3.0 created after %v", m, sizeMb, DiskFormatRaw, before)
+	}
+
+	// growing the existing disk records its new size
+	if err := MakeDiskImageContext(context.Background(), d, "file://"+iso, sizeMb+1, DiskImageOptions{GrowExisting: true}); err != nil {
+		t.Fatalf("MakeDiskImageContext() error = %v", err)
+	}
+	if m, err = ReadDiskMetadata(d); err != nil {
+		t.Fatalf("ReadDiskMetadata() error = %v", err)
+	}
+	if m.Size != int64(sizeMb+1)*bytesPerMB {
+		t.Errorf("recorded size after growing = %d, want %d", m.Size, int64(sizeMb+1)*bytesPerMB)
+	}
+}
+
+func TestISOVersion(t *testing.T) {
+	var tcs = []struct {
+		url  string
+		want string
+	}{
+		{url: "https://storage.googleapis.com/minikube/iso/minikube-v1.3.0.iso", want: "v1.3.0"},
+		{url: "https://example.com/minikube-v1.4.0-beta.1.iso", want: "v1.4.0-beta.1"},
+		{url: "https://example.com/v1.3.0/boot2docker.iso", want: ""},
+		{url: "file:///tmp/fixture.iso", want: ""},
+	}
+	for _, tc := range tcs {
+		if got := isoVersion(tc.url); got != tc.want {
+			t.Errorf("isoVersion(%q) = %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
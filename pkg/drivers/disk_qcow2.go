@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/pkg/errors"
+)
+
+// qcow2DiskPath returns the path of the machine disk when it is a growable qcow2 image
+func qcow2DiskPath(d *drivers.BaseDriver) string {
+	raw := diskFilename(d.GetMachineName())
+	return filepath.Join(d.ResolveStorePath("."), strings.TrimSuffix(raw, filepath.Ext(raw))+".qcow2")
+}
+
+// createQcow2DiskImage makes a thin provisioned qcow2 disk at diskPath that the guest sees as
+// diskSizeMb, but that only takes up the space the guest has written on the host.
+func createQcow2DiskImage(ctx context.Context, sshKeyPath, diskPath string, diskSizeMb, sectorSize int, extraAuthorizedKeys []string, swapSizeMb int, hostname string) error {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		return errors.Wrapf(err, "%s disks require qemu-img", DiskFormatQcow2)
+	}
+
+	// Lay out the boot2docker tar in a small raw disk, then convert and grow it to the cap
+	raw := diskPath + ".tmp"
+	if err := createFilledDiskImage(ctx, sshKeyPath, raw, 1, 0, extraAuthorizedKeys, swapSizeMb, hostname, nil); err != nil {
+		return err
+	}
+	defer os.Remove(raw)
+	if out, err := exec.CommandContext(ctx, qemuImg, "convert", "-f", DiskFormatRaw, "-O", DiskFormatQcow2, raw, diskPath).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "qemu-img convert: %s", out)
+	}
+	size := strconv.FormatInt(diskSizeBytes(diskSizeMb, sectorSize), 10)
+	if out, err := exec.CommandContext(ctx, qemuImg, "resize", "-f", DiskFormatQcow2, diskPath, size).CombinedOutput(); err != nil {
+		os.Remove(diskPath)
+		return errors.Wrapf(err, "qemu-img resize: %s", out)
+	}
+	return nil
+}
+
+// createEncryptedQcow2DiskImage is createQcow2DiskImage for a disk encrypted at rest with LUKS,
+// using the passphrase returned by passphrase. The disk is created encrypted at its full size,
+// then the boot2docker tar is written over its start.
+func createEncryptedQcow2DiskImage(ctx context.Context, sshKeyPath, diskPath string, diskSizeMb, sectorSize int, extraAuthorizedKeys []string, swapSizeMb int, hostname string, passphrase func() ([]byte, error)) error {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		return errors.Wrapf(err, "%s disks require qemu-img", DiskFormatQcow2)
+	}
+
+	size := strconv.FormatInt(diskSizeBytes(diskSizeMb, sectorSize), 10)
+	if out, err := qemuImgWithSecret(passphrase, "create", "-f", DiskFormatQcow2,
+		"--object", qemuSecretObject,
+		"-o", qemuLUKSOptions,
+		diskPath, size); err != nil {
+		os.Remove(diskPath)
+		return errors.Wrapf(err, "qemu-img create: %s", out)
+	}
+
+	raw := diskPath + ".tmp"
+	if err := createFilledDiskImage(ctx, sshKeyPath, raw, 1, 0, extraAuthorizedKeys, swapSizeMb, hostname, nil); err != nil {
+		os.Remove(diskPath)
+		return err
+	}
+	defer os.Remove(raw)
+	if out, err := qemuImgWithSecret(passphrase, "convert", "-n", "-f", DiskFormatRaw,
+		"--object", qemuSecretObject,
+		"--target-image-opts", raw,
+		"driver="+DiskFormatQcow2+",file.filename="+strings.Replace(diskPath, ",", ",,", -1)+",encrypt.key-secret=sec0"); err != nil {
+		os.Remove(diskPath)
+		return errors.Wrapf(err, "qemu-img convert: %s", out)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestMakeDiskImageQcow2(t *testing.T) {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		t.Skip("qemu-img is not installed")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+
+	sizeMb := 20000
+	opts := DiskImageOptions{Format: DiskFormatQcow2}
+	if err := MakeDiskImageContext(context.Background(), d, "file://"+iso, sizeMb, opts); err != nil {
+		t.Fatalf("MakeDiskImageContext() error = %v", err)
+	}
+	if got, want := GetDiskPath(d), qcow2DiskPath(d); got != want {
+		t.Errorf("GetDiskPath() = %s, want %s", got, want)
+	}
+
+	out, err := exec.Command(qemuImg, "info", "--output=json", GetDiskPath(d)).Output()
+	if err != nil {
+		t.Fatalf("qemu-img info: %v", err)
+	}
+	var info struct {
+		Format      string `json:"format"`
+		VirtualSize int64  `json:"virtual-size"`
+		ActualSize  int64  `json:"actual-size"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		t.Fatalf("parse qemu-img info: %v", err)
+	}
+	if info.Format != DiskFormatQcow2 {
+		t.Errorf("disk format = %s, want %s", info.Format, DiskFormatQcow2)
+	}
+	if want := int64(sizeMb) * bytesPerMB; info.VirtualSize != want {
+		t.Errorf("virtual size = %d, want the cap of %d", info.VirtualSize, want)
+	}
+	if info.ActualSize > 10*bytesPerMB {
+		t.Errorf("actual size = %d, want a thin provisioned disk", info.ActualSize)
+	}
+}
+
+func TestMakeDiskImageExistingQcow2(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	qcow2 := []byte("QFI\xfb a growable disk")
+	if err := ioutil.WriteFile(qcow2DiskPath(d), qcow2, 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+
+	// without a format, the existing disk must not be taken for a raw one and resized
+	opts := DiskImageOptions{GrowExisting: true}
+	if err := MakeDiskImageContext(context.Background(), d, "file://"+iso, 20000, opts); err != nil {
+		t.Fatalf("MakeDiskImageContext() error = %v", err)
+	}
+	if got, err := ioutil.ReadFile(qcow2DiskPath(d)); err != nil || string(got) != string(qcow2) {
+		t.Errorf("existing %s disk = %q, %v; want it left as it was", DiskFormatQcow2, got, err)
+	}
+}
+
+func TestCreateQcow2DiskImageNoQemuImg(t *testing.T) {
+	defer func(p string) { os.Setenv("PATH", p) }(os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	diskPath := filepath.Join(tmpdir, "minikube.qcow2")
+	if err := createQcow2DiskImage(context.Background(), filepath.Join(tmpdir, "id_rsa.pub"), diskPath, 1000, 0, nil, 0, ""); err == nil {
+		t.Fatal("createQcow2DiskImage() succeeded without qemu-img")
+	}
+	if _, err := os.Stat(diskPath); !os.IsNotExist(err) {
+		t.Errorf("disk left behind after failure: %v", err)
+	}
+}
+
+func TestMakeDiskImageEncryptedUnsupported(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	passphrase := func() ([]byte, error) { return []byte("correct horse battery staple"), nil }
+
+	for _, opts := range []DiskImageOptions{
+		{Passphrase: passphrase},
+		{Passphrase: passphrase, Format: DiskFormatRaw},
+	} {
+		if err := MakeDiskImageContext(context.Background(), d, "file:///fixture.iso", 20000, opts); err == nil {
+			t.Errorf("MakeDiskImageContext() with format %q expected an error encrypting", opts.Format)
+		}
+	}
+}
+
+func TestMakeDiskImageEncryptedArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub qemu-img is a shell script")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+
+	// The stub records its arguments and the secret it is handed, and creates the disk
+	bin := filepath.Join(tmpdir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	argsLog, secretLog := filepath.Join(tmpdir, "args"), filepath.Join(tmpdir, "secret")
+	writeStubDriver(t, bin, qemuImg, `echo "$@" >> `+argsLog+`
+cat <&3 >> `+secretLog+`
+if [ "$1" = create ]; then eval "disk=\${$(($# - 1))}"; : > "$disk"; fi`)
+	defer func(p string) { os.Setenv("PATH", p) }(os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	secret := "correct horse battery staple"
+	opts := DiskImageOptions{Format: DiskFormatQcow2, Passphrase: func() ([]byte, error) { return []byte(secret), nil }}
+	if err := MakeDiskImageContext(context.Background(), d, "file://"+iso, 20000, opts); err != nil {
+		t.Fatalf("MakeDiskImageContext() error = %v", err)
+	}
+
+	args, err := ioutil.ReadFile(argsLog)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	create := "create -f qcow2 --object " + qemuSecretObject + " -o " + qemuLUKSOptions + " " + GetDiskPath(d) + " " + strconv.FormatInt(20000*bytesPerMB, 10)
+	if !strings.Contains(string(args), create) {
+		t.Errorf("qemu-img was run with:\n%s\nwant a call to %q", args, create)
+	}
+	if strings.Contains(string(args), secret) {
+		t.Errorf("the passphrase was passed to qemu-img on the command line:\n%s", args)
+	}
+	got, err := ioutil.ReadFile(secretLog)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if want := secret + secret; string(got) != want {
+		t.Errorf("qemu-img was handed the secrets %q, want %q", got, want)
+	}
+
+	m, err := ReadDiskMetadata(d)
+	if err != nil {
+		t.Fatalf("ReadDiskMetadata() error = %v", err)
+	}
+	if !m.Encrypted {
+		t.Errorf("recorded metadata = %+v, want an encrypted disk", m)
+	}
+	if b, err := ioutil.ReadFile(diskMetadataPath(d)); err != nil || strings.Contains(string(b), secret) {
+		t.Errorf("disk metadata contains the passphrase, or can't be read: %v", err)
+	}
+}
+
+func TestMakeDiskImageEncrypted(t *testing.T) {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		t.Skip("qemu-img is not installed")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("encrypted disks are not supported on windows")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+
+	opts := DiskImageOptions{Format: DiskFormatQcow2, Passphrase: func() ([]byte, error) { return []byte("correct horse battery staple"), nil }}
+	if err := MakeDiskImageContext(context.Background(), d, "file://"+iso, 20000, opts); err != nil {
+		t.Fatalf("MakeDiskImageContext() error = %v", err)
+	}
+	out, err := exec.Command(qemuImg, "info", "--output=json", GetDiskPath(d)).Output()
+	if err != nil {
+		t.Fatalf("qemu-img info: %v", err)
+	}
+	var info struct {
+		Encrypted bool `json:"encrypted"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		t.Fatalf("parse qemu-img info: %v", err)
+	}
+	if !info.Encrypted {
+		t.Errorf("disk is not encrypted: %s", out)
+	}
+}
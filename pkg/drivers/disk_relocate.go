@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// rename renames files within a filesystem, replaceable for testing
+var rename = os.Rename
+
+// RelocateDisk moves the machine directory, with the disk image, its metadata, the ISO and the
+// ssh keys, to the same place under newStorePath, then points d at newStorePath. A directory on
+// another filesystem is copied, each file verified, before the original is removed. An existing
+// machine at the target is never overwritten.
+func RelocateDisk(d *drivers.BaseDriver, newStorePath string) error {
+	src := GetDiskPath(d)
+	if _, err := os.Stat(src); err != nil {
+		return errors.Wrap(err, "stat disk")
+	}
+	srcMach := d.ResolveStorePath(".")
+	moved := *d
+	moved.StorePath = newStorePath
+	machPath := moved.ResolveStorePath(".")
+	if entries, err := ioutil.ReadDir(machPath); err == nil && len(entries) > 0 {
+		return errors.Errorf("machine directory %s already exists", machPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(machPath), 0700); err != nil {
+		return errors.Wrap(err, "create machines dir")
+	}
+	// an empty directory left at the target would stop the rename
+	os.Remove(machPath)
+
+	glog.Infof("Relocating %s to %s ...", srcMach, machPath)
+	if err := relocateDir(srcMach, machPath); err != nil {
+		return errors.Wrapf(err, "relocate %s", srcMach)
+	}
+	uid, gid := DiskImageOptions{}.ownership()
+	if err := fixPermissions(machPath, uid, gid, PermissionsDefault); err != nil {
+		return errors.Wrapf(err, "fixing permissions on %s", machPath)
+	}
+	// GetSSHKeyPath remembers where the key was, so follow it if it lived in the machine directory
+	if rel, err := filepath.Rel(srcMach, d.SSHKeyPath); err == nil && d.SSHKeyPath != "" && !strings.HasPrefix(rel, "..") {
+		d.SSHKeyPath = filepath.Join(machPath, rel)
+	}
+	d.StorePath = newStorePath
+	return nil
+}
+
+// relocateDir moves the directory src to dst, falling back to copying each file when a rename
+// isn't possible. If the copy fails, dst is removed and src is left as it was.
+func relocateDir(src, dst string) error {
+	if err := rename(src, dst); err == nil {
+		return nil
+	}
+	glog.Infof("%s can't be renamed into place, copying it instead", src)
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode().IsRegular():
+			if err := copyDisk(path, target); err != nil {
+				return errors.Wrapf(err, "copy %s", path)
+			}
+			return os.Chmod(target, fi.Mode().Perm())
+		default:
+			return errors.Errorf("%s is not a regular file", path)
+		}
+	})
+	if err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestRelocateDisk(t *testing.T) {
+	var tests = []struct {
+		description string
+		rename      func(string, string) error
+	}{
+		{description: "same filesystem", rename: os.Rename},
+		{
+			description: "cross filesystem",
+			rename: func(string, string) error {
+				return &os.LinkError{Op: "rename", Err: syscall.EXDEV}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			rename = test.rename
+			defer func() { rename = os.Rename }()
+			d, cleanup := newTestDisk(t, 5)
+			defer cleanup()
+			src := GetDiskPath(d)
+			want, err := fileSHA256(src)
+			if err != nil {
+				t.Fatalf("hash disk: %v", err)
+			}
+			newStorePath := filepath.Join(d.StorePath, "relocated")
+			// the rest of the machine must move with the disk
+			oldMach := d.ResolveStorePath(".")
+			if err := ioutil.WriteFile(d.ResolveStorePath("id_rsa"), []byte("private key"), 0600); err != nil {
+				t.Fatalf("writefile: %v", err)
+			}
+			if err := ioutil.WriteFile(d.ResolveStorePath("boot2docker.iso"), []byte("iso"), 0644); err != nil {
+				t.Fatalf("writefile: %v", err)
+			}
+			d.GetSSHKeyPath()
+
+			if err := RelocateDisk(d, newStorePath); err != nil {
+				t.Fatalf("RelocateDisk() error = %v", err)
+			}
+			if d.StorePath != newStorePath {
+				t.Errorf("StorePath = %s, want %s", d.StorePath, newStorePath)
+			}
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("original disk still exists: %v", err)
+			}
+			got, err := fileSHA256(GetDiskPath(d))
+			if err != nil {
+				t.Fatalf("hash relocated disk: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("relocated disk doesn't match the original")
+			}
+			if _, err := os.Stat(oldMach); !os.IsNotExist(err) {
+				t.Errorf("original machine directory still exists: %v", err)
+			}
+			if _, err := os.Stat(d.ResolveStorePath("boot2docker.iso")); err != nil {
+				t.Errorf("ISO was not relocated: %v", err)
+			}
+			if got, want := d.GetSSHKeyPath(), d.ResolveStorePath("id_rsa"); got != want {
+				t.Errorf("GetSSHKeyPath() = %s, want %s", got, want)
+			}
+			fi, err := os.Stat(d.GetSSHKeyPath())
+			if err != nil {
+				t.Fatalf("ssh key was not relocated: %v", err)
+			}
+			if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+				t.Errorf("relocated ssh key mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+			}
+		})
+	}
+}
+
+func TestRelocateDiskTargetExists(t *testing.T) {
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+	newStorePath := filepath.Join(d.StorePath, "relocated")
+	existing := *d
+	existing.StorePath = newStorePath
+	if err := os.MkdirAll(existing.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := ioutil.WriteFile(GetDiskPath(&existing), []byte("other disk"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	if err := RelocateDisk(d, newStorePath); err == nil {
+		t.Fatal("RelocateDisk() over an existing disk succeeded")
+	}
+	if _, err := os.Stat(GetDiskPath(d)); err != nil {
+		t.Errorf("original disk should be left in place: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(GetDiskPath(&existing)); string(b) != "other disk" {
+		t.Error("existing disk was overwritten")
+	}
+}
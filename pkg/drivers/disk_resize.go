@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// DiskStat returns the apparent size of the disk image at path, as the guest sees a raw disk,
+// and the space it takes up on the host, which is smaller for sparse disks
+func DiskStat(path string) (apparent, allocated int64, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return fi.Size(), allocatedSize(fi), nil
+}
+
+// ResizeResult reports the size of a disk before and after ResizeDiskImage, in bytes
+type ResizeResult struct {
+	// OldApparent is the size the guest saw before the resize
+	OldApparent int64
+	// NewApparent is the size the guest sees after the resize
+	NewApparent int64
+	// OldAllocated is the space the disk took up on the host before the resize
+	OldAllocated int64
+	// NewAllocated is the space the disk takes up on the host after the resize
+	NewAllocated int64
+}
+
+// ResizeDiskImage grows the machine's raw disk to diskSizeMb, keeping it sparse, and reports
+// the sizes before and after. Disks can't be shrunk, as that would lose guest data.
+func ResizeDiskImage(d *drivers.BaseDriver, diskSizeMb int) (ResizeResult, error) {
+	var r ResizeResult
+	diskPath := GetDiskPath(d)
+	if diskPath == qcow2DiskPath(d) {
+		return r, errors.Errorf("%s is a %s disk, only %s disks can be resized", diskPath, DiskFormatQcow2, DiskFormatRaw)
+	}
+	var err error
+	if r.OldApparent, r.OldAllocated, err = DiskStat(diskPath); err != nil {
+		return r, errors.Wrap(err, "stat disk")
+	}
+
+	// round to the sector size the disk was built with, if it was recorded
+	sectorSize := 0
+	if m, err := ReadDiskMetadata(d); err == nil {
+		sectorSize = m.SectorSize
+	}
+	wantSize := diskSizeBytes(diskSizeMb, sectorSize)
+	if wantSize < r.OldApparent {
+		return r, errors.Errorf("can't shrink %s from %dMB to %dMB", diskPath, r.OldApparent/bytesPerMB, diskSizeMb)
+	}
+	if wantSize > r.OldApparent {
+		glog.Infof("Growing disk %s from %d to %d bytes", diskPath, r.OldApparent, wantSize)
+		if err := os.Truncate(diskPath, wantSize); err != nil {
+			return r, errors.Wrap(err, "grow disk")
+		}
+		if err := recordDiskSize(d); err != nil {
+			return r, errors.Wrap(err, "record disk size")
+		}
+	}
+	if r.NewApparent, r.NewAllocated, err = DiskStat(diskPath); err != nil {
+		return r, errors.Wrap(err, "stat disk")
+	}
+	return r, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import "testing"
+
+func TestResizeDiskImage(t *testing.T) {
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+
+	r, err := ResizeDiskImage(d, 20)
+	if err != nil {
+		t.Fatalf("ResizeDiskImage() error = %v", err)
+	}
+	if r.OldApparent != 5*bytesPerMB || r.NewApparent != 20*bytesPerMB {
+		t.Errorf("apparent size went from %d to %d, want %d to %d", r.OldApparent, r.NewApparent, 5*bytesPerMB, 20*bytesPerMB)
+	}
+	// growing only extends the hole at the end of the disk, where sparse files are supported
+	if r.OldAllocated < r.OldApparent && r.NewAllocated != r.OldAllocated {
+		t.Errorf("allocated size went from %d to %d, want it unchanged", r.OldAllocated, r.NewAllocated)
+	}
+
+	if _, err := ResizeDiskImage(d, 10); err == nil {
+		t.Error("ResizeDiskImage() expected an error shrinking the disk")
+	}
+}
+
+func TestNeedsFilesystemGrow(t *testing.T) {
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+	if err := writeDiskMetadata(d, DiskMetadata{GuestGrownSize: 5 * bytesPerMB}); err != nil {
+		t.Fatalf("writeDiskMetadata() error = %v", err)
+	}
+	if grow, err := NeedsFilesystemGrow(d); err != nil || grow {
+		t.Errorf("NeedsFilesystemGrow() of a fresh disk = %v, %v, want false", grow, err)
+	}
+
+	if _, err := ResizeDiskImage(d, 20); err != nil {
+		t.Fatalf("ResizeDiskImage() error = %v", err)
+	}
+	if grow, err := NeedsFilesystemGrow(d); err != nil || !grow {
+		t.Errorf("NeedsFilesystemGrow() with a stale guest size = %v, %v, want true", grow, err)
+	}
+
+	if err := RecordFilesystemGrown(d); err != nil {
+		t.Fatalf("RecordFilesystemGrown() error = %v", err)
+	}
+	if grow, err := NeedsFilesystemGrow(d); err != nil || grow {
+		t.Errorf("NeedsFilesystemGrow() after growing = %v, %v, want false", grow, err)
+	}
+}
@@ -0,0 +1,72 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"syscall"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"k8s.io/minikube/pkg/minikube/out"
+)
+
+// minFreeInodes is how many inodes creating a machine disk needs, with some headroom
+const minFreeInodes = 16
+
+// minFreeBytes is the space needed to write the start of a new disk, before it is grown sparsely
+const minFreeBytes = 1000000
+
+// statfs reports filesystem usage, replaceable for testing
+var statfs = syscall.Statfs
+
+// checkFreeSpace reports which resource is exhausted if the filesystem holding dir can't fit a
+// new disk, as a full inode table otherwise surfaces as a confusing ENOSPC despite free space.
+func checkFreeSpace(dir string, diskSizeMb int) error {
+	var st syscall.Statfs_t
+	if err := statfs(dir, &st); err != nil {
+		glog.Warningf("unable to check free space on %s: %v", dir, err)
+		return nil
+	}
+	if st.Ffree < minFreeInodes {
+		return errors.Errorf("no free inodes on the filesystem holding %s (%d left), though there may be free space", dir, st.Ffree)
+	}
+	free := uint64(st.Bavail) * uint64(st.Bsize)
+	if free < minFreeBytes {
+		return errors.Errorf("no free space on the filesystem holding %s (%d bytes left)", dir, free)
+	}
+	if free < uint64(diskSizeMb)*bytesPerMB {
+		out.WarningT("Only {{.free}}MB is free for the {{.size}}MB disk, which may fill up the host", out.V{"free": free / bytesPerMB, "size": diskSizeMb})
+	}
+	return nil
+}
+
+// checkDownloadSpace returns an error if the filesystem holding dir has less than need bytes free
+func checkDownloadSpace(dir string, need int64, log Logger) error {
+	var st syscall.Statfs_t
+	if err := statfs(dir, &st); err != nil {
+		log.Warningf("unable to check free space on %s: %v", dir, err)
+		return nil
+	}
+	free := uint64(st.Bavail) * uint64(st.Bsize)
+	if free < uint64(need) {
+		return errors.Errorf("not enough free space to download to %s: %d bytes needed, %d bytes available", dir, need, free)
+	}
+	return nil
+}
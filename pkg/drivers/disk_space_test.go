@@ -0,0 +1,93 @@
+// +build !windows
+
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	orig := statfs
+	defer func() { statfs = orig }()
+
+	// the Statfs_t field types differ between platforms, so each case sets them from constants
+	var tcs = []struct {
+		desc    string
+		set     func(st *syscall.Statfs_t)
+		wantErr string
+	}{
+		{desc: "plenty", set: func(st *syscall.Statfs_t) { st.Ffree, st.Bavail = 1000, 1<<20 }},
+		{desc: "low inodes", set: func(st *syscall.Statfs_t) { st.Ffree, st.Bavail = 3, 1<<20 }, wantErr: "inodes"},
+		{desc: "low space", set: func(st *syscall.Statfs_t) { st.Ffree, st.Bavail = 1000, 1 }, wantErr: "space"},
+		// sparse disks are allowed to exceed the free space, with a warning
+		{desc: "less than disk size", set: func(st *syscall.Statfs_t) { st.Ffree, st.Bavail = 1000, 1000 }},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			statfs = func(path string, st *syscall.Statfs_t) error {
+				tc.set(st)
+				st.Bsize = 4096
+				return nil
+			}
+			err := checkFreeSpace("/machines/minikube", 20000)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkFreeSpace() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("checkFreeSpace() error = %v, want one mentioning %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownloadFreeSpaceMargin(t *testing.T) {
+	_, cleanup := serveDriver(t, "#!/bin/sh\n")
+	defer cleanup()
+	orig := statfs
+	defer func() { statfs = orig }()
+	statfs = func(path string, st *syscall.Statfs_t) error {
+		st.Ffree = 1000
+		st.Bavail = 100
+		st.Bsize = 4096
+		return nil
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true})
+	if err == nil || !strings.Contains(err.Error(), "10000010 bytes needed, 409600 bytes available") {
+		t.Fatalf("download() with a 10MB margin and 400KB free error = %v, want the needed and available bytes", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, kvmDriver)); !os.IsNotExist(err) {
+		t.Errorf("expected no driver after running out of space, stat error = %v", err)
+	}
+
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, FreeSpaceMargin: 4096}); err != nil {
+		t.Errorf("download() with a 4KB margin error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// b2dMagicString tells the boot2docker automount script to format the disk and grow it to fill the device
+const b2dMagicString = "boot2docker, please format-me"
+
+// swapMarkerName is the tar entry holding the size of swap the guest init should activate, e.g. "1024M"
+const swapMarkerName = "swap"
+
+// hostnameFileName is the tar entry holding the hostname the guest init should set, in the
+// format of /etc/hostname
+const hostnameFileName = "hostname"
+
+// writeDiskTar streams the tar that boot2docker expects at the start of its raw disk to w.
+// The layout matches mcnutils.MakeDiskImage, but never shells out to a tar binary.
+// extraAuthorizedKeys are authorized alongside the machine key, and must already be validated.
+// If swapSizeMb is set, a swap marker asks the guest to set aside that much of the disk for swap.
+// If hostname is set, it must already be validated, and the guest init names the VM after it.
+func writeDiskTar(w io.Writer, publicSSHKeyPath string, extraAuthorizedKeys []string, swapSizeMb int, hostname string) error {
+	pubKey, err := ioutil.ReadFile(publicSSHKeyPath)
+	if err != nil {
+		return errors.Wrap(err, "read public key")
+	}
+	authorized := authorizedKeysContent(pubKey, extraAuthorizedKeys)
+
+	tw := tar.NewWriter(w)
+
+	// The magic string must come first, so that the automount script knows to format the disk
+	if err := writeTarFile(tw, b2dMagicString, 0, []byte(b2dMagicString)); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: ".ssh", Typeflag: tar.TypeDir, Mode: 0700}); err != nil {
+		return errors.Wrap(err, "write .ssh header")
+	}
+	if err := writeTarFile(tw, ".ssh/authorized_keys", 0644, authorized); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, ".ssh/authorized_keys2", 0644, authorized); err != nil {
+		return err
+	}
+	if swapSizeMb > 0 {
+		if err := writeTarFile(tw, swapMarkerName, 0644, []byte(fmt.Sprintf("%dM\n", swapSizeMb))); err != nil {
+			return err
+		}
+	}
+	if hostname != "" {
+		if err := writeTarFile(tw, hostnameFileName, 0644, []byte(hostname+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "close tar")
+	}
+	return nil
+}
+
+// authorizedKeysContent returns the authorized_keys file for the machine key followed by extra keys
+func authorizedKeysContent(pubKey []byte, extra []string) []byte {
+	if len(extra) == 0 {
+		return pubKey
+	}
+	content := append([]byte{}, pubKey...)
+	for _, k := range extra {
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			content = append(content, '\n')
+		}
+		content = append(content, strings.TrimSpace(k)...)
+	}
+	return append(content, '\n')
+}
+
+// writeTarFile writes a regular file entry to tw
+func writeTarFile(tw *tar.Writer, name string, mode int64, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(content))}); err != nil {
+		return errors.Wrapf(err, "write %s header", name)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return errors.Wrapf(err, "write %s", name)
+	}
+	return nil
+}
+
+// LayoutValidator inspects the tar at the start of a freshly built disk, returning an error
+// if a layout assumption that the guest relies on does not hold.
+type LayoutValidator func(r io.Reader) error
+
+// ValidateB2DLayout checks for the marker that makes boot2docker format the disk and grow
+// its filesystem to fill it. Without it, custom ISOs may leave most of the disk unusable.
+func ValidateB2DLayout(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return errors.Errorf("%q marker not found, the guest may not grow its filesystem to fill the disk", b2dMagicString)
+		}
+		if err != nil {
+			return errors.Wrap(err, "read tar")
+		}
+		if h.Name == b2dMagicString {
+			return nil
+		}
+	}
+}
+
+// validateDiskLayout runs validate against the tar at the start of the disk at diskPath
+func validateDiskLayout(diskPath string, validate LayoutValidator) error {
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer f.Close()
+	return validate(f)
+}
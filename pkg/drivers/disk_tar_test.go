@@ -0,0 +1,298 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/mcnutils"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+// tarEntry is the subset of a tar entry that boot2docker cares about
+type tarEntry struct {
+	Name     string
+	Typeflag byte
+	Mode     int64
+	Content  string
+}
+
+// readTarEntries returns the entries of the tar stream in r
+func readTarEntries(t *testing.T, r io.Reader) []tarEntry {
+	t.Helper()
+	var entries []tarEntry
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("tar next: %v", err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("tar read: %v", err)
+		}
+		entries = append(entries, tarEntry{Name: h.Name, Typeflag: h.Typeflag, Mode: h.Mode, Content: string(b)})
+	}
+}
+
+func TestWriteDiskTar(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	sshPath := filepath.Join(tmpdir, "ssh")
+	if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeDiskTar(buf, sshPath, nil, 0, ""); err != nil {
+		t.Fatalf("writeDiskTar() error = %v", err)
+	}
+
+	// boot2docker has only ever been handed the tar mcnutils.MakeDiskImage produces
+	golden, err := mcnutils.MakeDiskImage(sshPath)
+	if err != nil {
+		t.Fatalf("mcnutils.MakeDiskImage() error = %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden.Bytes()) {
+		t.Errorf("writeDiskTar() entries = %+v, want the mcnutils.MakeDiskImage tar %+v", readTarEntries(t, buf), readTarEntries(t, golden))
+	}
+}
+
+// maxDiskTarOverhead is what writeDiskTar may allocate beyond its one copy of the key file
+const maxDiskTarOverhead = 32 * 1024
+
+func TestWriteDiskTarAllocs(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	for _, size := range []int{1024, 64 * 1024, 1024 * 1024} {
+		sshPath := filepath.Join(tmpdir, fmt.Sprintf("ssh-%d", size))
+		if err := ioutil.WriteFile(sshPath, bytes.Repeat([]byte("k"), size), 0644); err != nil {
+			t.Fatalf("writefile: %v", err)
+		}
+		write := func() {
+			if err := writeDiskTar(ioutil.Discard, sshPath, nil, 0, ""); err != nil {
+				t.Fatalf("writeDiskTar() error = %v", err)
+			}
+		}
+
+		// the tar is streamed, so only the key is held in memory, however big it is
+		if allocs := testing.AllocsPerRun(10, write); allocs > 40 {
+			t.Errorf("writeDiskTar() of a %d byte key made %v allocations, want at most 40", size, allocs)
+		}
+		const runs = 10
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		for i := 0; i < runs; i++ {
+			write()
+		}
+		runtime.ReadMemStats(&after)
+		if perRun := int((after.TotalAlloc - before.TotalAlloc) / runs); perRun > size+maxDiskTarOverhead {
+			t.Errorf("writeDiskTar() of a %d byte key allocated %d bytes, want at most %d", size, perRun, size+maxDiskTarOverhead)
+		}
+	}
+}
+
+func TestValidateB2DLayout(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	sshPath := filepath.Join(tmpdir, "ssh")
+	if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	diskPath := filepath.Join(tmpdir, "disk")
+	if err := createRawDiskImage(sshPath, diskPath, 10, 0, nil); err != nil {
+		t.Fatalf("createRawDiskImage() error = %v", err)
+	}
+	if err := validateDiskLayout(diskPath, ValidateB2DLayout); err != nil {
+		t.Errorf("validateDiskLayout() error = %v, want nil", err)
+	}
+
+	// A custom layout without the format marker
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := writeTarFile(tw, ".ssh/authorized_keys", 0644, []byte("mysshkey")); err != nil {
+		t.Fatalf("writeTarFile: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	customPath := filepath.Join(tmpdir, "custom")
+	if err := ioutil.WriteFile(customPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	if err := validateDiskLayout(customPath, ValidateB2DLayout); err == nil {
+		t.Error("validateDiskLayout() expected an error for a disk without the format marker")
+	}
+}
+
+func TestExtraAuthorizedKeys(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	var keys []string
+	for _, name := range []string{"machine", "alice", "bob"} {
+		path := filepath.Join(tmpdir, name)
+		if err := generateSSHKey(path, DefaultSSHKeyBits, ""); err != nil {
+			t.Fatalf("generateSSHKey() error = %v", err)
+		}
+		b, err := ioutil.ReadFile(path + ".pub")
+		if err != nil {
+			t.Fatalf("readfile: %v", err)
+		}
+		key := strings.TrimSpace(string(b))
+		if name != "machine" {
+			key += " " + name
+		}
+		keys = append(keys, key)
+	}
+	extra := keys[1:]
+	if err := validateAuthorizedKeys(extra); err != nil {
+		t.Fatalf("validateAuthorizedKeys() error = %v", err)
+	}
+
+	diskPath := filepath.Join(tmpdir, "disk")
+	if err := createRawDiskImage(filepath.Join(tmpdir, "machine.pub"), diskPath, 10, 0, extra); err != nil {
+		t.Fatalf("createRawDiskImage() error = %v", err)
+	}
+	f, err := os.Open(diskPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	var found int
+	for _, e := range readTarEntries(t, f) {
+		if e.Name != ".ssh/authorized_keys" && e.Name != ".ssh/authorized_keys2" {
+			continue
+		}
+		found++
+		lines := strings.Split(strings.TrimSpace(e.Content), "\n")
+		if len(lines) != len(keys) {
+			t.Fatalf("%s has %d keys, want %d:\n%s", e.Name, len(lines), len(keys), e.Content)
+		}
+		for i, k := range keys {
+			if lines[i] != k {
+				t.Errorf("%s line %d = %q, want %q", e.Name, i, lines[i], k)
+			}
+		}
+	}
+	if found != 2 {
+		t.Errorf("found %d authorized_keys files, want 2", found)
+	}
+
+	for _, bad := range []string{"not a key", keys[1] + "\n" + keys[2]} {
+		if err := validateAuthorizedKeys([]string{bad}); err == nil {
+			t.Errorf("validateAuthorizedKeys(%q) expected an error", bad)
+		}
+	}
+}
+
+// createRawDiskImage used to go through mcnutils.MakeDiskImage, which could fail with errors the
+// user can't act on. The disk is now built in-process, so it must not need any external tools.
+func TestDiskImageHostname(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	sshPath := filepath.Join(tmpdir, "ssh")
+	if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	diskPath := filepath.Join(tmpdir, "disk")
+	if err := createFilledDiskImage(context.Background(), sshPath, diskPath, 10, 0, nil, 0, "minikube-m02", nil); err != nil {
+		t.Fatalf("createFilledDiskImage() error = %v", err)
+	}
+	f, err := os.Open(diskPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	var found []tarEntry
+	for _, e := range readTarEntries(t, f) {
+		if e.Name == "hostname" {
+			found = append(found, e)
+		}
+	}
+	want := []tarEntry{{Name: "hostname", Typeflag: tar.TypeReg, Mode: 0644, Content: "minikube-m02\n"}}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("hostname entries = %+v, want %+v", found, want)
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	var tcs = []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "minikube"},
+		{name: "minikube-m02"},
+		{name: "Node1.cluster.local"},
+		{name: "0node"},
+		{name: strings.Repeat("a", 63)},
+		{name: "", wantErr: true},
+		{name: "-node", wantErr: true},
+		{name: "node-", wantErr: true},
+		{name: "node_1", wantErr: true},
+		{name: "node 1", wantErr: true},
+		{name: "node..local", wantErr: true},
+		{name: "node.", wantErr: true},
+		{name: "node\nevil", wantErr: true},
+		{name: strings.Repeat("a", 64), wantErr: true},
+		{name: strings.Repeat("a.", 127) + "a", wantErr: true},
+	}
+	for _, tc := range tcs {
+		if err := validateHostname(tc.name); (err != nil) != tc.wantErr {
+			t.Errorf("validateHostname(%q) error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCreateRawDiskImageWithoutTools(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", tmpdir)
+
+	sshPath := filepath.Join(tmpdir, "ssh")
+	if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	diskPath := filepath.Join(tmpdir, "disk")
+	if err := createRawDiskImage(sshPath, diskPath, 10, 0, nil); err != nil {
+		t.Fatalf("createRawDiskImage() with an empty PATH error = %v", err)
+	}
+	if err := validateDiskLayout(diskPath, ValidateB2DLayout); err != nil {
+		t.Errorf("validateDiskLayout() error = %v, want nil", err)
+	}
+}
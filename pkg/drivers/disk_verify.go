@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// VerifyDiskImage checks that the disk at diskPath in format is intact, so that a bad write is
+// caught before the guest fails to boot. Raw disks must start with the boot2docker tar and be
+// the size recorded in their metadata, or at least the minimum disk size if they have none;
+// other formats are checked with qemu-img.
+func VerifyDiskImage(diskPath string, format string) error {
+	switch format {
+	case DiskFormatRaw:
+		if err := verifyRawDisk(diskPath); err != nil {
+			return errors.Wrapf(err, "disk %s is corrupt, delete the machine to recreate it", diskPath)
+		}
+		return nil
+	case DiskFormatQcow2, DiskFormatVMDK:
+		if _, err := exec.LookPath(qemuImg); err != nil {
+			return errors.Wrapf(err, "verifying %s disks requires qemu-img", format)
+		}
+		if out, err := exec.Command(qemuImg, "check", "-f", format, diskPath).CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "disk %s is corrupt, qemu-img check: %s", diskPath, out)
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported disk format %q", format)
+	}
+}
+
+// verifyRawDisk checks the boot2docker tar at the start of the raw disk at diskPath, and its size
+// against the metadata recorded alongside it
+func verifyRawDisk(diskPath string) error {
+	f, err := os.Open(diskPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	var entries, keys int
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "read tar header")
+		}
+		if entries == 0 && h.Name != b2dMagicString {
+			return errors.Errorf("first entry is %q, not the %q marker", h.Name, b2dMagicString)
+		}
+		if h.Name == ".ssh/authorized_keys" {
+			keys++
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return errors.Wrapf(err, "read %s", h.Name)
+		}
+		entries++
+	}
+	if entries == 0 {
+		return errors.New("no boot2docker tar at the start of the disk")
+	}
+	if keys == 0 {
+		return errors.New("no authorized ssh keys in the disk")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if m, err := readMetadataFile(diskPath + ".json"); err == nil && m.Size > 0 {
+		if fi.Size() != m.Size {
+			return errors.Errorf("disk is %d bytes, but was built as %d bytes, it may have been truncated or resized outside of minikube", fi.Size(), m.Size)
+		}
+		return nil
+	}
+	// without metadata the built size is unknown, so fall back to the usual minimum
+	if min := int64(defaultMinDiskSizeMB) * bytesPerMB; fi.Size() < min {
+		return errors.Errorf("disk is %d bytes, less than the minimum of %d, it may have been truncated", fi.Size(), min)
+	}
+	return nil
+}
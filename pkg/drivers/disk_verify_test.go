@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestVerifyDiskImage(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	sshPath := filepath.Join(tmpdir, "ssh")
+	if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	newDisk := func(name string) string {
+		path := filepath.Join(tmpdir, name)
+		if err := createRawDiskImage(sshPath, path, defaultMinDiskSizeMB, 0, nil); err != nil {
+			t.Fatalf("createRawDiskImage() error = %v", err)
+		}
+		return path
+	}
+
+	if err := VerifyDiskImage(newDisk("good"), DiskFormatRaw); err != nil {
+		t.Errorf("VerifyDiskImage() of a good disk error = %v", err)
+	}
+
+	corrupt := newDisk("corrupt")
+	f, err := os.OpenFile(corrupt, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("garbage"), 0); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	f.Close()
+	if err := VerifyDiskImage(corrupt, DiskFormatRaw); err == nil {
+		t.Error("VerifyDiskImage() expected an error for a disk with a corrupt header")
+	}
+
+	truncated := newDisk("truncated")
+	if err := os.Truncate(truncated, bytesPerMB); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if err := VerifyDiskImage(truncated, DiskFormatRaw); err == nil {
+		t.Error("VerifyDiskImage() expected an error for a truncated disk")
+	}
+
+	// with metadata, the disk must be the size it was built as, not just the minimum
+	recorded := newDisk("recorded")
+	fi, err := os.Stat(recorded)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if err := writeMetadataFile(recorded+".json", DiskMetadata{Size: fi.Size()}); err != nil {
+		t.Fatalf("writeMetadataFile() error = %v", err)
+	}
+	if err := VerifyDiskImage(recorded, DiskFormatRaw); err != nil {
+		t.Errorf("VerifyDiskImage() of a disk matching its metadata error = %v", err)
+	}
+	if err := writeMetadataFile(recorded+".json", DiskMetadata{Size: fi.Size() + bytesPerMB}); err != nil {
+		t.Fatalf("writeMetadataFile() error = %v", err)
+	}
+	if err := VerifyDiskImage(recorded, DiskFormatRaw); err == nil {
+		t.Error("VerifyDiskImage() expected an error for a disk smaller than its recorded size")
+	}
+
+	if err := VerifyDiskImage(newDisk("unknown"), "vdi"); err == nil {
+		t.Error("VerifyDiskImage() expected an error for an unsupported format")
+	}
+}
+
+func TestVerifyDiskImageQcow2(t *testing.T) {
+	if _, err := exec.LookPath(qemuImg); err != nil {
+		t.Skip("qemu-img is not installed")
+	}
+	d, cleanup := newTestDisk(t, 10)
+	defer cleanup()
+	dest := filepath.Join(d.StorePath, "disk.qcow2")
+	if err := ExportDisk(d, dest, DiskFormatQcow2); err != nil {
+		t.Fatalf("ExportDisk() error = %v", err)
+	}
+	if err := VerifyDiskImage(dest, DiskFormatQcow2); err != nil {
+		t.Errorf("VerifyDiskImage() of a good qcow2 disk error = %v", err)
+	}
+
+	if err := os.Truncate(dest, 512); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if err := VerifyDiskImage(dest, DiskFormatQcow2); err == nil {
+		t.Error("VerifyDiskImage() expected an error for a truncated qcow2 disk")
+	}
+}
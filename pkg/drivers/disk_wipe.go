@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io"
+	"os"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// removeFile unlinks files, replaceable for testing
+var removeFile = os.Remove
+
+// SecureDeleteDisk zeroes the machine's disk image before removing it, along with its
+// metadata, so that what the machine stored can't be recovered from the freed blocks. Chunks
+// that already read as zero, including the holes of a sparse disk, are left alone so that
+// wiping never allocates more space. This is best-effort: SSDs and copy-on-write filesystems
+// may keep old copies of the data that no overwrite can reach.
+func SecureDeleteDisk(d *drivers.BaseDriver) error {
+	diskPath := GetDiskPath(d)
+	glog.Infof("Wiping %s ...", diskPath)
+	if err := zeroFile(diskPath); err != nil {
+		return errors.Wrapf(err, "wipe %s", diskPath)
+	}
+	if err := removeFile(diskPath); err != nil {
+		return errors.Wrap(err, "remove disk")
+	}
+	if err := removeFile(diskMetadataPath(d)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove disk metadata")
+	}
+	return nil
+}
+
+// zeroFile overwrites every chunk of path holding data with zeros, and syncs the result
+func zeroFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, copyChunkSize)
+	zeros := make([]byte, copyChunkSize)
+	var off int64
+	for {
+		n, err := f.ReadAt(buf, off)
+		if n > 0 && !isZero(buf[:n]) {
+			if _, werr := f.WriteAt(zeros[:n], off); werr != nil {
+				return errors.Wrap(werr, "write")
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "read")
+		}
+	}
+	return f.Sync()
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSecureDeleteDisk(t *testing.T) {
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+	diskPath := GetDiskPath(d)
+	f, err := os.OpenFile(diskPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open disk: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("secret"), 3*bytesPerMB); err != nil {
+		t.Fatalf("write disk: %v", err)
+	}
+	f.Close()
+
+	var wiped []byte
+	removeFile = func(path string) error {
+		if path == diskPath {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			wiped = b
+		}
+		return os.Remove(path)
+	}
+	defer func() { removeFile = os.Remove }()
+
+	if err := SecureDeleteDisk(d); err != nil {
+		t.Fatalf("SecureDeleteDisk() error = %v", err)
+	}
+	if len(wiped) != 5*bytesPerMB {
+		t.Fatalf("disk was %d bytes when removed, want %d", len(wiped), 5*bytesPerMB)
+	}
+	if !isZero(wiped) {
+		t.Error("disk still held data when it was removed")
+	}
+	for _, p := range []string{diskPath, diskMetadataPath(d)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, stat error = %v", p, err)
+		}
+	}
+}
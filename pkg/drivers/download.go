@@ -0,0 +1,943 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/golang/glog"
+	"github.com/hashicorp/go-getter"
+	"github.com/pkg/errors"
+
+	"k8s.io/minikube/pkg/util"
+	"k8s.io/minikube/pkg/version"
+)
+
+// Logger receives log lines, as glog does
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+}
+
+// glogLogger is the Logger used when none is set
+type glogLogger struct{}
+
+// Infof logs to glog at the info level
+func (glogLogger) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, fmt.Sprintf(format, args...))
+}
+
+// Warningf logs to glog at the warning level
+func (glogLogger) Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(1, fmt.Sprintf(format, args...))
+}
+
+// InstallOptions are the optional settings used when installing or updating a driver
+type InstallOptions struct {
+	// ForceIPv4 only dials IPv4 addresses, for dual-stack hosts with broken IPv6
+	ForceIPv4 bool
+	// Quiet suppresses the download message and progress bar, e.g. for scripting
+	Quiet bool
+	// Plain prints the download message in ASCII, for consoles and CI logs that mangle emoji.
+	// Setting NO_EMOJI in the environment has the same effect.
+	Plain bool
+	// Force downloads the driver even if the existing file matches the expected checksum
+	Force bool
+	// SHA256 pins the expected hex sha256 digest of the driver, which go-getter then verifies
+	// during the download. If empty, the checksum published alongside the driver is used.
+	SHA256 string
+	// Metrics, if set, receives the duration of the download
+	Metrics MetricsSink
+	// StagingDir is where the driver is downloaded before being moved into the destination, so
+	// that large downloads avoid a small TMPDIR. Defaults to the destination itself.
+	StagingDir string
+	// SourceAddr, if set, is the local address downloads are made from, to egress a particular
+	// interface on multi-homed hosts. Defaults to the system's routing.
+	SourceAddr net.IP
+	// Filename is the name to save the driver as in the destination, e.g. to keep several
+	// versions cached. Defaults to the driver name.
+	Filename string
+	// Symlink links the driver name to Filename, so that the driver can still be found by name
+	Symlink bool
+	// AtomicSwap saves the driver under a versioned name, then atomically points a symlink at it
+	// from Filename, so that a driver being run while it is updated is always complete. The
+	// version it replaces is removed. Not supported on windows.
+	AtomicSwap bool
+	// Progress, if set, displays download progress in place of util.DefaultProgressBar,
+	// e.g. to match the style of an application embedding minikube
+	Progress getter.ProgressTracker
+	// Probe runs the driver after installing it, failing the install if it exits nonzero
+	Probe bool
+	// ProbeArgs is the driver subcommand Probe runs, e.g. one that checks the hypervisor is
+	// reachable. Defaults to "version".
+	ProbeArgs []string
+	// VersionedCache keeps drivers in a directory per minikube version within the destination,
+	// looking them up there rather than in PATH, so that several minikube versions can coexist.
+	VersionedCache bool
+	// ConnectTimeout bounds how long connecting to the download server may take.
+	// Defaults to defaultConnectTimeout.
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds how long a download may go without receiving any data before it is
+	// considered stalled. Defaults to defaultReadTimeout.
+	ReadTimeout time.Duration
+	// Retries is how many more times a stalled download is attempted before giving up
+	Retries int
+	// VerifyInstalled hashes an installed driver that is otherwise up to date against the
+	// checksum recorded when it was downloaded, replacing it if it no longer matches, e.g.
+	// before each start. Drivers without a recorded checksum are not checked.
+	VerifyInstalled bool
+	// VersionRetries is how many more times running the installed driver's 'version' command is
+	// attempted when the driver fails to start for a transient reason, such as a loaded host
+	// running out of processes, before the driver is replaced. Defaults to
+	// defaultVersionRetries; negative disables retries.
+	VersionRetries int
+	// OCIRef, if set, pulls the driver from this OCI registry artifact rather than over HTTP,
+	// e.g. registry.example.com/tools/docker-machine-driver-kvm2:v1.3.0. The layer titled with
+	// the driver name, or else the only layer, holds the binary or a tar containing it.
+	OCIRef string
+	// OCIPlainHTTP talks to the OCIRef registry over plain HTTP, e.g. for a local registry
+	OCIPlainHTTP bool
+	// URLTemplate, if set, is where drivers are downloaded from instead of the release URL, to
+	// describe a mirror's layout. It is a text/template of an absolute http(s) URL that may use
+	// {{.Driver}}, {{.Version}}, {{.Channel}}, {{.OS}} and {{.Arch}}, and must use {{.Driver}}.
+	// Defaults to $MINIKUBE_DRIVER_DOWNLOAD_URL, then to the release URL.
+	URLTemplate string
+	// Version pins the driver release to download, e.g. v1.3.0, taking precedence over Channel.
+	// It also fills {{.Version}} in URLTemplate, where it defaults to the minikube version.
+	Version string
+	// Channel is the release track to download drivers from when no Version is pinned.
+	// Defaults to ChannelStable.
+	Channel Channel
+	// LookPath finds installed drivers, given the driver name or, with VersionedCache, its path
+	// in the cache. Defaults to exec.LookPath, e.g. tests can search a temp dir instead of PATH.
+	LookPath func(file string) (string, error)
+	// VersionRange, if set, is the window of driver versions known to work, e.g.
+	// semver.MustParseRange(">=1.2.0 <2.0.0"). An installed driver inside it is kept even if it
+	// is older than minikube; one outside it is replaced with the newest release inside it,
+	// which must then report a version inside it. Unset, the driver must be at least the
+	// minikube version.
+	VersionRange semver.Range
+	// Logger receives the log lines of the install, e.g. to route them into an embedder's own
+	// logger. Defaults to glog.
+	Logger Logger
+	// FreeSpaceMargin is how many bytes must remain free on the filesystem a driver is downloaded
+	// to, on top of the driver itself. Defaults to defaultFreeSpaceMargin.
+	FreeSpaceMargin int64
+	// Companions also downloads the files, such as udev rules, that the driver needs installed
+	// alongside it. Drivers without companion files are unaffected.
+	Companions bool
+	// AuditLog, if set, is a file that an AuditEntry is appended to as a JSON line for each
+	// driver downloaded, e.g. for compliance records. It is created if absent. A download that
+	// can't be recorded fails, although the driver is left installed.
+	AuditLog string
+	// Headers are added to every request downloads make, including for checksums and redirects
+	// on the same host, e.g. a token header a CDN requires. Redirects to other hosts only get
+	// the User-Agent. A User-Agent here replaces the default, which
+	// identifies minikube and its version, e.g. for a mirror that allow-lists clients.
+	Headers http.Header
+	// Verifier, if set, checks the provenance of each downloaded driver before it is installed,
+	// e.g. CosignVerifier. A driver it rejects is still installed, with a warning, unless
+	// RequireVerification is set.
+	Verifier DriverVerifier
+	// RequireVerification fails any download that the Verifier rejects, or that there is no
+	// Verifier to check
+	RequireVerification bool
+	// VerifySegments checks a download against the per-segment digests published alongside it
+	// with the .segments suffix as it streams in, failing as soon as a bad segment arrives
+	// rather than after the whole download. Without a manifest, only the whole file is checked.
+	VerifySegments bool
+
+	// targetVersion is the minikube version being installed for, the default {{.Version}}
+	targetVersion string
+}
+
+// Channel is a driver release track
+type Channel string
+
+const (
+	// ChannelStable is the track of minikube releases
+	ChannelStable Channel = "stable"
+	// ChannelBeta is the track of release candidates
+	ChannelBeta Channel = "beta"
+	// ChannelEdge is the track of builds from master
+	ChannelEdge Channel = "edge"
+)
+
+// channelTracks are the path segments of the release URL that each Channel downloads from
+var channelTracks = map[Channel]string{
+	ChannelStable: "latest",
+	ChannelBeta:   "beta",
+	ChannelEdge:   "edge",
+}
+
+// channel returns the Channel to download from
+func (o InstallOptions) channel() (Channel, error) {
+	if o.Channel == "" {
+		return ChannelStable, nil
+	}
+	if _, ok := channelTracks[o.Channel]; !ok {
+		return "", errors.Errorf("unknown driver channel %q, must be %s, %s or %s", o.Channel, ChannelStable, ChannelBeta, ChannelEdge)
+	}
+	return o.Channel, nil
+}
+
+// track returns the release URL path segment to download from: the pinned Version, or else the Channel's
+func (o InstallOptions) track() (string, error) {
+	if o.Version != "" {
+		return o.Version, nil
+	}
+	c, err := o.channel()
+	if err != nil {
+		return "", err
+	}
+	return channelTracks[c], nil
+}
+
+const (
+	// defaultConnectTimeout is the ConnectTimeout used when none is set
+	defaultConnectTimeout = 30 * time.Second
+	// defaultReadTimeout is the ReadTimeout used when none is set
+	defaultReadTimeout = time.Minute
+)
+
+// defaultVersionRetries is the VersionRetries used when none is set
+const defaultVersionRetries = 2
+
+// versionRetries returns how many more times a transiently failing 'version' command is run
+func (o InstallOptions) versionRetries() int {
+	if o.VersionRetries == 0 {
+		return defaultVersionRetries
+	}
+	if o.VersionRetries < 0 {
+		return 0
+	}
+	return o.VersionRetries
+}
+
+// downloadRetryPolicy returns how a stalled download of url is retried. A stall has already
+// waited out ReadTimeout, so the next attempt starts right away.
+func (o InstallOptions) downloadRetryPolicy(url string) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: o.Retries + 1,
+		OnRetry: func(err error, _ time.Duration) {
+			o.logger().Warningf("download of %s stalled, retrying: %v", url, err)
+		},
+	}
+}
+
+// defaultFreeSpaceMargin is the FreeSpaceMargin used when none is set
+const defaultFreeSpaceMargin = 10 * 1000000
+
+// freeSpaceMargin returns how many bytes must be left free after a download
+func (o InstallOptions) freeSpaceMargin() int64 {
+	if o.FreeSpaceMargin > 0 {
+		return o.FreeSpaceMargin
+	}
+	return defaultFreeSpaceMargin
+}
+
+// connectTimeout returns the timeout for connecting to the download server
+func (o InstallOptions) connectTimeout() time.Duration {
+	if o.ConnectTimeout > 0 {
+		return o.ConnectTimeout
+	}
+	return defaultConnectTimeout
+}
+
+// readTimeout returns how long a download may go without receiving data
+func (o InstallOptions) readTimeout() time.Duration {
+	if o.ReadTimeout > 0 {
+		return o.ReadTimeout
+	}
+	return defaultReadTimeout
+}
+
+// noEmojiEnv is the environment variable that, when set to anything, requests plain ASCII output
+const noEmojiEnv = "NO_EMOJI"
+
+// plain returns whether download output should avoid emoji
+func (o InstallOptions) plain() bool {
+	return o.Plain || os.Getenv(noEmojiEnv) != ""
+}
+
+// noProgress is a getter.ProgressTracker that displays nothing
+type noProgress struct{}
+
+// TrackProgress returns stream untouched
+func (noProgress) TrackProgress(src string, currentSize, totalSize int64, stream io.ReadCloser) io.ReadCloser {
+	return stream
+}
+
+// progress returns the progress tracker to use for downloads
+func (o InstallOptions) progress() getter.ProgressTracker {
+	if o.Quiet {
+		return noProgress{}
+	}
+	if o.Progress != nil {
+		return o.Progress
+	}
+	return util.DefaultProgressBar
+}
+
+// hashingProgress is a getter.ProgressTracker that hashes each download as it streams to disk,
+// so that verifying and recording its checksum doesn't need to read the file again
+type hashingProgress struct {
+	getter.ProgressTracker
+	hash hash.Hash
+	// segments, if set, are checked as they stream in, failing the download at the first bad one
+	segments *segmentManifest
+}
+
+// TrackProgress tracks stream with the wrapped tracker, hashing what it reads
+func (p *hashingProgress) TrackProgress(src string, currentSize, totalSize int64, stream io.ReadCloser) io.ReadCloser {
+	// a resumed download only streams the remainder, so the hash would be incomplete
+	p.hash = nil
+	if currentSize == 0 {
+		p.hash = sha256.New()
+	}
+	body := p.ProgressTracker.TrackProgress(src, currentSize, totalSize, stream)
+	if p.hash == nil {
+		return body
+	}
+	r := io.TeeReader(body, p.hash)
+	if p.segments != nil {
+		r = newSegmentReader(r, p.segments, src)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, body}
+}
+
+// sum returns the hex sha256 of the file downloaded to path, from the stream if it was hashed
+func (p *hashingProgress) sum(path string) (string, error) {
+	if p.hash != nil {
+		return hex.EncodeToString(p.hash.Sum(nil)), nil
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// dialer dials download connections, optionally restricted to a single network
+type dialer struct {
+	net.Dialer
+	// network overrides the network requested by the transport, e.g. "tcp4"
+	network string
+	// readTimeout, if set, fails reads that receive nothing for that long
+	readTimeout time.Duration
+}
+
+// DialContext connects to addr, using d.network if set
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.network != "" {
+		network = d.network
+	}
+	conn, err := d.Dialer.DialContext(ctx, network, addr)
+	if err != nil || d.readTimeout == 0 {
+		return conn, err
+	}
+	return &idleTimeoutConn{Conn: conn, timeout: d.readTimeout}, nil
+}
+
+// idleTimeoutConn is a net.Conn whose reads time out if no data arrives within timeout
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Read reads from the connection, pushing the read deadline back each time
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// isTimeout returns whether err is caused by a network timeout
+func isTimeout(err error) bool {
+	ne, ok := errors.Cause(err).(net.Error)
+	return ok && ne.Timeout()
+}
+
+// newDialer returns the dialer used for driver downloads
+func (o InstallOptions) newDialer() *dialer {
+	d := &dialer{
+		Dialer:      net.Dialer{Timeout: o.connectTimeout(), KeepAlive: 30 * time.Second},
+		readTimeout: o.readTimeout(),
+	}
+	if o.ForceIPv4 {
+		d.network = "tcp4"
+	}
+	if o.SourceAddr != nil {
+		d.LocalAddr = &net.TCPAddr{IP: o.SourceAddr}
+	}
+	return d
+}
+
+// httpClient returns the HTTP client used for driver downloads
+func (o InstallOptions) httpClient() *http.Client {
+	return &http.Client{
+		Transport: headerTransport{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           o.newDialer().DialContext,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: o.readTimeout(),
+				IdleConnTimeout:       90 * time.Second,
+			},
+			header: o.headers(),
+		},
+	}
+}
+
+// closeIdleConnections closes the idle connections of a client from httpClient
+func closeIdleConnections(c *http.Client) {
+	if tr, ok := c.Transport.(headerTransport); ok {
+		tr.CloseIdleConnections()
+	}
+}
+
+// headers returns the headers to send with download requests
+func (o InstallOptions) headers() http.Header {
+	h := http.Header{}
+	h.Set("User-Agent", fmt.Sprintf("Minikube/%s Minikube-OS/%s", version.GetVersion(), runtime.GOOS))
+	for k, vs := range o.Headers {
+		h.Del(k)
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	return h
+}
+
+// headerTransport adds header to each request that doesn't already set it. Only the User-Agent
+// follows a redirect to another host, so a token meant for a mirror doesn't leak to wherever it points.
+type headerTransport struct {
+	*http.Transport
+	header http.Header
+}
+
+// RoundTrip sends req with the extra headers
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it is given
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+len(t.header))
+	for k, vs := range req.Header {
+		r.Header[k] = vs
+	}
+	sameHost := req.URL.Host == originHost(req)
+	for k, vs := range t.header {
+		if !sameHost && k != "User-Agent" {
+			continue
+		}
+		if _, ok := r.Header[k]; !ok {
+			r.Header[k] = vs
+		}
+	}
+	return t.Transport.RoundTrip(r)
+}
+
+// originHost returns the host of the request that the redirects leading to req started from
+func originHost(req *http.Request) string {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req.URL.Host
+}
+
+// getters returns the go-getter getters for driver downloads, with HTTP(S) going through client
+func getters(client *http.Client) map[string]getter.Getter {
+	gs := map[string]getter.Getter{}
+	for scheme, g := range getter.Getters {
+		gs[scheme] = g
+	}
+	hg := &getter.HttpGetter{Client: client}
+	gs["http"] = hg
+	gs["https"] = hg
+	return gs
+}
+
+// checksumSuffix is appended to a download URL to find its published sha256 checksum
+const checksumSuffix = ".sha256"
+
+// minDriverSize is the smallest plausible driver binary, in bytes. Anything smaller is most likely
+// an error page or an empty body from a misbehaving mirror. A var for testing.
+var minDriverSize int64 = 1 << 20
+
+// allowedDrivers are the driver binaries that may be installed and run, on top of those with a download URL
+var allowedDrivers = []string{"docker-machine-driver-kvm2", "docker-machine-driver-hyperkit"}
+
+// validateDriverName returns an error unless driver is the bare name of an allowed driver binary,
+// so that a caller-supplied name can't make InstallOrUpdate run something else
+func validateDriverName(driver string) error {
+	if err := validateBareName(driver); err != nil {
+		return errors.Wrap(err, "driver")
+	}
+	if _, ok := driverDownloadURL(driver); ok {
+		return nil
+	}
+	for _, d := range allowedDrivers {
+		if driver == d {
+			return nil
+		}
+	}
+	return errors.Errorf("unknown driver %q, must be one of %v", driver, allowedDrivers)
+}
+
+// validateBareName returns an error unless name is a plain file name that can't be mistaken
+// for a path or a command line flag
+func validateBareName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, "-") {
+		return errors.Errorf("invalid name %q", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return errors.Errorf("invalid character %q in name %q", r, name)
+		}
+	}
+	return nil
+}
+
+// driverDownloadURL returns where driver is downloaded from, if minikube manages it
+func driverDownloadURL(driver string) (string, bool) {
+	// only support kvm2 for now
+	if driver != "docker-machine-driver-kvm2" {
+		return "", false
+	}
+	return driverKVMDownloadURL, true
+}
+
+// resolveURL returns where driver is downloaded from, if minikube manages it, honoring the URL
+// template and release track
+func (o InstallOptions) resolveURL(driver string) (string, bool, error) {
+	u, ok := driverDownloadURL(driver)
+	if !ok {
+		return "", false, nil
+	}
+	if o.urlTemplate() != "" {
+		u, err := o.renderURL(driver)
+		return u, true, err
+	}
+	t, err := o.track()
+	if err != nil {
+		return "", true, err
+	}
+	return strings.Replace(u, "/releases/latest/", "/releases/"+t+"/", 1), true, nil
+}
+
+// urlTemplateData are the placeholders available to InstallOptions.URLTemplate
+type urlTemplateData struct {
+	// Driver is the driver binary name, e.g. docker-machine-driver-kvm2
+	Driver string
+	// Version is the minikube version, e.g. v1.3.0
+	Version string
+	// Channel is the release track, e.g. stable
+	Channel Channel
+	// OS is the GOOS the driver is for
+	OS string
+	// Arch is the GOARCH the driver is for
+	Arch string
+}
+
+// driverDownloadURLEnv is the environment variable holding a URLTemplate to use when none is set
+const driverDownloadURLEnv = "MINIKUBE_DRIVER_DOWNLOAD_URL"
+
+// urlTemplate returns the URLTemplate to download drivers with, or "" for the release URL
+func (o InstallOptions) urlTemplate() string {
+	if o.URLTemplate != "" {
+		return o.URLTemplate
+	}
+	return os.Getenv(driverDownloadURLEnv)
+}
+
+// renderURL renders the URL template for driver
+func (o InstallOptions) renderURL(driver string) (string, error) {
+	tmpl := o.urlTemplate()
+	t, err := template.New("url").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", errors.Wrap(err, "parse url template")
+	}
+	c, err := o.channel()
+	if err != nil {
+		return "", err
+	}
+	v := o.Version
+	if v == "" {
+		v = o.targetVersion
+	}
+	if v == "" {
+		if sv, err := currentVersion(); err == nil {
+			v = version.VersionPrefix + sv.String()
+		}
+	}
+	render := func(driver string) (string, error) {
+		var b strings.Builder
+		if err := t.Execute(&b, urlTemplateData{Driver: driver, Version: v, Channel: c, OS: runtime.GOOS, Arch: runtime.GOARCH}); err != nil {
+			return "", errors.Wrap(err, "render url template")
+		}
+		return b.String(), nil
+	}
+	u, err := render(driver)
+	if err != nil {
+		return "", err
+	}
+	// each driver needs its own URL, so the template must depend on the driver name
+	if other, err := render(driver + "-other"); err != nil || other == u {
+		return "", errors.Errorf("url template %q must use {{.Driver}}", tmpl)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", errors.Errorf("url template %q renders %q, which is not an http(s) URL", tmpl, u)
+	}
+	return u, nil
+}
+
+// ensureDestination creates the destination directory if it is missing, and rejects it if it is not a directory
+func (o InstallOptions) ensureDestination(destination string) error {
+	fi, err := os.Stat(destination)
+	if os.IsNotExist(err) {
+		o.logger().Infof("Creating driver destination %s", destination)
+		if err := os.MkdirAll(destination, 0755); err != nil {
+			return errors.Wrapf(err, "create destination %s", destination)
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "stat destination %s", destination)
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("driver destination %s is a file, not a directory", destination)
+	}
+	return nil
+}
+
+// stage creates a temporary directory to download into, ideally on the same filesystem as destination
+func (o InstallOptions) stage(destination string) (string, error) {
+	dir := o.StagingDir
+	if dir == "" {
+		dir = destination
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "create staging directory %s", dir)
+	}
+	return ioutil.TempDir(dir, ".download-")
+}
+
+// filename returns the name to save driver as
+func (o InstallOptions) filename(driver string) string {
+	if o.Filename != "" {
+		return o.Filename
+	}
+	return driver
+}
+
+// link points driver in destination at the downloaded file, if a symlink was requested
+func (o InstallOptions) link(driver, destination string) error {
+	name := o.filename(driver)
+	if !o.Symlink || name == driver {
+		return nil
+	}
+	// relative, so that the cache can be moved as a whole
+	return swapLink(filepath.Join(destination, driver), name)
+}
+
+// swapLink atomically points link at target, replacing whatever link was, so that it is never missing
+func swapLink(link, target string) error {
+	tmp := fmt.Sprintf("%s.tmp-%d", link, os.Getpid())
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove stale %s", tmp)
+	}
+	if err := os.Symlink(target, tmp); err != nil {
+		return errors.Wrapf(err, "link %s to %s", tmp, target)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "can't replace %s, check that it is writable", link)
+	}
+	return nil
+}
+
+// versionedFilename returns the name AtomicSwap saves the driver with digest under
+func (o InstallOptions) versionedFilename(driver, digest string) string {
+	v := o.Version
+	if v == "" {
+		v = o.targetVersion
+	}
+	if v == "" {
+		v = digest[:12]
+	}
+	return o.filename(driver) + "-" + v
+}
+
+// swapDriver points the driver's name in destination at its versioned file, then removes the
+// versioned file it pointed at before
+func (o InstallOptions) swapDriver(driver, destination, versioned string) error {
+	link := filepath.Join(destination, o.filename(driver))
+	old, readErr := os.Readlink(link)
+	if err := swapLink(link, versioned); err != nil {
+		return err
+	}
+	// only ever remove files that a previous swap saved, never one the link was pointed at by hand
+	if readErr != nil || old == versioned || filepath.Base(old) != old || !strings.HasPrefix(old, o.filename(driver)+"-") {
+		return nil
+	}
+	o.logger().Infof("Removing replaced driver %s", old)
+	for _, p := range []string{filepath.Join(destination, old), filepath.Join(destination, old) + checksumSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			o.logger().Warningf("unable to remove %s: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// lookPath returns the function that finds installed drivers
+func (o InstallOptions) lookPath() func(string) (string, error) {
+	if o.LookPath != nil {
+		return o.LookPath
+	}
+	return exec.LookPath
+}
+
+// logger returns where the install logs to
+func (o InstallOptions) logger() Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return glogLogger{}
+}
+
+// probeArgs returns the driver subcommand to run after installing
+func (o InstallOptions) probeArgs() []string {
+	if len(o.ProbeArgs) == 0 {
+		return []string{"version"}
+	}
+	return o.ProbeArgs
+}
+
+// probeDriver runs the driver installed at bin, or found in PATH, with args, returning an error if it exits nonzero
+func probeDriver(driver, bin string, opts InstallOptions) error {
+	if _, err := os.Stat(bin); err != nil {
+		if bin, err = opts.lookPath()(driver); err != nil {
+			return errors.Wrapf(err, "can't find %s to check it", driver)
+		}
+	}
+	args := opts.probeArgs()
+	opts.logger().Infof("Checking driver: %s %s", bin, strings.Join(args, " "))
+	if output, err := exec.Command(bin, args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "%s %s failed, check that its hypervisor is installed and running: %s", driver, strings.Join(args, " "), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// EstimateDownloadSize returns how many bytes downloading driver would fetch, e.g. to ask for
+// confirmation on metered connections, or -1 if the server does not say.
+func EstimateDownloadSize(driver string) (int64, error) {
+	return EstimateDownloadSizeWithOptions(driver, InstallOptions{})
+}
+
+// EstimateDownloadSizeWithOptions is EstimateDownloadSize for a download with opts, asking the
+// server the driver would be fetched from, with the same headers and proxy.
+func EstimateDownloadSizeWithOptions(driver string, opts InstallOptions) (int64, error) {
+	url, ok, err := opts.resolveURL(driver)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.Errorf("%s is not downloaded by minikube", driver)
+	}
+	// registries only report the size of each layer once pulling has started
+	if opts.OCIRef != "" {
+		return -1, nil
+	}
+	return contentLength(opts.httpClient(), url)
+}
+
+// contentLength returns the size the server reports for url, or -1 if it does not say
+func contentLength(client *http.Client, url string) (int64, error) {
+	resp, err := client.Head(url)
+	if err != nil {
+		return 0, errors.Wrapf(err, "HEAD %s", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("HEAD %s: %s", url, resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+// moveFile renames src over dst, copying instead when they are on different filesystems.
+// Either way, dst is only replaced once the new file is complete.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	w, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+"-")
+	if err != nil {
+		return err
+	}
+	tmp := w.Name()
+	if _, err := io.Copy(w, in); err != nil {
+		w.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, fi.Mode()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// fetchChecksum returns the sha256 checksum published alongside url
+func fetchChecksum(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url + checksumSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("%s%s: %s", url, checksumSuffix, resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", errors.Wrap(err, "read checksum")
+	}
+	// Either a bare digest, or sha256sum output of the form "<digest>  <filename>"
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", errors.Errorf("malformed checksum from %s%s: %q", url, checksumSuffix, b)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// writeChecksum records digest as the checksum of the driver at path
+func writeChecksum(path, digest string) error {
+	return ioutil.WriteFile(path+checksumSuffix, []byte(digest+"  "+filepath.Base(path)+"\n"), 0644)
+}
+
+// RefreshChecksum re-fetches the checksum published for driver, e.g. after a release was re-signed,
+// and records it alongside the driver in destination without downloading the driver again.
+// It returns an error if the installed driver no longer matches.
+func RefreshChecksum(driver, destination string) error {
+	return RefreshChecksumWithOptions(driver, destination, InstallOptions{})
+}
+
+// RefreshChecksumWithOptions is RefreshChecksum for a driver installed with opts. The checksum is
+// fetched from where the driver was downloaded, and recorded alongside the file the driver's
+// name points at, as the download itself records it, e.g. the versioned file of an AtomicSwap.
+func RefreshChecksumWithOptions(driver, destination string, opts InstallOptions) error {
+	url, ok, err := opts.resolveURL(driver)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf("%s is not downloaded by minikube", driver)
+	}
+	want, err := fetchChecksum(opts.httpClient(), url)
+	if err != nil {
+		return errors.Wrap(err, "fetch checksum")
+	}
+	target, err := filepath.EvalSymlinks(filepath.Join(destination, opts.filename(driver)))
+	if err != nil {
+		return errors.Wrapf(err, "find the installed %s", driver)
+	}
+	got, err := fileSHA256(target)
+	if err != nil {
+		return errors.Wrapf(err, "hash %s", target)
+	}
+	if hex.EncodeToString(got) != want {
+		return errors.Errorf("%s does not match the published checksum %s, reinstall it", target, want)
+	}
+	return writeChecksum(target, want)
+}
+
+// validSHA256 returns whether s is a hex encoded sha256 digest
+func validSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+// pinned returns url with a go-getter checksum query if a digest is pinned
+func (o InstallOptions) pinned(url string) string {
+	if o.SHA256 == "" {
+		return url
+	}
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + "checksum=sha256:" + strings.ToLower(o.SHA256)
+}
+
+// upToDate returns whether path exists and matches the pinned digest, or else the checksum published alongside url
+func (o InstallOptions) upToDate(client *http.Client, url, path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	want := strings.ToLower(o.SHA256)
+	if want == "" {
+		var err error
+		if want, err = fetchChecksum(client, url); err != nil {
+			o.logger().Infof("unable to fetch checksum, will download: %v", err)
+			return false
+		}
+	}
+	got, err := fileSHA256(path)
+	if err != nil {
+		o.logger().Warningf("unable to hash %s: %v", path, err)
+		return false
+	}
+	return hex.EncodeToString(got) == want
+}
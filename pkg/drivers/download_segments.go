@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// segmentManifestSuffix is appended to a download URL to find its per-segment digests
+const segmentManifestSuffix = ".segments"
+
+// segmentManifest lists the sha256 digests of consecutive fixed size segments of a download,
+// so that corruption is caught as soon as a bad segment arrives rather than once it is complete
+type segmentManifest struct {
+	// SegmentSize is the size in bytes of every segment but the last, which may be shorter
+	SegmentSize int64 `json:"segmentSize"`
+	// SHA256 are the hex sha256 digests of the segments, in order
+	SHA256 []string `json:"sha256"`
+}
+
+// fetchSegmentManifest returns the segment manifest published alongside url, or nil if there is none
+func fetchSegmentManifest(client *http.Client, url string) (*segmentManifest, error) {
+	resp, err := client.Get(url + segmentManifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s%s: %s", url, segmentManifestSuffix, resp.Status)
+	}
+	var m segmentManifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&m); err != nil {
+		return nil, errors.Wrapf(err, "parse %s%s", url, segmentManifestSuffix)
+	}
+	if m.SegmentSize <= 0 || len(m.SHA256) == 0 {
+		return nil, errors.Errorf("malformed segment manifest %s%s", url, segmentManifestSuffix)
+	}
+	for i, d := range m.SHA256 {
+		if !validSHA256(d) {
+			return nil, errors.Errorf("malformed digest of segment %d in %s%s: %q", i, url, segmentManifestSuffix, d)
+		}
+		m.SHA256[i] = strings.ToLower(d)
+	}
+	return &m, nil
+}
+
+// segmentReader checks each segment of a stream against a manifest as it is read, failing the
+// read that completes a bad segment
+type segmentReader struct {
+	r        io.Reader
+	manifest *segmentManifest
+	src      string
+	hash     hash.Hash
+	// segment is the index of the segment being read, and n how much of it has been read
+	segment int
+	n       int64
+}
+
+// newSegmentReader returns a reader of r that verifies it against m
+func newSegmentReader(r io.Reader, m *segmentManifest, src string) *segmentReader {
+	return &segmentReader{r: r, manifest: m, src: src, hash: sha256.New()}
+}
+
+// Read reads from the stream, verifying every segment it completes
+func (s *segmentReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	for b := p[:n]; len(b) > 0; {
+		take := s.manifest.SegmentSize - s.n
+		if take > int64(len(b)) {
+			take = int64(len(b))
+		}
+		s.hash.Write(b[:take])
+		s.n += take
+		b = b[take:]
+		if s.n == s.manifest.SegmentSize {
+			if verr := s.check(); verr != nil {
+				return n, verr
+			}
+		}
+	}
+	if err == io.EOF && s.n > 0 {
+		if verr := s.check(); verr != nil {
+			return n, verr
+		}
+	}
+	if err == io.EOF && s.segment != len(s.manifest.SHA256) {
+		return n, errors.Errorf("%s ended after %d segments, its manifest lists %d", s.src, s.segment, len(s.manifest.SHA256))
+	}
+	return n, err
+}
+
+// check compares the segment just read with its digest, then starts the next
+func (s *segmentReader) check() error {
+	if s.segment >= len(s.manifest.SHA256) {
+		return errors.Errorf("%s is longer than the %d segments its manifest lists", s.src, len(s.manifest.SHA256))
+	}
+	if got := hex.EncodeToString(s.hash.Sum(nil)); got != s.manifest.SHA256[s.segment] {
+		return errors.Errorf("segment %d of %s did not match: expected %s, got %s", s.segment, s.src, s.manifest.SHA256[s.segment], got)
+	}
+	s.segment++
+	s.n = 0
+	s.hash.Reset()
+	return nil
+}
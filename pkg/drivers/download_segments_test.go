@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestDownloadVerifySegments(t *testing.T) {
+	const segmentSize = 1024
+	segments := [][]byte{
+		append([]byte("#!/bin/sh\n"), bytes.Repeat([]byte("a"), segmentSize-10)...),
+		bytes.Repeat([]byte("b"), segmentSize),
+		bytes.Repeat([]byte("c"), segmentSize/2),
+	}
+	digest := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+	good := []string{digest(segments[0]), digest(segments[1]), digest(segments[2])}
+
+	var tcs = []struct {
+		desc     string
+		manifest []string
+		wantErr  string
+	}{
+		{desc: "good", manifest: good},
+		{desc: "bad segment", manifest: []string{good[0], digest([]byte("tampered")), good[2]}, wantErr: "segment 1 of"},
+		{desc: "too few segments", manifest: good[:2], wantErr: "longer than the 2 segments"},
+		{desc: "no manifest"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, segmentManifestSuffix) {
+					if tc.manifest == nil {
+						http.NotFound(w, r)
+						return
+					}
+					json.NewEncoder(w).Encode(segmentManifest{SegmentSize: segmentSize, SHA256: tc.manifest})
+					return
+				}
+				if strings.HasSuffix(r.URL.Path, checksumSuffix) {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(bytes.Join(segments, nil))))
+				if r.Method != http.MethodGet {
+					return
+				}
+				for i, s := range segments {
+					w.Write(s)
+					w.(http.Flusher).Flush()
+					if i == 1 && tc.wantErr == "segment 1 of" {
+						// hold back the rest, so that only an early abort can fail the download quickly
+						select {
+						case <-r.Context().Done():
+							return
+						case <-time.After(10 * time.Second):
+						}
+					}
+				}
+			}))
+			defer srv.Close()
+			orig := driverKVMDownloadURL
+			driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+			defer func() { driverKVMDownloadURL = orig }()
+			defer allowSmallDrivers()()
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+
+			start := time.Now()
+			err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, VerifySegments: true})
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("download() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("download() error = %v, want one mentioning %q", err, tc.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("download took %v to fail, want it to abort at the bad segment", elapsed)
+			}
+			if _, err := os.Stat(filepath.Join(tmpdir, kvmDriver)); !os.IsNotExist(err) {
+				t.Errorf("expected no driver after a bad segment, stat error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,987 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/minikube/pkg/minikube/out"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+const kvmDriver = "docker-machine-driver-kvm2"
+
+// serveDriver points the kvm2 download at an httptest server that serves body, returning a cleanup func
+func serveDriver(t *testing.T, body string) (*httptest.Server, func()) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	orig := driverKVMDownloadURL
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+	restoreSize := allowSmallDrivers()
+	return srv, func() {
+		driverKVMDownloadURL = orig
+		restoreSize()
+		srv.Close()
+	}
+}
+
+// allowSmallDrivers lets fixture drivers of a few bytes be downloaded, returning a func that restores the minimum
+func allowSmallDrivers() func() {
+	orig := minDriverSize
+	minDriverSize = 1
+	return func() { minDriverSize = orig }
+}
+
+func TestDownloadEmptyBody(t *testing.T) {
+	_, cleanup := serveDriver(t, "")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true}); err == nil {
+		t.Fatal("download() of an empty body succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, kvmDriver)); !os.IsNotExist(err) {
+		t.Errorf("expected no driver after an empty download, stat error = %v", err)
+	}
+}
+
+func TestDownloadKeepsInstalledDriverOnFailure(t *testing.T) {
+	_, cleanup := serveDriver(t, "new driver")
+	defer cleanup()
+
+	cases := []struct {
+		name string
+		opts InstallOptions
+	}{
+		{"checksum mismatch", InstallOptions{Quiet: true, Force: true, SHA256: strings.Repeat("0", 64)}},
+		{"verifier rejects", InstallOptions{Quiet: true, Force: true, Verifier: func(DriverArtifact) error { return fmt.Errorf("untrusted") }, RequireVerification: true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+			installed := filepath.Join(tmpdir, kvmDriver)
+			if err := ioutil.WriteFile(installed, []byte("old driver"), 0755); err != nil {
+				t.Fatalf("writefile: %v", err)
+			}
+
+			if err := download(kvmDriver, tmpdir, tc.opts); err == nil {
+				t.Fatal("download() expected an error")
+			}
+			b, err := ioutil.ReadFile(installed)
+			if err != nil {
+				t.Fatalf("the installed driver was removed: %v", err)
+			}
+			if string(b) != "old driver" {
+				t.Errorf("installed driver = %q, want it left as it was", b)
+			}
+		})
+	}
+}
+
+func TestNewDialer(t *testing.T) {
+	if got := (InstallOptions{}).newDialer().network; got != "" {
+		t.Errorf("default dialer network = %q, want the system default", got)
+	}
+	if got := (InstallOptions{ForceIPv4: true}).newDialer().network; got != "tcp4" {
+		t.Errorf("ForceIPv4 dialer network = %q, want %q", got, "tcp4")
+	}
+}
+
+func TestNewDialerSourceAddr(t *testing.T) {
+	if got := (InstallOptions{}).newDialer().LocalAddr; got != nil {
+		t.Errorf("default dialer LocalAddr = %v, want nil", got)
+	}
+	src := net.ParseIP("127.0.0.1")
+	got, ok := (InstallOptions{SourceAddr: src}).newDialer().LocalAddr.(*net.TCPAddr)
+	if !ok || !got.IP.Equal(src) {
+		t.Errorf("dialer LocalAddr = %v, want %v", got, src)
+	}
+
+	// Downloads should still succeed when bound to a reachable address
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, SourceAddr: src}); err != nil {
+		t.Errorf("download() bound to %v error = %v", src, err)
+	}
+}
+
+func TestDownloadQuiet(t *testing.T) {
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	f := tests.NewFakeFile()
+	out.SetOutFile(f)
+	defer out.SetOutFile(os.Stdout)
+
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if got := f.String(); got != "" {
+		t.Errorf("quiet download wrote output: %q", got)
+	}
+	if _, ok := (InstallOptions{Quiet: true}).progress().(noProgress); !ok {
+		t.Error("quiet download should not track progress")
+	}
+
+	if err := download(kvmDriver, tmpdir, InstallOptions{}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if f.String() == "" {
+		t.Error("expected a download message when not quiet")
+	}
+}
+
+func TestDownloadPlain(t *testing.T) {
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	defer os.Unsetenv(noEmojiEnv)
+
+	var cases = []struct {
+		description string
+		opts        InstallOptions
+		env         string
+	}{
+		{description: "option", opts: InstallOptions{Plain: true}},
+		{description: "environment", env: "1"},
+	}
+	for _, test := range cases {
+		t.Run(test.description, func(t *testing.T) {
+			os.Setenv(noEmojiEnv, test.env)
+			f := tests.NewFakeFile()
+			out.SetOutFile(f)
+			defer out.SetOutFile(os.Stdout)
+
+			if err := download(kvmDriver, tmpdir, test.opts); err != nil {
+				t.Fatalf("download() error = %v", err)
+			}
+			want := "Downloading driver " + kvmDriver + ":\n"
+			if got := f.String(); got != want {
+				t.Errorf("download output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// countingProgress is a getter.ProgressTracker that counts the downloads it tracks
+type countingProgress struct {
+	tracked int
+}
+
+func (p *countingProgress) TrackProgress(src string, currentSize, totalSize int64, stream io.ReadCloser) io.ReadCloser {
+	p.tracked++
+	return stream
+}
+
+func TestDownloadCustomProgress(t *testing.T) {
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	p := &countingProgress{}
+	if err := download(kvmDriver, tmpdir, InstallOptions{Progress: p}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if p.tracked != 1 {
+		t.Errorf("custom progress tracked %d downloads, want 1", p.tracked)
+	}
+	if _, ok := (InstallOptions{Quiet: true, Progress: p}).progress().(noProgress); !ok {
+		t.Error("quiet download should not track progress, even with a custom tracker")
+	}
+}
+
+func TestDownloadSkipsValidFile(t *testing.T) {
+	body := "driver"
+	sum := sha256.Sum256([]byte(body))
+	var driverGets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, checksumSuffix) {
+			fmt.Fprintf(w, "%x  %s\n", sum, kvmDriver)
+			return
+		}
+		if r.Method == http.MethodGet {
+			driverGets++
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+	orig := driverKVMDownloadURL
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+	defer func() { driverKVMDownloadURL = orig }()
+	defer allowSmallDrivers()()
+
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	target := filepath.Join(tmpdir, kvmDriver)
+	if err := ioutil.WriteFile(target, []byte(body), 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if driverGets != 0 {
+		t.Errorf("driver was fetched %d times, want 0", driverGets)
+	}
+
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, Force: true}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if driverGets != 1 {
+		t.Errorf("forced download fetched the driver %d times, want 1", driverGets)
+	}
+}
+
+func TestDownloadPinnedSHA256(t *testing.T) {
+	body := "driver"
+	_, cleanup := serveDriver(t, body)
+	defer cleanup()
+
+	var tcs = []struct {
+		desc    string
+		sha     string
+		wantErr string
+	}{
+		{"matching", fmt.Sprintf("%x", sha256.Sum256([]byte(body))), ""},
+		{"upper case", strings.ToUpper(fmt.Sprintf("%x", sha256.Sum256([]byte(body)))), ""},
+		{"mismatching", fmt.Sprintf("%x", sha256.Sum256([]byte("other"))), "did not match"},
+		{"malformed", "abc", "invalid sha256"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+			staging := filepath.Join(tmpdir, "staging")
+
+			err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, SHA256: tc.sha, StagingDir: staging})
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("download() error = %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("download() error = %v, want a %q error", err, tc.wantErr)
+			}
+			if left, _ := ioutil.ReadDir(staging); len(left) != 0 {
+				t.Errorf("staging directory not cleaned up, contains %d entries", len(left))
+			}
+			_, statErr := os.Stat(filepath.Join(tmpdir, kvmDriver))
+			if tc.wantErr != "" && !os.IsNotExist(statErr) {
+				t.Errorf("expected no driver after a failed download, stat error = %v", statErr)
+			}
+			if tc.wantErr == "" && statErr != nil {
+				t.Errorf("expected a driver after download, stat error = %v", statErr)
+			}
+		})
+	}
+}
+
+func TestDownloadDestination(t *testing.T) {
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	missing := filepath.Join(tmpdir, "bin", "drivers")
+	if err := download(kvmDriver, missing, InstallOptions{Quiet: true}); err != nil {
+		t.Fatalf("download() to a missing destination error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(missing, kvmDriver)); err != nil {
+		t.Errorf("expected driver in the created destination: %v", err)
+	}
+
+	file := filepath.Join(tmpdir, "file")
+	if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	err := download(kvmDriver, file, InstallOptions{Quiet: true})
+	if err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("download() to a file destination error = %v, want a not a directory error", err)
+	}
+}
+
+func TestDownloadStagingDir(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	destination := filepath.Join(tmpdir, "bin")
+	staging := filepath.Join(tmpdir, "staging")
+
+	// While the driver is being served, the download should be staged rather than in destination
+	var staged []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if matches, err := filepath.Glob(filepath.Join(staging, ".download-*")); err == nil {
+			staged = matches
+		}
+		fmt.Fprint(w, "driver")
+	}))
+	defer srv.Close()
+	orig := driverKVMDownloadURL
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+	defer func() { driverKVMDownloadURL = orig }()
+	defer allowSmallDrivers()()
+
+	if err := download(kvmDriver, destination, InstallOptions{Quiet: true, StagingDir: staging}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if len(staged) != 1 {
+		t.Errorf("staged downloads = %v, want one in %s", staged, staging)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(destination, kvmDriver))
+	if err != nil || string(got) != "driver" {
+		t.Errorf("driver in destination = %q, %v; want %q", got, err, "driver")
+	}
+	if left, _ := ioutil.ReadDir(staging); len(left) != 0 {
+		t.Errorf("staging directory not cleaned up, contains %d entries", len(left))
+	}
+}
+
+func TestDownloadUnremovableTarget(t *testing.T) {
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+
+	t.Run("read-only destination", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			t.Skip("directory permissions are not enforced")
+		}
+		tmpdir := tests.MakeTempDir()
+		defer os.RemoveAll(tmpdir)
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, kvmDriver), []byte("old"), 0755); err != nil {
+			t.Fatalf("writefile: %v", err)
+		}
+		if err := os.Chmod(tmpdir, 0555); err != nil {
+			t.Fatalf("chmod: %v", err)
+		}
+		defer os.Chmod(tmpdir, 0755)
+
+		err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true})
+		if err == nil || !strings.Contains(err.Error(), "writable") {
+			t.Errorf("download() error = %v, want a not writable error", err)
+		}
+	})
+
+	t.Run("non-empty directory", func(t *testing.T) {
+		tmpdir := tests.MakeTempDir()
+		defer os.RemoveAll(tmpdir)
+		if err := os.MkdirAll(filepath.Join(tmpdir, kvmDriver, "child"), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+
+		err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true})
+		if err == nil || !strings.Contains(err.Error(), "writable") {
+			t.Errorf("download() error = %v, want a not writable error", err)
+		}
+	})
+}
+
+func TestDownloadFilename(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need elevated privileges on windows")
+	}
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	versioned := kvmDriver + "-v1.2.3"
+	opts := InstallOptions{Quiet: true, Filename: versioned, Symlink: true}
+	// twice, to check that an existing link is replaced
+	for i := 0; i < 2; i++ {
+		if err := download(kvmDriver, tmpdir, opts); err != nil {
+			t.Fatalf("download() error = %v", err)
+		}
+	}
+
+	if got, err := ioutil.ReadFile(filepath.Join(tmpdir, versioned)); err != nil || string(got) != "driver" {
+		t.Errorf("versioned driver = %q, %v; want %q", got, err, "driver")
+	}
+	target, err := os.Readlink(filepath.Join(tmpdir, kvmDriver))
+	if err != nil {
+		t.Fatalf("readlink: %v", err)
+	}
+	if target != versioned {
+		t.Errorf("%s links to %q, want %q", kvmDriver, target, versioned)
+	}
+}
+
+func TestEstimateDownloadSize(t *testing.T) {
+	body := strings.Repeat("d", 1234)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		if r.Method != http.MethodHead {
+			fmt.Fprint(w, body)
+		}
+	}))
+	defer srv.Close()
+	orig := driverKVMDownloadURL
+	defer func() { driverKVMDownloadURL = orig }()
+
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+	if got, err := EstimateDownloadSize(kvmDriver); err != nil || got != int64(len(body)) {
+		t.Errorf("EstimateDownloadSize() = %d, %v; want %d", got, err, len(body))
+	}
+
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver + "?chunked=1"
+	if got, err := EstimateDownloadSize(kvmDriver); err != nil || got != -1 {
+		t.Errorf("EstimateDownloadSize() without Content-Length = %d, %v; want -1", got, err)
+	}
+
+	if _, err := EstimateDownloadSize("docker-machine-driver-stub"); err == nil {
+		t.Error("EstimateDownloadSize() expected an error for a driver minikube doesn't download")
+	}
+}
+
+func TestEstimateDownloadSizeWithOptions(t *testing.T) {
+	body := strings.Repeat("d", 1234)
+	var gotPath, gotAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAgent = r.URL.Path, r.Header.Get("User-Agent")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}))
+	defer srv.Close()
+
+	// the size is asked of the mirror that the driver would be downloaded from
+	opts := InstallOptions{URLTemplate: srv.URL + "/mirror/{{.Version}}/{{.Driver}}", Version: "v1.2.3"}
+	if got, err := EstimateDownloadSizeWithOptions(kvmDriver, opts); err != nil || got != int64(len(body)) {
+		t.Errorf("EstimateDownloadSizeWithOptions() = %d, %v; want %d", got, err, len(body))
+	}
+	if want := "/mirror/v1.2.3/" + kvmDriver; gotPath != want {
+		t.Errorf("HEAD %s, want %s", gotPath, want)
+	}
+	if !strings.HasPrefix(gotAgent, "Minikube/") {
+		t.Errorf("User-Agent = %q, want the download headers", gotAgent)
+	}
+
+	os.Setenv(driverDownloadURLEnv, srv.URL+"/env/{{.Driver}}")
+	defer os.Unsetenv(driverDownloadURLEnv)
+	if _, err := EstimateDownloadSize(kvmDriver); err != nil {
+		t.Errorf("EstimateDownloadSize() error = %v", err)
+	}
+	if want := "/env/" + kvmDriver; gotPath != want {
+		t.Errorf("HEAD %s, want %s from %s", gotPath, want, driverDownloadURLEnv)
+	}
+}
+
+func TestRefreshChecksum(t *testing.T) {
+	body := "driver"
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+	var driverGets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, checksumSuffix) {
+			fmt.Fprintf(w, "%s  %s\n", sum, kvmDriver)
+			return
+		}
+		driverGets++
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+	orig := driverKVMDownloadURL
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+	defer func() { driverKVMDownloadURL = orig }()
+	defer allowSmallDrivers()()
+
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	target := filepath.Join(tmpdir, kvmDriver)
+	if err := ioutil.WriteFile(target, []byte(body), 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	// a checksum recorded before the release was re-signed
+	if err := writeChecksum(target, strings.Repeat("0", 64)); err != nil {
+		t.Fatalf("writeChecksum: %v", err)
+	}
+
+	if err := RefreshChecksum(kvmDriver, tmpdir); err != nil {
+		t.Fatalf("RefreshChecksum() error = %v", err)
+	}
+	got, err := ioutil.ReadFile(target + checksumSuffix)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if want := sum + "  " + kvmDriver + "\n"; string(got) != want {
+		t.Errorf("recorded checksum = %q, want %q", got, want)
+	}
+	if driverGets != 0 {
+		t.Errorf("driver was downloaded %d times, want 0", driverGets)
+	}
+
+	if err := ioutil.WriteFile(target, []byte("tampered"), 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	if err := RefreshChecksum(kvmDriver, tmpdir); err == nil {
+		t.Error("RefreshChecksum() expected an error for a driver that no longer matches")
+	}
+}
+
+func TestRefreshChecksumWithOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("atomic driver swaps are not supported on windows")
+	}
+	body := "driver"
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		fmt.Fprintf(w, "%s  %s\n", sum, kvmDriver)
+	}))
+	defer srv.Close()
+
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	// installed as an AtomicSwap does: the driver's name links to a versioned file
+	versioned := kvmDriver + "-v1.2.3"
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, versioned), []byte(body), 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	if err := os.Symlink(versioned, filepath.Join(tmpdir, kvmDriver)); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	opts := InstallOptions{URLTemplate: srv.URL + "/mirror/{{.Version}}/{{.Driver}}", Version: "v1.2.3", AtomicSwap: true}
+	if err := RefreshChecksumWithOptions(kvmDriver, tmpdir, opts); err != nil {
+		t.Fatalf("RefreshChecksumWithOptions() error = %v", err)
+	}
+	if want := "/mirror/v1.2.3/" + kvmDriver + checksumSuffix; gotPath != want {
+		t.Errorf("fetched %s, want %s", gotPath, want)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(tmpdir, versioned+checksumSuffix))
+	if err != nil {
+		t.Fatalf("checksum not recorded alongside the versioned driver: %v", err)
+	}
+	if want := sum + "  " + versioned + "\n"; string(got) != want {
+		t.Errorf("recorded checksum = %q, want %q", got, want)
+	}
+	if _, err := os.Lstat(filepath.Join(tmpdir, kvmDriver+checksumSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expected no checksum alongside the link, stat error = %v", err)
+	}
+}
+
+func TestDownloadStalled(t *testing.T) {
+	var requests int32
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		// the HEAD requests checking the size and range support are answered, only the body stalls
+		if r.Method == http.MethodHead {
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, "partial")
+		w.(http.Flusher).Flush()
+		// stop sending mid-body until the client gives up, or the test is over
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+	orig := driverKVMDownloadURL
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+	defer func() { driverKVMDownloadURL = orig }()
+	defer allowSmallDrivers()()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	opts := InstallOptions{Quiet: true, ReadTimeout: 100 * time.Millisecond, Retries: 1}
+	err := download(kvmDriver, tmpdir, opts)
+	if err == nil || !strings.Contains(err.Error(), "download stalled") {
+		t.Fatalf("download() error = %v, want a stalled download", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("download attempts = %d, want 2", got)
+	}
+}
+
+func TestHashingProgress(t *testing.T) {
+	body := strings.Repeat("driver", 1000)
+	want := fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+
+	hp := &hashingProgress{ProgressTracker: noProgress{}}
+	r := hp.TrackProgress("src", 0, int64(len(body)), ioutil.NopCloser(strings.NewReader(body)))
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	got, err := hp.sum("")
+	if err != nil {
+		t.Fatalf("sum() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("streamed hash = %s, want %s", got, want)
+	}
+
+	// the recorded checksum comes from the stream
+	_, cleanup := serveDriver(t, body)
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(tmpdir, kvmDriver+checksumSuffix))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if got := strings.Fields(string(b))[0]; got != want {
+		t.Errorf("recorded checksum = %s, want %s", got, want)
+	}
+}
+
+func TestRenderURL(t *testing.T) {
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	var tcs = []struct {
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{tmpl: "https://mirror.example.com/{{.Version}}/{{.Driver}}", want: "https://mirror.example.com/v1.3.0/" + kvmDriver},
+		{tmpl: "https://mirror.example.com/{{.OS}}-{{.Arch}}/{{.Driver}}-{{.Version}}", want: "https://mirror.example.com/" + platform + "/" + kvmDriver + "-v1.3.0"},
+		{tmpl: "http://10.0.0.1:8080/drivers?name={{.Driver}}", want: "http://10.0.0.1:8080/drivers?name=" + kvmDriver},
+		{tmpl: "https://mirror.example.com/{{.Version}}/kvm2", wantErr: true},
+		{tmpl: "https://mirror.example.com/{{.Driver", wantErr: true},
+		{tmpl: "https://mirror.example.com/{{.Platform}}/{{.Driver}}", wantErr: true},
+		{tmpl: "/srv/{{.Driver}}", wantErr: true},
+	}
+	for _, tc := range tcs {
+		got, err := InstallOptions{URLTemplate: tc.tmpl, Version: "v1.3.0"}.renderURL(kvmDriver)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("renderURL(%q) error = %v, wantErr %v", tc.tmpl, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("renderURL(%q) = %q, want %q", tc.tmpl, got, tc.want)
+		}
+	}
+}
+
+func TestDownloadURLTemplate(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprint(w, "driver")
+	}))
+	defer srv.Close()
+	defer allowSmallDrivers()()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	opts := InstallOptions{Quiet: true, Force: true, URLTemplate: srv.URL + "/{{.Version}}/{{.Driver}}", Version: "v1.3.0"}
+	if err := download(kvmDriver, tmpdir, opts); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if want := "/v1.3.0/" + kvmDriver; len(paths) == 0 || paths[len(paths)-1] != want {
+		t.Errorf("downloaded from %v, want %s", paths, want)
+	}
+}
+
+func TestDownloadURLFromEnv(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprint(w, "driver")
+	}))
+	defer srv.Close()
+	defer allowSmallDrivers()()
+	defer os.Setenv(driverDownloadURLEnv, os.Getenv(driverDownloadURLEnv))
+	os.Setenv(driverDownloadURLEnv, srv.URL+"/env/{{.Driver}}")
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, Force: true}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if want := "/env/" + kvmDriver; len(paths) == 0 || paths[len(paths)-1] != want {
+		t.Errorf("downloaded from %v, want %s", paths, want)
+	}
+
+	// an explicit template wins over the environment
+	opts := InstallOptions{Quiet: true, Force: true, URLTemplate: srv.URL + "/opt/{{.Driver}}"}
+	if err := download(kvmDriver, tmpdir, opts); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if want := "/opt/" + kvmDriver; paths[len(paths)-1] != want {
+		t.Errorf("downloaded from %v, want %s", paths, want)
+	}
+}
+
+// recordingLogger is a Logger that keeps what it is given
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.lines = append(l.lines, "I "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warningf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "W "+fmt.Sprintf(format, args...))
+}
+
+func TestDownloadLogger(t *testing.T) {
+	_, cleanup := serveDriver(t, "#!/bin/sh\n")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	destination := filepath.Join(tmpdir, "bin")
+
+	log := &recordingLogger{}
+	if err := download(kvmDriver, destination, InstallOptions{Quiet: true, Logger: log}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	want := "I Creating driver destination " + destination
+	for _, l := range log.lines {
+		if l == want {
+			return
+		}
+	}
+	t.Errorf("logged %q, want a line %q", log.lines, want)
+}
+
+func TestResolveURLChannel(t *testing.T) {
+	orig := driverKVMDownloadURL
+	defer func() { driverKVMDownloadURL = orig }()
+	driverKVMDownloadURL = "https://storage.googleapis.com/minikube/releases/latest/" + kvmDriver
+
+	var tcs = []struct {
+		desc    string
+		opts    InstallOptions
+		want    string
+		wantErr bool
+	}{
+		{desc: "default", want: "https://storage.googleapis.com/minikube/releases/latest/" + kvmDriver},
+		{desc: "stable", opts: InstallOptions{Channel: ChannelStable}, want: "https://storage.googleapis.com/minikube/releases/latest/" + kvmDriver},
+		{desc: "beta", opts: InstallOptions{Channel: ChannelBeta}, want: "https://storage.googleapis.com/minikube/releases/beta/" + kvmDriver},
+		{desc: "edge", opts: InstallOptions{Channel: ChannelEdge}, want: "https://storage.googleapis.com/minikube/releases/edge/" + kvmDriver},
+		{desc: "pinned version wins", opts: InstallOptions{Channel: ChannelBeta, Version: "v1.3.0"}, want: "https://storage.googleapis.com/minikube/releases/v1.3.0/" + kvmDriver},
+		{desc: "template", opts: InstallOptions{Channel: ChannelEdge, URLTemplate: "https://mirror.example.com/{{.Channel}}/{{.Driver}}"}, want: "https://mirror.example.com/edge/" + kvmDriver},
+		{desc: "unknown", opts: InstallOptions{Channel: "nightly"}, wantErr: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, ok, err := tc.opts.resolveURL(kvmDriver)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("resolveURL() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil || !ok {
+				t.Fatalf("resolveURL() = %s, %v, %v", got, ok, err)
+			}
+			if got != tc.want {
+				t.Errorf("resolveURL() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDownloadAtomicSwap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("atomic swaps need symlinks")
+	}
+	var current atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, current.Load().(string))
+	}))
+	defer srv.Close()
+	orig := driverKVMDownloadURL
+	driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+	defer func() { driverKVMDownloadURL = orig }()
+	defer allowSmallDrivers()()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	driverPath := filepath.Join(tmpdir, kvmDriver)
+
+	// big enough that a partially written driver would be noticed
+	bodies := map[string]bool{}
+	body := func(v string) string {
+		return "#!/bin/sh\necho " + v + "\n" + strings.Repeat("#", 256*1024) + "\n"
+	}
+	for _, v := range []string{"v1.0.0", "v1.1.0", "v1.2.0", "v1.3.0"} {
+		bodies[body(v)] = true
+	}
+
+	current.Store(body("v1.0.0"))
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, AtomicSwap: true, Version: "v1.0.0"}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	readErrs := make(chan error, 1)
+	go func() {
+		defer close(readErrs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			b, err := ioutil.ReadFile(driverPath)
+			if err != nil {
+				readErrs <- err
+				return
+			}
+			if !bodies[string(b)] {
+				readErrs <- fmt.Errorf("read an incomplete driver of %d bytes", len(b))
+				return
+			}
+		}
+	}()
+	for _, v := range []string{"v1.1.0", "v1.2.0", "v1.3.0"} {
+		current.Store(body(v))
+		if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, AtomicSwap: true, Version: v}); err != nil {
+			t.Fatalf("download(%s) error = %v", v, err)
+		}
+	}
+	close(done)
+	if err := <-readErrs; err != nil {
+		t.Errorf("concurrent reader: %v", err)
+	}
+
+	if target, err := os.Readlink(driverPath); err != nil || target != kvmDriver+"-v1.3.0" {
+		t.Errorf("%s links to %q, %v, want %s-v1.3.0", driverPath, target, err, kvmDriver)
+	}
+	for _, v := range []string{"v1.0.0", "v1.1.0", "v1.2.0"} {
+		if _, err := os.Stat(filepath.Join(tmpdir, kvmDriver+"-"+v)); !os.IsNotExist(err) {
+			t.Errorf("expected the replaced %s to be removed, stat error = %v", v, err)
+		}
+	}
+}
+
+func TestDownloadHeaders(t *testing.T) {
+	var tcs = []struct {
+		desc      string
+		headers   http.Header
+		wantAgent string
+		wantToken string
+	}{
+		{desc: "default", wantAgent: "Minikube/"},
+		{desc: "custom", headers: http.Header{"X-Cdn-Token": {"abc"}, "User-Agent": {"allowed-client"}}, wantAgent: "allowed-client", wantToken: "abc"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			var mu sync.Mutex
+			got := map[string]http.Header{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				got[r.Method+" "+r.URL.Path] = r.Header
+				mu.Unlock()
+				fmt.Fprint(w, "#!/bin/sh\n")
+			}))
+			defer srv.Close()
+			orig := driverKVMDownloadURL
+			driverKVMDownloadURL = srv.URL + "/" + kvmDriver
+			defer func() { driverKVMDownloadURL = orig }()
+			defer allowSmallDrivers()()
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+
+			if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, Headers: tc.headers}); err != nil {
+				t.Fatalf("download() error = %v", err)
+			}
+			h, ok := got["GET /"+kvmDriver]
+			if !ok {
+				t.Fatalf("no GET of the driver among %v", got)
+			}
+			for req, h := range got {
+				if ua := h.Get("User-Agent"); !strings.HasPrefix(ua, tc.wantAgent) {
+					t.Errorf("%s User-Agent = %q, want prefix %q", req, ua, tc.wantAgent)
+				}
+			}
+			if token := h.Get("X-Cdn-Token"); token != tc.wantToken {
+				t.Errorf("GET X-Cdn-Token = %q, want %q", token, tc.wantToken)
+			}
+		})
+	}
+}
+
+func TestDownloadHeadersRedirect(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]http.Header{}
+	record := func(host string, r *http.Request) {
+		mu.Lock()
+		got[host+" "+r.Method+" "+r.URL.Path] = r.Header
+		mu.Unlock()
+	}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("other", r)
+		fmt.Fprint(w, "#!/bin/sh\n")
+	}))
+	defer other.Close()
+	// the mirror moves the driver within itself, then hands it off to another host
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("mirror", r)
+		if strings.HasPrefix(r.URL.Path, "/moved/") {
+			http.Redirect(w, r, other.URL+strings.TrimPrefix(r.URL.Path, "/moved"), http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/moved"+r.URL.Path, http.StatusFound)
+	}))
+	defer mirror.Close()
+	orig := driverKVMDownloadURL
+	driverKVMDownloadURL = mirror.URL + "/" + kvmDriver
+	defer func() { driverKVMDownloadURL = orig }()
+	defer allowSmallDrivers()()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	headers := http.Header{"X-Cdn-Token": {"abc"}, "User-Agent": {"allowed-client"}}
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, Headers: headers}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	for _, req := range []string{"mirror GET /" + kvmDriver, "mirror GET /moved/" + kvmDriver, "other GET /" + kvmDriver} {
+		if _, ok := got[req]; !ok {
+			t.Fatalf("no %s among %v", req, got)
+		}
+	}
+	for req, h := range got {
+		if ua := h.Get("User-Agent"); ua != "allowed-client" {
+			t.Errorf("%s User-Agent = %q, want %q", req, ua, "allowed-client")
+		}
+		want := "abc"
+		if strings.HasPrefix(req, "other ") {
+			want = ""
+		}
+		if token := h.Get("X-Cdn-Token"); token != want {
+			t.Errorf("%s X-Cdn-Token = %q, want %q", req, token, want)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-getter"
+	"github.com/pkg/errors"
+
+	"k8s.io/minikube/pkg/minikube/out"
+)
+
+// Downloader downloads drivers, holding on to the temp files and HTTP connections the downloads
+// use until Close, so that long-running embedders can release them in one place.
+type Downloader struct {
+	opts   InstallOptions
+	client *http.Client
+	// temps are the staging directories to remove on Close
+	temps []string
+	// gate holds downloads while paused
+	gate *gate
+}
+
+// NewDownloader returns a Downloader that downloads with opts
+func NewDownloader(opts InstallOptions) *Downloader {
+	return &Downloader{opts: opts, client: opts.httpClient(), gate: newGate()}
+}
+
+// Pause stops reading the download in progress, and any started later, until Resume. The
+// connection is kept open, so the download continues where it left off unless the server
+// gives up on it in the meantime.
+func (dl *Downloader) Pause() {
+	dl.gate.set(true)
+}
+
+// Resume continues downloads held by Pause
+func (dl *Downloader) Resume() {
+	dl.gate.set(false)
+}
+
+// gate blocks readers while it is closed
+type gate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	closed bool
+}
+
+// newGate returns an open gate
+func newGate() *gate {
+	g := &gate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// set closes or opens the gate, releasing any waiting readers when opened
+func (g *gate) set(closed bool) {
+	g.mu.Lock()
+	g.closed = closed
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// wait blocks while the gate is closed
+func (g *gate) wait() {
+	g.mu.Lock()
+	for g.closed {
+		g.cond.Wait()
+	}
+	g.mu.Unlock()
+}
+
+// gatedProgress is a getter.ProgressTracker that holds each download's stream at a gate
+type gatedProgress struct {
+	getter.ProgressTracker
+	gate *gate
+}
+
+// TrackProgress tracks stream with the wrapped tracker, only reading it while the gate is open
+func (p gatedProgress) TrackProgress(src string, currentSize, totalSize int64, stream io.ReadCloser) io.ReadCloser {
+	return &gatedReader{ReadCloser: p.ProgressTracker.TrackProgress(src, currentSize, totalSize, stream), gate: p.gate}
+}
+
+// gatedReader is an io.ReadCloser that only reads while its gate is open
+type gatedReader struct {
+	io.ReadCloser
+	gate *gate
+}
+
+// Read waits for the gate to open, then reads
+func (r *gatedReader) Read(b []byte) (int, error) {
+	r.gate.wait()
+	return r.ReadCloser.Read(b)
+}
+
+// Close removes the Downloader's temp files and closes its idle HTTP connections
+func (dl *Downloader) Close() error {
+	var firstErr error
+	for _, t := range dl.temps {
+		if err := os.RemoveAll(t); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "remove %s", t)
+		}
+	}
+	dl.temps = nil
+	// don't leave a paused download hanging
+	dl.Resume()
+	closeIdleConnections(dl.client)
+	return firstErr
+}
+
+// ErrUnmanagedDriver is the cause of the error returned by Download for drivers that minikube
+// doesn't know where to download from, so that nothing was downloaded
+var ErrUnmanagedDriver = errors.New("driver is not downloaded by minikube")
+
+// Download downloads driver into destination
+func (dl *Downloader) Download(driver, destination string) error {
+	opts := dl.opts
+	if err := validateDriverName(driver); err != nil {
+		return err
+	}
+	if err := validateBareName(opts.filename(driver)); err != nil {
+		return errors.Wrap(err, "driver filename")
+	}
+	url, ok, err := opts.resolveURL(driver)
+	if err != nil {
+		return err
+	}
+	if opts.OCIRef != "" {
+		url, ok = opts.OCIRef, true
+	}
+	if !ok {
+		return errors.Wrap(ErrUnmanagedDriver, driver)
+	}
+
+	if err := opts.ensureDestination(destination); err != nil {
+		return err
+	}
+
+	targetFilepath := path.Join(destination, opts.filename(driver))
+	httpClient := dl.client
+
+	if opts.AtomicSwap && runtime.GOOS == "windows" {
+		return errors.New("atomic driver swaps are not supported on windows")
+	}
+	if opts.SHA256 != "" && !validSHA256(opts.SHA256) {
+		return errors.Errorf("invalid sha256 digest: %q", opts.SHA256)
+	}
+	// registries don't publish checksums alongside artifacts, so only a pinned digest can skip a pull
+	canSkip := opts.OCIRef == "" || opts.SHA256 != ""
+	if !opts.Force && canSkip && opts.upToDate(httpClient, url, targetFilepath) {
+		opts.logger().Infof("%s already matches the expected checksum, skipping download", targetFilepath)
+		return opts.link(driver, destination)
+	}
+
+	if !opts.Quiet {
+		if opts.plain() {
+			out.String("Downloading driver %s:\n", driver)
+		} else {
+			out.T(out.Happy, "Downloading driver {{.driver}}:", out.V{"driver": driver})
+		}
+	}
+
+	action := "install"
+	if _, err := os.Lstat(targetFilepath); err == nil {
+		action = "update"
+	}
+
+	// the installed driver is only replaced once the new one is downloaded and verified,
+	// but find out now if that will be impossible
+	if err := checkReplaceable(targetFilepath); err != nil {
+		return errors.Wrapf(err, "can't replace %s, check that it is writable", targetFilepath)
+	}
+
+	stage, err := opts.stage(destination)
+	if err != nil {
+		return errors.Wrap(err, "staging directory")
+	}
+	dl.temps = append(dl.temps, stage)
+	stagedFilepath := filepath.Join(stage, driver)
+
+	// fail early rather than filling up the filesystem partway through
+	size := minDriverSize
+	if opts.OCIRef == "" {
+		if n, err := contentLength(httpClient, url); err == nil && n >= 0 {
+			size = n
+		}
+	}
+	if err := checkDownloadSpace(stage, size+opts.freeSpaceMargin(), opts.logger()); err != nil {
+		return err
+	}
+
+	hp := &hashingProgress{ProgressTracker: opts.progress()}
+	if opts.VerifySegments && opts.OCIRef == "" {
+		if hp.segments, err = fetchSegmentManifest(httpClient, url); err != nil {
+			return errors.Wrap(err, "segment manifest")
+		}
+		if hp.segments == nil {
+			opts.logger().Infof("no segment manifest for %s, only verifying the whole download", url)
+		}
+	}
+	clientOpts := []getter.ClientOption{getter.WithProgress(gatedProgress{ProgressTracker: hp, gate: dl.gate})}
+	client := &getter.Client{
+		// go-getter checks a pinned digest too, and removes a download that doesn't match it
+		Src:     opts.pinned(url),
+		Dst:     stagedFilepath,
+		Mode:    getter.ClientModeFile,
+		Getters: getters(httpClient),
+		Options: clientOpts,
+	}
+
+	start := time.Now()
+	if opts.OCIRef != "" {
+		err = pullOCIDriver(httpClient, opts.OCIRef, opts.OCIPlainHTTP, driver, stagedFilepath, opts.progress())
+	} else {
+		policy := opts.downloadRetryPolicy(url)
+		err = policy.Do(context.Background(), func() error {
+			os.Remove(stagedFilepath)
+			err := client.Get()
+			if isTimeout(err) {
+				// the stalled response is closed, but don't retry over a connection it left behind
+				closeIdleConnections(httpClient)
+			}
+			return err
+		}, isTimeout)
+		if err != nil && isTimeout(err) {
+			err = errors.Wrapf(err, "download stalled after %d attempts", policy.attempts())
+		}
+	}
+	opts.Metrics.record(PhaseDownload, time.Since(start))
+	if err != nil {
+		return errors.Wrapf(err, "can't download driver %s from: %s", driver, url)
+	}
+
+	fi, err := os.Stat(stagedFilepath)
+	if err != nil {
+		return errors.Wrap(err, "stat download")
+	}
+	if fi.Size() < minDriverSize {
+		os.Remove(stagedFilepath)
+		return errors.Errorf("downloaded driver %s from %s is only %d bytes, expected at least %d", driver, url, fi.Size(), minDriverSize)
+	}
+
+	digest, err := hp.sum(stagedFilepath)
+	if err != nil {
+		return errors.Wrap(err, "checksum download")
+	}
+	if want := strings.ToLower(opts.SHA256); want != "" && digest != want {
+		return errors.Errorf("checksum of %s did not match: expected %s, got %s", url, want, digest)
+	}
+
+	if err := opts.verify(DriverArtifact{Driver: driver, Path: stagedFilepath, Source: url, SHA256: digest, Client: httpClient}); err != nil {
+		os.Remove(stagedFilepath)
+		return err
+	}
+
+	if err := os.Chmod(stagedFilepath, 0777); err != nil {
+		return errors.Wrap(err, "chmod error")
+	}
+
+	installed := targetFilepath
+	if opts.AtomicSwap {
+		installed = filepath.Join(destination, opts.versionedFilename(driver, digest))
+	}
+	if err := moveFile(stagedFilepath, installed); err != nil {
+		return errors.Wrapf(err, "move driver into %s", destination)
+	}
+	if err := writeChecksum(installed, digest); err != nil {
+		opts.logger().Warningf("unable to record the checksum of %s: %v", installed, err)
+	}
+	if opts.AtomicSwap {
+		if err := opts.swapDriver(driver, destination, filepath.Base(installed)); err != nil {
+			return errors.Wrapf(err, "swap in %s", installed)
+		}
+	}
+	if opts.Companions {
+		if err := dl.downloadCompanions(driver, destination); err != nil {
+			return errors.Wrapf(err, "companion files of %s", driver)
+		}
+	}
+
+	if err := opts.link(driver, destination); err != nil {
+		return err
+	}
+	if opts.AuditLog != "" {
+		version := opts.Version
+		if version == "" {
+			version = opts.targetVersion
+		}
+		e := AuditEntry{Time: time.Now().UTC(), Driver: driver, Action: action, Source: redactURL(url), Version: version, SHA256: digest, Path: installed}
+		if err := appendAuditEntry(opts.AuditLog, e); err != nil {
+			return errors.Wrapf(err, "record the install of %s", driver)
+		}
+	}
+	return nil
+}
+
+// checkReplaceable returns an error if a file at path could not be replaced, without changing it
+func checkReplaceable(path string) error {
+	if fi, err := os.Lstat(path); err == nil && fi.IsDir() {
+		return errors.Errorf("%s is a directory", path)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestDownloaderClose(t *testing.T) {
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	staging := filepath.Join(tmpdir, "staging")
+
+	dl := NewDownloader(InstallOptions{Quiet: true, StagingDir: staging})
+	if err := dl.Download(kvmDriver, filepath.Join(tmpdir, "bin")); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	temps, err := filepath.Glob(filepath.Join(staging, ".download-*"))
+	if err != nil || len(temps) != 1 {
+		t.Fatalf("temp files before Close = %v, %v; want one staging directory", temps, err)
+	}
+
+	if err := dl.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(staging, ".download-*")); len(temps) != 0 {
+		t.Errorf("temp files after Close = %v, want none", temps)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "bin", kvmDriver)); err != nil {
+		t.Errorf("Close() should leave the downloaded driver: %v", err)
+	}
+}
+
+// byteCounter is a getter.ProgressTracker that counts the bytes read from downloads
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) TrackProgress(src string, currentSize, totalSize int64, stream io.ReadCloser) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{readerFunc(func(b []byte) (int, error) {
+		n, err := stream.Read(b)
+		atomic.AddInt64(&c.n, int64(n))
+		return n, err
+	}), stream}
+}
+
+// readerFunc adapts a function to io.Reader
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(b []byte) (int, error) { return f(b) }
+
+func TestDownloaderPause(t *testing.T) {
+	body := strings.Repeat("driver", 1000)
+	_, cleanup := serveDriver(t, body)
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	counter := &byteCounter{}
+	dl := NewDownloader(InstallOptions{Progress: counter})
+	defer dl.Close()
+	dl.Pause()
+	done := make(chan error, 1)
+	go func() { done <- dl.Download(kvmDriver, tmpdir) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("paused Download() returned early: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if n := atomic.LoadInt64(&counter.n); n != 0 {
+		t.Errorf("paused download read %d bytes, want 0", n)
+	}
+
+	dl.Resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Download() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("resumed download didn't complete")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(tmpdir, kvmDriver))
+	if err != nil || string(b) != body {
+		t.Errorf("driver = %d bytes, %v, want %d bytes", len(b), err, len(body))
+	}
+}
+
+func TestDownloadUnmanagedDriver(t *testing.T) {
+	_, cleanup := serveDriver(t, "#!/bin/sh\n")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	driver := "docker-machine-driver-stub"
+	defer allowDriver(driver)()
+
+	dl := NewDownloader(InstallOptions{Quiet: true})
+	defer dl.Close()
+	if err := dl.Download(kvmDriver, tmpdir); err != nil {
+		t.Errorf("Download(%s) error = %v", kvmDriver, err)
+	}
+	err := dl.Download(driver, tmpdir)
+	if errors.Cause(err) != ErrUnmanagedDriver {
+		t.Errorf("Download(%s) error = %v, want %v", driver, err, ErrUnmanagedDriver)
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, driver)); !os.IsNotExist(err) {
+		t.Errorf("expected nothing downloaded for %s, stat error = %v", driver, err)
+	}
+}
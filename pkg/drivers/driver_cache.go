@@ -0,0 +1,231 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blang/semver"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	"k8s.io/minikube/pkg/version"
+)
+
+// driverVerifyParallelism bounds how many cached drivers VerifyAllDrivers hashes at once
+const driverVerifyParallelism = 4
+
+// currentVersion returns the running minikube version, replaceable for testing
+var currentVersion = version.GetSemverVersion
+
+// PruneDriverCache removes all but the keep newest versions of each driver cached in
+// destination by InstallOptions.VersionedCache, returning the paths it removed. A version of a
+// driver is pruned as a whole, with the links to it and its companion files. The drivers
+// cached for the running minikube version are always kept.
+func PruneDriverCache(destination string, keep int) ([]string, error) {
+	if keep < 0 {
+		return nil, errors.Errorf("can't keep %d versions", keep)
+	}
+	entries, err := ioutil.ReadDir(destination)
+	if err != nil {
+		return nil, errors.Wrap(err, "read driver cache")
+	}
+	current, err := currentVersion()
+	if err != nil {
+		glog.Warningf("unable to determine the minikube version: %v", err)
+	}
+
+	// the cached versions of each driver, and the files of each driver by version
+	cached := map[string][]semver.Version{}
+	files := map[string]map[string][]string{}
+	for _, e := range entries {
+		v, err := semver.Parse(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		groups, err := cachedDriverFiles(filepath.Join(destination, e.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "read driver cache")
+		}
+		files[v.String()] = groups
+		for driver := range groups {
+			cached[driver] = append(cached[driver], v)
+		}
+	}
+
+	var removed []string
+	for driver, versions := range cached {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].GT(versions[j]) })
+		if len(versions) <= keep {
+			continue
+		}
+		for _, v := range versions[keep:] {
+			if v.Equals(current) {
+				continue
+			}
+			dir := VersionedDriverDir(destination, v)
+			for _, name := range files[v.String()][driver] {
+				p := filepath.Join(dir, name)
+				glog.Infof("Pruning cached driver file %s", p)
+				if err := os.Remove(p); err != nil {
+					return removed, errors.Wrapf(err, "remove %s", p)
+				}
+				removed = append(removed, p)
+				if err := os.Remove(p + checksumSuffix); err != nil && !os.IsNotExist(err) {
+					glog.Warningf("unable to remove %s: %v", p+checksumSuffix, err)
+				}
+			}
+			// drop the version directory once nothing is left in it
+			os.Remove(dir)
+		}
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// cachedDriverFiles returns the names of the files in the driver cache directory dir, grouped
+// by the driver they belong to: the driver binary, the other names it was saved as with
+// Filename or AtomicSwap, the links to those, and its companion files. A link only counts
+// alongside the file it points at in dir. Checksums are left out, as they go with their file.
+func cachedDriverFiles(dir string) (map[string][]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	owner := map[string]string{}
+	for driver, cs := range driverCompanions {
+		for _, c := range cs {
+			owner[c.name] = driver
+		}
+	}
+	known := append([]string{}, allowedDrivers...)
+	targets := map[string]string{}
+	var regular []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasSuffix(name, checksumSuffix) {
+			continue
+		}
+		if e.Mode()&os.ModeSymlink == 0 {
+			regular = append(regular, name)
+			continue
+		}
+		// a link names the driver that its target was saved as
+		target, err := os.Readlink(filepath.Join(dir, name))
+		if err != nil || filepath.Base(target) != target {
+			continue
+		}
+		targets[name] = target
+		if _, ok := owner[target]; !ok {
+			owner[target] = name
+		}
+		known = append(known, name)
+	}
+
+	groups := map[string][]string{}
+	group := map[string]string{}
+	for _, name := range regular {
+		driver, ok := owner[name]
+		if !ok {
+			driver = cachedDriverName(name, known)
+		}
+		groups[driver] = append(groups[driver], name)
+		group[name] = driver
+	}
+	for link, target := range targets {
+		if driver, ok := group[target]; ok {
+			groups[driver] = append(groups[driver], link)
+		}
+	}
+	for _, names := range groups {
+		sort.Strings(names)
+	}
+	return groups, nil
+}
+
+// cachedDriverName returns the driver that a cached file named name is a copy of, such as
+// docker-machine-driver-kvm2-v1.3.0 saved by AtomicSwap, going by the longest of the known
+// driver names it starts with. A file that matches none is a driver of its own.
+func cachedDriverName(name string, known []string) string {
+	driver := name
+	longest := 0
+	for _, k := range known {
+		if (name == k || strings.HasPrefix(name, k+"-")) && len(k) > longest {
+			driver, longest = k, len(k)
+		}
+	}
+	return driver
+}
+
+// VerifyAllDrivers checks every driver in destination, including those cached per version by
+// InstallOptions.VersionedCache, against the checksum recorded when it was downloaded. It returns
+// the result for the path of each driver with a recorded checksum, nil meaning that it matched.
+func VerifyAllDrivers(destination string) (map[string]error, error) {
+	var sums []string
+	for _, pattern := range []string{"*" + checksumSuffix, filepath.Join("*", "*"+checksumSuffix)} {
+		matches, err := filepath.Glob(filepath.Join(destination, pattern))
+		if err != nil {
+			return nil, errors.Wrap(err, "find cached drivers")
+		}
+		sums = append(sums, matches...)
+	}
+
+	results := map[string]error{}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, driverVerifyParallelism)
+	for _, s := range sums {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			err := verifyChecksum(path)
+			mu.Lock()
+			results[path] = err
+			mu.Unlock()
+		}(strings.TrimSuffix(s, checksumSuffix))
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// verifyChecksum returns an error unless the file at path matches the checksum recorded alongside it
+func verifyChecksum(path string) error {
+	b, err := ioutil.ReadFile(path + checksumSuffix)
+	if err != nil {
+		return errors.Wrap(err, "read checksum")
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 || !validSHA256(fields[0]) {
+		return errors.Errorf("malformed checksum file %s%s", path, checksumSuffix)
+	}
+	got, err := fileSHA256(path)
+	if err != nil {
+		return errors.Wrapf(err, "hash %s", path)
+	}
+	if hex.EncodeToString(got) != strings.ToLower(fields[0]) {
+		return errors.Errorf("%s does not match its recorded checksum %s", path, fields[0])
+	}
+	return nil
+}
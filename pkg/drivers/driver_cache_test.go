@@ -0,0 +1,177 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/blang/semver"
+	"k8s.io/minikube/pkg/minikube/tests"
+	"k8s.io/minikube/pkg/version"
+)
+
+func TestPruneDriverCache(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	for _, v := range []string{"1.1.0", "1.2.0", "1.3.0", "1.10.0"} {
+		dir := filepath.Join(tmpdir, v)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		for _, f := range []string{kvmDriver, kvmDriver + checksumSuffix} {
+			if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(v), 0755); err != nil {
+				t.Fatalf("writefile: %v", err)
+			}
+		}
+	}
+	// an old driver only cached for the running minikube must survive
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "1.1.0", "docker-machine-driver-hyperkit"), nil, 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	currentVersion = func() (semver.Version, error) { return semver.MustParse("1.1.0"), nil }
+	defer func() { currentVersion = version.GetSemverVersion }()
+
+	removed, err := PruneDriverCache(tmpdir, 2)
+	if err != nil {
+		t.Fatalf("PruneDriverCache() error = %v", err)
+	}
+	want := []string{filepath.Join(tmpdir, "1.2.0", kvmDriver)}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("PruneDriverCache() removed %v, want %v", removed, want)
+	}
+	for _, v := range []string{"1.1.0", "1.3.0", "1.10.0"} {
+		if _, err := os.Stat(filepath.Join(tmpdir, v, kvmDriver)); err != nil {
+			t.Errorf("expected %s to be kept: %v", v, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "1.2.0")); !os.IsNotExist(err) {
+		t.Errorf("expected the emptied 1.2.0 directory to be removed: %v", err)
+	}
+}
+
+func TestPruneDriverCacheGroupsFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the cache links drivers with symlinks")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	const rules = "99-kvm2.rules"
+	defer func(c map[string][]companion) { driverCompanions = c }(driverCompanions)
+	driverCompanions = map[string][]companion{kvmDriver: {{name: rules}}}
+	currentVersion = func() (semver.Version, error) { return semver.MustParse("2.0.0"), nil }
+	defer func() { currentVersion = version.GetSemverVersion }()
+
+	write := func(path string) {
+		if err := ioutil.WriteFile(path, []byte(path), 0755); err != nil {
+			t.Fatalf("writefile: %v", err)
+		}
+		if err := writeChecksum(path, fmt.Sprintf("%x", sha256.Sum256([]byte(path)))); err != nil {
+			t.Fatalf("writeChecksum() error = %v", err)
+		}
+	}
+	for _, v := range []string{"1.1.0", "1.2.0", "1.3.0"} {
+		dir := filepath.Join(tmpdir, v)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if v == "1.3.0" {
+			write(filepath.Join(dir, kvmDriver))
+			continue
+		}
+		// older versions were saved under another name, linked from the driver name, with a companion
+		write(filepath.Join(dir, kvmDriver+"-v"+v))
+		if err := os.Symlink(kvmDriver+"-v"+v, filepath.Join(dir, kvmDriver)); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+		write(filepath.Join(dir, rules))
+	}
+
+	removed, err := PruneDriverCache(tmpdir, 1)
+	if err != nil {
+		t.Fatalf("PruneDriverCache() error = %v", err)
+	}
+	var want []string
+	for _, v := range []string{"1.1.0", "1.2.0"} {
+		want = append(want,
+			filepath.Join(tmpdir, v, rules),
+			filepath.Join(tmpdir, v, kvmDriver),
+			filepath.Join(tmpdir, v, kvmDriver+"-v"+v))
+	}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("PruneDriverCache() removed %v, want %v", removed, want)
+	}
+	for _, v := range []string{"1.1.0", "1.2.0"} {
+		if _, err := os.Stat(filepath.Join(tmpdir, v)); !os.IsNotExist(err) {
+			t.Errorf("expected the emptied %s directory to be removed: %v", v, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpdir, "1.3.0", kvmDriver)); err != nil {
+		t.Errorf("expected the newest driver to be kept: %v", err)
+	}
+}
+
+func TestVerifyAllDrivers(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	var paths []string
+	for _, p := range []string{kvmDriver, "docker-machine-driver-hyperkit", filepath.Join("1.3.0", kvmDriver)} {
+		path := filepath.Join(tmpdir, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p), 0755); err != nil {
+			t.Fatalf("writefile: %v", err)
+		}
+		if err := writeChecksum(path, fmt.Sprintf("%x", sha256.Sum256([]byte(p)))); err != nil {
+			t.Fatalf("writeChecksum() error = %v", err)
+		}
+		paths = append(paths, path)
+	}
+	corrupt := paths[1]
+	if err := ioutil.WriteFile(corrupt, []byte("corrupted"), 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	// drivers without a recorded checksum can't be verified, and are left out
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "docker-machine-driver-other"), nil, 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	results, err := VerifyAllDrivers(tmpdir)
+	if err != nil {
+		t.Fatalf("VerifyAllDrivers() error = %v", err)
+	}
+	if len(results) != len(paths) {
+		t.Errorf("VerifyAllDrivers() checked %d drivers, want %d: %v", len(results), len(paths), results)
+	}
+	for _, p := range paths {
+		err, ok := results[p]
+		if !ok {
+			t.Errorf("%s was not verified", p)
+			continue
+		}
+		if wantErr := p == corrupt; (err != nil) != wantErr {
+			t.Errorf("%s verification error = %v, wantErr %v", p, err, wantErr)
+		}
+	}
+}
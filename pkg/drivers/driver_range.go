@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+
+	"k8s.io/minikube/pkg/minikube/constants"
+	"k8s.io/minikube/pkg/version"
+)
+
+// driverReleasesURL lists the minikube releases, whose drivers are published alongside them, a var for testing
+var driverReleasesURL = constants.GithubMinikubeReleasesURL
+
+// releaseVersions returns the versions listed by the releases JSON at url
+func releaseVersions(client *http.Client, url string) ([]semver.Version, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("get %s: %s", url, resp.Status)
+	}
+	var releases []struct {
+		Name string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", url)
+	}
+	var versions []semver.Version
+	for _, r := range releases {
+		if v, err := semver.Make(strings.TrimPrefix(r.Name, version.VersionPrefix)); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+// rangeVersion returns the driver version to download to satisfy VersionRange: the pinned
+// Version, or else the newest release inside the range, falling back to minikubeVersion if the
+// releases can't be listed. Pre-releases are only considered off the stable channel.
+func (o InstallOptions) rangeVersion(driver string, minikubeVersion semver.Version) (semver.Version, error) {
+	if o.Version != "" {
+		v, err := semver.Make(strings.TrimPrefix(o.Version, version.VersionPrefix))
+		if err != nil {
+			return v, errors.Wrapf(err, "can't parse driver version %s", o.Version)
+		}
+		if !o.VersionRange(v) {
+			return v, errors.Errorf("%s %s is outside the allowed driver version range", driver, o.Version)
+		}
+		return v, nil
+	}
+	c, err := o.channel()
+	if err != nil {
+		return minikubeVersion, err
+	}
+
+	versions, err := releaseVersions(o.httpClient(), driverReleasesURL)
+	if err != nil {
+		o.logger().Warningf("unable to list driver releases, trying %s %s: %v", driver, minikubeVersion, err)
+	}
+	var newest *semver.Version
+	for i, v := range versions {
+		if !o.VersionRange(v) || (len(v.Pre) > 0 && c == ChannelStable) {
+			continue
+		}
+		if newest == nil || v.GT(*newest) {
+			newest = &versions[i]
+		}
+	}
+	if newest != nil {
+		return *newest, nil
+	}
+	if !o.VersionRange(minikubeVersion) {
+		return minikubeVersion, errors.Errorf("%s %s is outside the allowed driver version range, and no release inside it can be downloaded", driver, minikubeVersion)
+	}
+	return minikubeVersion, nil
+}
+
+// checkInstalledInRange returns an error unless the driver installed in destination reports a
+// version inside VersionRange, e.g. because the release track served another version
+func (o InstallOptions) checkInstalledInRange(driver, destination string) error {
+	bin := filepath.Join(destination, o.filename(driver))
+	output, err := o.driverVersionOutput(bin)
+	if err != nil {
+		return errors.Wrapf(err, "run %s version", bin)
+	}
+	s := ExtractVMDriverVersion(string(output))
+	v, err := semver.Make(strings.TrimPrefix(s, version.VersionPrefix))
+	if err != nil {
+		return errors.Wrapf(err, "can't parse the version of the downloaded %s", driver)
+	}
+	if !o.VersionRange(v) {
+		return errors.Errorf("downloaded %s %s is outside the allowed driver version range", driver, s)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// DriverArtifact is a downloaded driver awaiting verification, before it is installed
+type DriverArtifact struct {
+	// Driver is the name of the driver
+	Driver string
+	// Path is where the downloaded driver is staged
+	Path string
+	// Source is the URL, or OCI reference, the driver was downloaded from
+	Source string
+	// SHA256 is the hex sha256 digest of the downloaded driver
+	SHA256 string
+	// Client is the HTTP client the driver was downloaded with, for fetching signatures alongside it
+	Client *http.Client
+}
+
+// DriverVerifier checks the provenance of a downloaded driver, such as its signature, returning
+// an error if it can't be trusted
+type DriverVerifier func(a DriverArtifact) error
+
+// verify runs the Verifier on a, failing closed if RequireVerification is set
+func (o InstallOptions) verify(a DriverArtifact) error {
+	if o.Verifier == nil {
+		if o.RequireVerification {
+			return errors.Errorf("verification of %s is required, but no verifier is set", a.Driver)
+		}
+		return nil
+	}
+	if err := o.Verifier(a); err != nil {
+		if o.RequireVerification {
+			return errors.Wrapf(err, "verify %s", a.Driver)
+		}
+		o.logger().Warningf("unable to verify %s, installing it anyway: %v", a.Driver, err)
+	}
+	return nil
+}
+
+// cosign is the sigstore tool CosignVerifier verifies signatures with
+const cosign = "cosign"
+
+// cosignBundleSuffix is appended to a download URL to find the cosign bundle published for it
+const cosignBundleSuffix = ".bundle"
+
+// CosignVerifier returns a DriverVerifier that checks the keyless cosign bundle published
+// alongside each driver, with the .bundle suffix, using the cosign tool. The signing
+// certificate must name identity and have been issued for oidcIssuer, e.g. the release
+// workflow and https://token.actions.githubusercontent.com. Requires cosign on PATH.
+func CosignVerifier(identity, oidcIssuer string) DriverVerifier {
+	return func(a DriverArtifact) error {
+		if _, err := exec.LookPath(cosign); err != nil {
+			return errors.Wrap(err, "keyless verification requires cosign")
+		}
+		bundle := a.Path + cosignBundleSuffix
+		if err := fetchFile(a.Client, a.Source+cosignBundleSuffix, bundle); err != nil {
+			return errors.Wrap(err, "fetch cosign bundle")
+		}
+		defer os.Remove(bundle)
+		args := []string{"verify-blob", "--bundle", bundle, "--certificate-identity", identity, "--certificate-oidc-issuer", oidcIssuer, a.Path}
+		if out, err := exec.Command(cosign, args...).CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "cosign verify-blob: %s", out)
+		}
+		return nil
+	}
+}
+
+// fetchFile downloads url to path
+func fetchFile(client *http.Client, url, path string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s: %s", url, resp.Status)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// bundles are a few kilobytes, anything much bigger is not one
+	if _, err := io.Copy(f, io.LimitReader(resp.Body, 1<<20)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
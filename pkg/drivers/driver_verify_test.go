@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestDownloadVerifier(t *testing.T) {
+	body := "#!/bin/sh\necho version: v1.3.0\n"
+	_, cleanup := serveDriver(t, body)
+	defer cleanup()
+	sum := sha256.Sum256([]byte(body))
+	accept := func(a DriverArtifact) error {
+		if a.Driver != kvmDriver || a.SHA256 != hex.EncodeToString(sum[:]) {
+			return errors.Errorf("unexpected artifact %+v", a)
+		}
+		if _, err := os.Stat(a.Path); err != nil {
+			return err
+		}
+		return nil
+	}
+	reject := func(a DriverArtifact) error { return errors.New("untrusted signer") }
+
+	var tcs = []struct {
+		desc          string
+		verifier      DriverVerifier
+		required      bool
+		wantErr       bool
+		wantInstalled bool
+		wantWarning   bool
+	}{
+		{desc: "accepted", verifier: accept, required: true, wantInstalled: true},
+		{desc: "rejected", verifier: reject, required: true, wantErr: true},
+		{desc: "rejected but not required", verifier: reject, wantInstalled: true, wantWarning: true},
+		{desc: "required without a verifier", required: true, wantErr: true},
+		{desc: "not verified", wantInstalled: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+			log := &recordingLogger{}
+			opts := InstallOptions{Quiet: true, Verifier: tc.verifier, RequireVerification: tc.required, Logger: log}
+			if err := download(kvmDriver, tmpdir, opts); (err != nil) != tc.wantErr {
+				t.Fatalf("download() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			_, err := os.Stat(filepath.Join(tmpdir, kvmDriver))
+			if installed := err == nil; installed != tc.wantInstalled {
+				t.Errorf("driver installed = %v, want %v", installed, tc.wantInstalled)
+			}
+			warned := strings.Contains(strings.Join(log.lines, "\n"), "W unable to verify")
+			if warned != tc.wantWarning {
+				t.Errorf("warned about the failed verification = %v, want %v: %v", warned, tc.wantWarning, log.lines)
+			}
+		})
+	}
+}
+
+func TestCosignVerifierWithoutCosign(t *testing.T) {
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+	if err := CosignVerifier("release@example.com", "https://issuer.example.com")(DriverArtifact{Driver: kvmDriver}); err == nil {
+		t.Error("CosignVerifier() succeeded without cosign")
+	}
+}
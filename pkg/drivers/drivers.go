@@ -17,37 +17,130 @@ limitations under the License.
 package drivers
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/blang/semver"
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/mcnflag"
 	"github.com/docker/machine/libmachine/mcnutils"
-	"github.com/docker/machine/libmachine/ssh"
 	"github.com/golang/glog"
-	"github.com/hashicorp/go-getter"
 	"github.com/pkg/errors"
 	"k8s.io/minikube/pkg/version"
 
+	"k8s.io/minikube/pkg/minikube/constants"
 	"k8s.io/minikube/pkg/minikube/out"
 	"k8s.io/minikube/pkg/util"
 )
 
+// driverKVMDownloadURL is where the kvm2 driver is downloaded from, a var for testing
+var driverKVMDownloadURL = "https://storage.googleapis.com/minikube/releases/latest/docker-machine-driver-kvm2"
+
 const (
-	driverKVMDownloadURL = "https://storage.googleapis.com/minikube/releases/latest/docker-machine-driver-kvm2"
+	// isoFilename is the name mcnutils gives the ISO inside the machine directory
+	isoFilename = "boot2docker.iso"
 )
 
-// GetDiskPath returns the path of the machine disk image
+// GetDiskPath returns the path of the machine disk image, which is the growable qcow2 image if the machine has one
 func GetDiskPath(d *drivers.BaseDriver) string {
-	return filepath.Join(d.ResolveStorePath("."), d.GetMachineName()+".rawdisk")
+	if p := qcow2DiskPath(d); fileExists(p) {
+		return p
+	}
+	return filepath.Join(d.ResolveStorePath("."), diskFilename(d.GetMachineName()))
+}
+
+// DefaultDiskFilenamePattern is the name of raw machine disks unless SetDiskFilenamePattern is called
+const DefaultDiskFilenamePattern = "{{.MachineName}}.rawdisk"
+
+// diskFilenameTemplate renders the name of raw machine disks within the machine directory
+var diskFilenameTemplate = template.Must(template.New("disk").Parse(DefaultDiskFilenamePattern))
+
+// SetDiskFilenamePattern changes the name GetDiskPath gives raw machine disks, e.g. "disk.img"
+// for tooling that expects it. The pattern is a text/template that may use {{.MachineName}}, and
+// must render a plain filename within the machine directory. Growable disks take the same name
+// with a .qcow2 extension. It only applies to this process, so driver plugins that build their
+// own disks must set it too, and should be called before any machine is created.
+func SetDiskFilenamePattern(pattern string) error {
+	tmpl, err := template.New("disk").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return errors.Wrapf(err, "parse disk filename pattern %q", pattern)
+	}
+	name, err := renderDiskFilename(tmpl, "minikube")
+	if err != nil {
+		return errors.Wrapf(err, "render disk filename pattern %q", pattern)
+	}
+	if err := validateDiskFilename(name); err != nil {
+		return errors.Wrapf(err, "disk filename pattern %q", pattern)
+	}
+	diskFilenameTemplate = tmpl
+	return nil
+}
+
+// renderDiskFilename renders tmpl for machineName
+func renderDiskFilename(tmpl *template.Template, machineName string) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, struct{ MachineName string }{machineName}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validateDiskFilename returns an error unless name can safely be created in the machine
+// directory without escaping it or clobbering another of the machine's files
+func validateDiskFilename(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") || filepath.Base(name) != name {
+		return errors.Errorf("%q is not a plain filename", name)
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if name == isoFilename || ext == ".qcow2" || ext == ".json" || ext == ".pub" || strings.HasPrefix(name, "id_") {
+		return errors.Errorf("%q clashes with the other files of a machine", name)
+	}
+	return nil
+}
+
+// diskFilename returns the name of machineName's raw disk within its machine directory
+func diskFilename(machineName string) string {
+	name, err := renderDiskFilename(diskFilenameTemplate, machineName)
+	if err != nil || validateDiskFilename(name) != nil {
+		// machine names are validated by libmachine, so only an odd one could get here
+		return machineName + ".rawdisk"
+	}
+	return name
+}
+
+// DiskExists returns whether the machine's disk image exists. Errors other than the disk
+// not existing, such as a store path that can't be read, are returned rather than taken to
+// mean there is no disk.
+func DiskExists(d *drivers.BaseDriver) (bool, error) {
+	p := GetDiskPath(d)
+	_, err := os.Stat(p)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, errors.Wrapf(err, "stat disk %s", p)
+}
+
+// fileExists returns whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 // CommonDriver is the common driver base class
@@ -63,34 +156,107 @@ func (d *CommonDriver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	return nil
 }
 
-func createRawDiskImage(sshKeyPath, diskPath string, diskSizeMb int) error {
-	tarBuf, err := mcnutils.MakeDiskImage(sshKeyPath)
-	if err != nil {
-		return errors.Wrap(err, "make disk image")
+// The driver flags checked by ValidateFlags
+const (
+	// FlagDiskSize is the disk size, in megabytes
+	FlagDiskSize = "disk-size"
+	// FlagMemory is the memory size, in megabytes
+	FlagMemory = "memory"
+	// FlagCPUs is the number of CPUs
+	FlagCPUs = "cpus"
+	// FlagSSHKeyBits is the size of the generated RSA machine key
+	FlagSSHKeyBits = "ssh-key-bits"
+)
+
+// ValidateFlags checks the common invariants of flags before create, so that invalid settings
+// fail early rather than deep in the underlying driver. All problems are reported together.
+// Unset flags, which read as zero, are not checked.
+func (d *CommonDriver) ValidateFlags(flags drivers.DriverOptions) error {
+	var problems []string
+	// the minimum disk size depends on the driver, so it is checked by the CLI, see MinimumDiskSizeMB
+	if disk := flags.Int(FlagDiskSize); disk < 0 {
+		problems = append(problems, fmt.Sprintf("requested disk size of %dMB, must be positive", disk))
 	}
+	if mem, min := flags.Int(FlagMemory), util.CalculateSizeInMB(constants.MinimumMemorySize); mem != 0 && mem < min {
+		problems = append(problems, fmt.Sprintf("requested memory of %dMB is less than the minimum of %dMB", mem, min))
+	}
+	if cpus := flags.Int(FlagCPUs); cpus < 0 {
+		problems = append(problems, fmt.Sprintf("requested %d CPUs, must be at least 1", cpus))
+	} else if cpus > runtime.NumCPU() {
+		problems = append(problems, fmt.Sprintf("requested %d CPUs, but the host only has %d", cpus, runtime.NumCPU()))
+	}
+	if bits := flags.Int(FlagSSHKeyBits); bits != 0 {
+		if err := validateSSHKeyBits(bits); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("invalid driver flags: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func createRawDiskImage(sshKeyPath, diskPath string, diskSizeMb, sectorSize int, extraAuthorizedKeys []string) error {
+	return createFilledDiskImage(context.Background(), sshKeyPath, diskPath, diskSizeMb, sectorSize, extraAuthorizedKeys, 0, "", nil)
+}
 
+// createFilledDiskImage is createRawDiskImage, but marks swapSizeMb of the disk for guest swap
+// and bakes in hostname if set, and writes fill over the disk after the tar rather than leaving
+// a hole, unless fill is empty. Filling stops early once ctx is done.
+func createFilledDiskImage(ctx context.Context, sshKeyPath, diskPath string, diskSizeMb, sectorSize int, extraAuthorizedKeys []string, swapSizeMb int, hostname string, fill []byte) error {
 	file, err := os.OpenFile(diskPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 	if err != nil {
 		return errors.Wrap(err, "open")
 	}
 	defer file.Close()
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return errors.Wrap(err, "seek")
-	}
 
-	if _, err := file.Write(tarBuf.Bytes()); err != nil {
-		return errors.Wrap(err, "write tar")
+	// Stream the tar straight to the disk rather than holding it in memory
+	if err := writeDiskTar(file, sshKeyPath, extraAuthorizedKeys, swapSizeMb, hostname); err != nil {
+		file.Close()
+		os.Remove(diskPath)
+		return errors.Wrap(err, "make disk image")
+	}
+	if len(fill) > 0 {
+		if err := fillDisk(ctx, file, diskSizeBytes(diskSizeMb, sectorSize), fill); err != nil {
+			file.Close()
+			os.Remove(diskPath)
+			return errors.Wrap(err, "fill disk")
+		}
 	}
 	if err := file.Close(); err != nil {
 		return errors.Wrapf(err, "closing file %s", diskPath)
 	}
 
-	if err := os.Truncate(diskPath, int64(diskSizeMb*1000000)); err != nil {
+	if err := os.Truncate(diskPath, diskSizeBytes(diskSizeMb, sectorSize)); err != nil {
 		return errors.Wrap(err, "truncate")
 	}
 	return nil
 }
 
+// fillDisk repeats pattern from the current offset of file up to size bytes, the last copy cut short as needed
+func fillDisk(ctx context.Context, file *os.File, size int64, pattern []byte) error {
+	off, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	chunk := bytes.Repeat(pattern, copyChunkSize/len(pattern)+1)
+	for off < size {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// keep the pattern aligned to the start of the fill across chunks
+		n := int64(len(chunk) - len(chunk)%len(pattern))
+		if n > size-off {
+			n = size - off
+		}
+		if _, err := file.Write(chunk[:n]); err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
+
 func publicSSHKeyPath(d *drivers.BaseDriver) string {
 	return d.GetSSHKeyPath() + ".pub"
 }
@@ -105,38 +271,527 @@ func Restart(d drivers.Driver) error {
 	return d.Start()
 }
 
+// chown is os.Chown, replaceable for testing
+var chown = os.Chown
+
+// Ownership is the user and group that own a machine directory and its files
+type Ownership struct {
+	// UID is the user ID to chown to
+	UID int
+	// GID is the group ID to chown to
+	GID int
+}
+
+// PermissionsStrategy is how fixPermissions sets the modes of a machine directory and its files
+type PermissionsStrategy string
+
+const (
+	// PermissionsDefault only fixes ownership, leaving modes as they were created
+	PermissionsDefault PermissionsStrategy = ""
+	// PermissionsStrict makes the directory and its files accessible to their owner only
+	PermissionsStrict PermissionsStrategy = "strict"
+	// PermissionsGroupReadable lets the group read the directory and its files, e.g. for a shared
+	// hypervisor account, except files that are private to their owner, such as the SSH key
+	PermissionsGroupReadable PermissionsStrategy = "group-readable"
+)
+
+// modes returns the mode a directory should have, and the mode a file currently at mode should have
+func (s PermissionsStrategy) modes(mode os.FileMode) (os.FileMode, os.FileMode) {
+	switch s {
+	case PermissionsStrict:
+		return 0700, mode.Perm() &^ 0077
+	case PermissionsGroupReadable:
+		if mode.Perm()&0077 == 0 {
+			return 0750, mode.Perm()
+		}
+		return 0750, mode.Perm()&^0027 | 0040
+	}
+	return 0, mode.Perm()
+}
+
+// validatePermissionsStrategy returns an error unless s is one of the known strategies
+func validatePermissionsStrategy(s PermissionsStrategy) error {
+	switch s {
+	case PermissionsDefault, PermissionsStrict, PermissionsGroupReadable:
+		return nil
+	}
+	return errors.Errorf("unknown permissions strategy %q, must be %s or %s", s, PermissionsStrict, PermissionsGroupReadable)
+}
+
+// defaultMinDiskSizeMB is the minimum disk size for drivers without an entry in minDiskSizeMB
+const defaultMinDiskSizeMB = 2000
+
+// minDiskSizeMB is the smallest disk, in megabytes, that each driver's guest will boot from
+var minDiskSizeMB = map[string]int{
+	constants.DriverKvm2:     2000,
+	constants.DriverHyperkit: 2000,
+}
+
+// MinimumDiskSizeMB returns the smallest disk size, in megabytes, supported by driver, for
+// callers such as the CLI to enforce unless told otherwise. There is no minimum without a driver
+// name. MakeDiskImage does not enforce it, so that it can be bypassed.
+func MinimumDiskSizeMB(driver string) int {
+	if driver == "" {
+		return 0
+	}
+	if min, ok := minDiskSizeMB[driver]; ok {
+		return min
+	}
+	return defaultMinDiskSizeMB
+}
+
+// diskSizeRE matches a disk size: a whole number with an optional unit suffix
+var diskSizeRE = regexp.MustCompile(`^([0-9]+)\s*([a-z]*)$`)
+
+// diskSizeUnits are the bytes in each unit ParseDiskSize accepts
+var diskSizeUnits = map[string]int64{
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+}
+
+// ParseDiskSize parses a disk size such as "20g" or "20000mb" into the megabytes of 2^20 bytes
+// that MakeDiskImage takes. The units are b, k, m and g, optionally followed by b or ib, in any case, and are
+// powers of two. A bare number is in megabytes. The size must be a whole number of megabytes.
+func ParseDiskSize(s string) (mb int, err error) {
+	m := diskSizeRE.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, errors.Errorf("invalid disk size %q, want a whole number with an optional unit, such as 20g or 20000mb", s)
+	}
+	unit := m[2]
+	if unit == "" {
+		unit = "m"
+	}
+	mult, ok := diskSizeUnits[unit]
+	if !ok {
+		return 0, errors.Errorf("invalid disk size %q, unknown unit %q, want one of b, k, m or g", s, m[2])
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n > math.MaxInt64/mult {
+		return 0, errors.Errorf("invalid disk size %q, too large", s)
+	}
+	size := n * mult
+	if size == 0 {
+		return 0, errors.Errorf("invalid disk size %q, must be more than zero", s)
+	}
+	if size%(1<<20) != 0 {
+		return 0, errors.Errorf("invalid disk size %q, not a whole number of megabytes", s)
+	}
+	if size>>20 > math.MaxInt32 {
+		return 0, errors.Errorf("invalid disk size %q, too large", s)
+	}
+	return int(size >> 20), nil
+}
+
+// hostnameLabelRE matches a label of an RFC 1123 hostname
+var hostnameLabelRE = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// validateHostname returns an error unless name is a valid RFC 1123 hostname
+func validateHostname(name string) error {
+	if len(name) > 253 {
+		return errors.Errorf("invalid hostname %q, longer than 253 characters", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabelRE.MatchString(label) {
+			return errors.Errorf("invalid hostname %q, each dot-separated part must be 1 to 63 letters, digits or hyphens, not starting or ending with a hyphen", name)
+		}
+	}
+	return nil
+}
+
+// validateSwapSize returns an error unless swapSizeMb is unset, or positive and less than diskSizeMb
+func validateSwapSize(swapSizeMb, diskSizeMb int) error {
+	if swapSizeMb < 0 {
+		return errors.Errorf("invalid swap size %dMB", swapSizeMb)
+	}
+	if swapSizeMb > 0 && swapSizeMb >= diskSizeMb {
+		return errors.Errorf("swap size of %dMB must be less than the disk size of %dMB", swapSizeMb, diskSizeMb)
+	}
+	return nil
+}
+
+// DefaultSectorSize is the logical sector size of disks built without DiskImageOptions.SectorSize
+const DefaultSectorSize = 512
+
+// validateSectorSize returns an error unless size is unset, or a power of two of at least DefaultSectorSize
+func validateSectorSize(size int) error {
+	if size == 0 || (size >= DefaultSectorSize && size&(size-1) == 0) {
+		return nil
+	}
+	return errors.Errorf("invalid sector size %d, must be a power of two of at least %d", size, DefaultSectorSize)
+}
+
+// bytesPerMB is the size of the megabytes that disk sizes are given in. They are powers of two,
+// as ParseDiskSize reads them.
+const bytesPerMB = 1 << 20
+
+// diskSizeBytes returns the size of a diskSizeMb disk, rounded up to a whole number of sectors if sectorSize is set
+func diskSizeBytes(diskSizeMb, sectorSize int) int64 {
+	size := int64(diskSizeMb) * bytesPerMB
+	if sectorSize <= 0 {
+		return size
+	}
+	sector := int64(sectorSize)
+	return (size + sector - 1) / sector * sector
+}
+
+// DiskImageOptions are the optional settings used when making a disk image
+type DiskImageOptions struct {
+	// Owner overrides the ownership of the machine directory. Defaults to the invoking user.
+	Owner *Ownership
+	// IDMap translates the owner ids before chown, e.g. into a rootless docker user namespace
+	IDMap func(uid, gid int) (int, int)
+	// GrowExisting grows an existing disk that is smaller than requested, rather than only warning.
+	// Disks are never shrunk.
+	GrowExisting bool
+	// ValidateLayout, if set, inspects a newly created disk and warns about any problems it reports
+	ValidateLayout LayoutValidator
+	// SSHKeyBits is the size of the generated RSA machine key. Defaults to DefaultSSHKeyBits.
+	SSHKeyBits int
+	// SSHKeyComment, if set, identifies the generated public key in agents and authorized_keys
+	// files, e.g. "minikube-<profile>@<host>". It must be a single line.
+	SSHKeyComment string
+	// Metrics, if set, receives the duration of each phase of the build
+	Metrics MetricsSink
+	// ExtraAuthorizedKeys are SSH public keys, e.g. of operators, to authorize alongside the machine key
+	ExtraAuthorizedKeys []string
+	// SectorSize is the logical sector size in bytes that the guest expects, e.g. 4096. The disk
+	// size is rounded up to a multiple of it, and it is recorded in the disk metadata.
+	// Defaults to DefaultSectorSize, without rounding.
+	SectorSize int
+	// Format is DiskFormatRaw, the default, or DiskFormatQcow2 for a thin provisioned disk that
+	// only takes up what the guest uses on the host, up to the disk size. qcow2 requires qemu-img.
+	Format string
+	// SkipPermissionFix leaves the ownership of the machine directory alone, e.g. where the store
+	// path already belongs to a dedicated account, or is on a mount that rejects chown
+	SkipPermissionFix bool
+	// ISOCacheDir, if set, is a cache of ISOs by content shared between machines, so that a
+	// machine booting an ISO another has already fetched gets a link or copy of the cached one.
+	ISOCacheDir string
+	// DebugFillPattern, if set, is repeated over the raw disk after the boot2docker tar in place of
+	// a hole, so that what the guest writes stands out when debugging. The whole disk is written,
+	// so it is meant for small test disks only.
+	DebugFillPattern []byte
+	// SwapSizeMB, if set, is how much disk the guest should set aside for swap. The disk is made
+	// that much bigger than the requested size, which it must be less than, and a swap marker in
+	// the boot2docker tar tells the guest init how much to activate.
+	SwapSizeMB int
+	// Permissions is how the modes of the machine directory and its files are set alongside their
+	// ownership. Defaults to PermissionsDefault, which leaves them alone. Ignored on Windows.
+	Permissions PermissionsStrategy
+	// Hostname, if set, is baked into the boot2docker tar for the guest init to name the VM,
+	// e.g. to tell the nodes of a cluster apart. It must be a valid RFC 1123 hostname.
+	Hostname string
+	// Passphrase, if set, returns the passphrase to encrypt the disk at rest with using LUKS.
+	// Only qcow2 disks can be encrypted. The passphrase is handed to qemu-img over a pipe, and
+	// is never logged or written to disk.
+	Passphrase func() ([]byte, error)
+	// VerifySegments checks an ISO fetched over HTTP(S) against the per-segment digests published
+	// alongside it with the .segments suffix as it streams in, failing at the first bad segment
+	// rather than once a large ISO has finished. An ISO without a manifest is copied as usual.
+	VerifySegments bool
+}
+
+// sshKeyBits returns the size of RSA machine key to generate
+func (o DiskImageOptions) sshKeyBits() int {
+	if o.SSHKeyBits == 0 {
+		return DefaultSSHKeyBits
+	}
+	return o.SSHKeyBits
+}
+
+// sectorSize returns the logical sector size of the disk
+func (o DiskImageOptions) sectorSize() int {
+	if o.SectorSize == 0 {
+		return DefaultSectorSize
+	}
+	return o.SectorSize
+}
+
+// ownership returns the uid and gid that machine files should be chowned to
+func (o DiskImageOptions) ownership() (int, int) {
+	uid, gid := syscall.Getuid(), syscall.Getegid()
+	if o.Owner != nil {
+		uid, gid = o.Owner.UID, o.Owner.GID
+	}
+	if o.IDMap != nil {
+		uid, gid = o.IDMap(uid, gid)
+	}
+	return uid, gid
+}
+
+// copyIsoToMachineDir copies the boot2docker ISO into the machine directory, replaceable for testing
+var copyIsoToMachineDir = func(storePath, isoURL, machineName string) error {
+	return mcnutils.NewB2dUtils(storePath).CopyIsoToMachineDir(isoURL, machineName)
+}
+
 // MakeDiskImage makes a boot2docker VM disk image.
 func MakeDiskImage(d *drivers.BaseDriver, boot2dockerURL string, diskSize int) error {
+	return MakeDiskImageContext(context.Background(), d, boot2dockerURL, diskSize, DiskImageOptions{})
+}
+
+// MakeDiskImageWithOptions makes a boot2docker VM disk image using the given options.
+func MakeDiskImageWithOptions(d *drivers.BaseDriver, boot2dockerURL string, diskSize int, opts DiskImageOptions) error {
+	return MakeDiskImageContext(context.Background(), d, boot2dockerURL, diskSize, opts)
+}
+
+// MakeDiskImageContext makes a boot2docker VM disk image, giving up as soon as ctx is done.
+// On cancellation, any files created by this call are removed and ctx.Err() is returned.
+func MakeDiskImageContext(ctx context.Context, d *drivers.BaseDriver, boot2dockerURL string, diskSize int, opts DiskImageOptions) (err error) {
+	if err := validateSSHKeyBits(opts.sshKeyBits()); err != nil {
+		return err
+	}
+	if err := validateSSHKeyComment(opts.SSHKeyComment); err != nil {
+		return err
+	}
+	if err := validateAuthorizedKeys(opts.ExtraAuthorizedKeys); err != nil {
+		return err
+	}
+	if err := validateSectorSize(opts.SectorSize); err != nil {
+		return err
+	}
+	if opts.Format != "" && opts.Format != DiskFormatRaw && opts.Format != DiskFormatQcow2 {
+		return errors.Errorf("unsupported disk format %q, must be %s or %s", opts.Format, DiskFormatRaw, DiskFormatQcow2)
+	}
+	if len(opts.DebugFillPattern) > 0 && opts.Format == DiskFormatQcow2 {
+		return errors.Errorf("only %s disks can be filled with a pattern", DiskFormatRaw)
+	}
+	if err := validateSwapSize(opts.SwapSizeMB, diskSize); err != nil {
+		return err
+	}
+	if err := validatePermissionsStrategy(opts.Permissions); err != nil {
+		return err
+	}
+	if opts.Hostname != "" {
+		if err := validateHostname(opts.Hostname); err != nil {
+			return err
+		}
+	}
+	if opts.Passphrase != nil && opts.Format != DiskFormatQcow2 {
+		return errors.Errorf("encryption is only supported for %s disks, not %s", DiskFormatQcow2, DiskFormatRaw)
+	}
+
+	var created artifacts
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			created.remove()
+			err = ctx.Err()
+		}
+	}()
+
 	glog.Infof("Making disk image using store path: %s", d.StorePath)
-	b2 := mcnutils.NewB2dUtils(d.StorePath)
-	if err := b2.CopyIsoToMachineDir(boot2dockerURL, d.MachineName); err != nil {
-		return errors.Wrap(err, "copy iso to machine dir")
+	created.track(d.ResolveStorePath(isoFilename))
+	if err := withContext(ctx, opts.Metrics.timed(PhaseISOCopy, func() error {
+		if opts.ISOCacheDir != "" {
+			return copyISOCached(ctx, d, boot2dockerURL, opts)
+		}
+		return copyISOContext(ctx, d, boot2dockerURL, opts)
+	})); err != nil {
+		return err
 	}
 
 	keyPath := d.GetSSHKeyPath()
 	glog.Infof("Creating ssh key: %s...", keyPath)
-	if err := ssh.GenerateSSHKey(keyPath); err != nil {
+	created.track(keyPath)
+	created.track(publicSSHKeyPath(d))
+	if err := withContext(ctx, opts.Metrics.timed(PhaseKeyGen, func() error {
+		return generateSSHKey(keyPath, opts.sshKeyBits(), opts.SSHKeyComment)
+	})); err != nil {
 		return errors.Wrap(err, "generate ssh key")
 	}
+	if err := EnsureSSHKeyPermissions(d); err != nil {
+		return errors.Wrap(err, "ssh key permissions")
+	}
 
+	// The swap comes on top of the requested size, so that the guest filesystem still gets all of it
+	diskSize += opts.SwapSizeMB
 	diskPath := GetDiskPath(d)
-	glog.Infof("Creating raw disk image: %s...", diskPath)
-	if _, err := os.Stat(diskPath); os.IsNotExist(err) {
-		if err := createRawDiskImage(publicSSHKeyPath(d), diskPath, diskSize); err != nil {
-			return errors.Wrapf(err, "createRawDiskImage(%s)", diskPath)
+	create := func() error {
+		return createFilledDiskImage(ctx, publicSSHKeyPath(d), diskPath, diskSize, opts.SectorSize, opts.ExtraAuthorizedKeys, opts.SwapSizeMB, opts.Hostname, opts.DebugFillPattern)
+	}
+	if opts.Format == DiskFormatQcow2 {
+		diskPath = qcow2DiskPath(d)
+		create = func() error {
+			return createQcow2DiskImage(ctx, publicSSHKeyPath(d), diskPath, diskSize, opts.SectorSize, opts.ExtraAuthorizedKeys, opts.SwapSizeMB, opts.Hostname)
+		}
+		if opts.Passphrase != nil {
+			create = func() error {
+				return createEncryptedQcow2DiskImage(ctx, publicSSHKeyPath(d), diskPath, diskSize, opts.SectorSize, opts.ExtraAuthorizedKeys, opts.SwapSizeMB, opts.Hostname, opts.Passphrase)
+			}
+		}
+	}
+	glog.Infof("Creating disk image: %s...", diskPath)
+	fi, statErr := os.Stat(diskPath)
+	if statErr == nil {
+		// a growable disk is resized by the guest, and its file size is not the size the guest sees
+		if opts.Format == DiskFormatQcow2 || diskFormat(d) == DiskFormatQcow2 {
+			glog.Infof("Using existing %s disk %s", DiskFormatQcow2, diskPath)
+			return nil
+		}
+		checkRecordedSize(d, fi.Size())
+		if err := reconcileDiskSize(diskPath, fi.Size(), diskSize, opts.SectorSize, opts.GrowExisting); err != nil {
+			return err
 		}
-		machPath := d.ResolveStorePath(".")
-		if err := fixPermissions(machPath); err != nil {
-			return errors.Wrapf(err, "fixing permissions on %s", machPath)
+		return recordDiskSize(d)
+	}
+	if os.IsNotExist(statErr) {
+		if err := checkFreeSpace(d.ResolveStorePath("."), diskSize); err != nil {
+			return err
+		}
+		if w, err := CheckStoreFilesystem(d.StorePath); err != nil {
+			glog.Warningf("unable to check the filesystem of %s: %v", d.StorePath, err)
+		} else if w.Message != "" {
+			out.WarningT("{{.warning}}. Consider setting {{.env}} to a directory on a local disk.", out.V{"warning": w.Message, "env": constants.MinikubeHome})
+		}
+		created.track(diskPath)
+		if err := withContext(ctx, opts.Metrics.timed(PhaseDiskCreate, create)); err != nil {
+			return errors.Wrapf(err, "create disk image %s", diskPath)
+		}
+		created.track(diskMetadataPath(d))
+		format := opts.Format
+		if format == "" {
+			format = DiskFormatRaw
 		}
+		if err := writeDiskMetadata(d, DiskMetadata{
+			SectorSize: opts.sectorSize(),
+			// boot2docker formats the whole disk on first boot
+			GuestGrownSize: diskSizeBytes(diskSize, opts.SectorSize),
+			Size:           diskSizeBytes(diskSize, opts.SectorSize),
+			Format:         format,
+			ISOVersion:     isoVersion(boot2dockerURL),
+			CreatedAt:      time.Now(),
+			SwapSize:       diskSizeBytes(opts.SwapSizeMB, 0),
+			Encrypted:      opts.Passphrase != nil,
+		}); err != nil {
+			return errors.Wrap(err, "write disk metadata")
+		}
+		if opts.ValidateLayout != nil && opts.Format != DiskFormatQcow2 {
+			if err := validateDiskLayout(diskPath, opts.ValidateLayout); err != nil {
+				glog.Warningf("disk layout validation failed for %s: %v", diskPath, err)
+				out.WarningT("Unexpected disk layout: {{.error}}", out.V{"error": err})
+			}
+		}
+		if !opts.SkipPermissionFix {
+			machPath := d.ResolveStorePath(".")
+			uid, gid := opts.ownership()
+			if err := fixPermissions(machPath, uid, gid, opts.Permissions); err != nil {
+				return errors.Wrapf(err, "fixing permissions on %s", machPath)
+			}
+		}
+	}
+	return nil
+}
+
+// reconcileDiskSize compares an existing disk against the requested size, growing it if allowed
+func reconcileDiskSize(diskPath string, currentSize int64, diskSizeMb, sectorSize int, grow bool) error {
+	wantSize := diskSizeBytes(diskSizeMb, sectorSize)
+	if currentSize == wantSize {
+		return nil
+	}
+	if currentSize > wantSize || !grow {
+		glog.Warningf("Existing disk %s is %dMB, ignoring requested size of %dMB", diskPath, currentSize/bytesPerMB, diskSizeMb)
+		out.WarningT("The existing disk is {{.current}}MB, not the requested {{.requested}}MB. Delete the machine to recreate it at the new size.", out.V{"current": currentSize / bytesPerMB, "requested": diskSizeMb})
+		return nil
+	}
+	glog.Infof("Growing disk %s from %d to %d bytes", diskPath, currentSize, wantSize)
+	if err := os.Truncate(diskPath, wantSize); err != nil {
+		return errors.Wrap(err, "grow disk")
 	}
 	return nil
 }
 
-func fixPermissions(path string) error {
-	glog.Infof("Fixing permissions on %s ...", path)
-	if err := os.Chown(path, syscall.Getuid(), syscall.Getegid()); err != nil {
-		return errors.Wrap(err, "chown dir")
+// withContext runs fn, which should stop early once ctx is done, returning ctx.Err() if ctx was
+// done by the time fn returned. fn has always returned by then, so that nothing it is still
+// writing races the removal of what it created.
+func withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := fn()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// ctxReader is a reader that fails with ctx.Err() once ctx is done, to bound a copy out of it
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// artifacts are the paths created by an in-progress operation, removed if it is abandoned
+type artifacts []string
+
+// track records path as an artifact if it does not exist yet
+func (a *artifacts) track(path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		*a = append(*a, path)
+	}
+}
+
+// remove deletes all tracked artifacts
+func (a artifacts) remove() {
+	for _, p := range a {
+		glog.Infof("Removing partial artifact: %s", p)
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("unable to remove %s: %v", p, err)
+		}
+	}
+}
+
+// chownUnsupported returns whether err means the filesystem doesn't support changing ownership at
+// all, as on WSL and some network mounts, rather than that permission was denied
+func chownUnsupported(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	// ENOTSUP and EOPNOTSUPP are the same on some platforms, so they can't be switch cases
+	for _, unsupported := range []error{syscall.ENOSYS, syscall.EINVAL, syscall.ENOTSUP, syscall.EOPNOTSUPP} {
+		if err == unsupported {
+			return true
+		}
+	}
+	return false
+}
+
+// fixPermissions chowns path and the files in it to uid and gid, and sets their modes by strategy
+func fixPermissions(path string, uid, gid int, strategy PermissionsStrategy) error {
+	glog.Infof("Fixing permissions on %s (uid=%d, gid=%d) ...", path, uid, gid)
+	// Windows has no mode bits to speak of, only a read-only flag
+	chmod := strategy != PermissionsDefault && runtime.GOOS != "windows"
+	if chmod {
+		dirMode, _ := strategy.modes(0)
+		if err := os.Chmod(path, dirMode); err != nil {
+			return errors.Wrap(err, "chmod dir")
+		}
+	}
+	owned := true
+	if err := chown(path, uid, gid); err != nil {
+		if !chownUnsupported(err) {
+			return errors.Wrap(err, "chown dir")
+		}
+		// the files would fail the same way, and their ownership may well be right already
+		glog.Warningf("chown is not supported on %s, leaving ownership as is: %v", path, err)
+		out.WarningT("Unable to change the owner of {{.path}}, leaving it as is: {{.error}}", out.V{"path": path, "error": err})
+		if !chmod {
+			return nil
+		}
+		owned = false
 	}
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
@@ -144,79 +799,267 @@ func fixPermissions(path string) error {
 	}
 	for _, f := range files {
 		fp := filepath.Join(path, f.Name())
-		if err := os.Chown(fp, syscall.Getuid(), syscall.Getegid()); err != nil {
-			return errors.Wrap(err, "chown file")
+		if owned {
+			if err := chown(fp, uid, gid); err != nil {
+				return errors.Wrap(err, "chown file")
+			}
+		}
+		if !chmod || !f.Mode().IsRegular() {
+			continue
+		}
+		if _, mode := strategy.modes(f.Mode()); mode != f.Mode().Perm() {
+			if err := os.Chmod(fp, mode); err != nil {
+				return errors.Wrap(err, "chmod file")
+			}
 		}
 	}
 	return nil
 }
 
+// InstallAction describes what InstallOrUpdate did with a driver
+type InstallAction string
+
+const (
+	// DriverDownloaded means the driver was missing and has been downloaded
+	DriverDownloaded InstallAction = "Downloaded"
+	// DriverUpdated means an outdated or unversioned driver has been replaced
+	DriverUpdated InstallAction = "Updated"
+	// DriverSkipped means the installed driver was already up to date
+	DriverSkipped InstallAction = "Skipped"
+	// DriverUnmanaged means the driver needed installing or updating, but isn't one minikube
+	// downloads, so whatever is installed, if anything, was kept
+	DriverUnmanaged InstallAction = "Unmanaged"
+)
+
+// InstallOrUpdateResult describes the outcome of InstallOrUpdateWithResult
+type InstallOrUpdateResult struct {
+	// Action is what was done with the driver
+	Action InstallAction
+	// FromVersion is the version of the driver found before the install, empty if unknown
+	FromVersion string
+	// ToVersion is the version the driver is expected to be at afterwards
+	ToVersion string
+}
+
+// VersionedDriverDir returns where drivers for minikubeVersion are kept within destination,
+// when installed with InstallOptions.VersionedCache
+func VersionedDriverDir(destination string, minikubeVersion semver.Version) string {
+	return filepath.Join(destination, minikubeVersion.String())
+}
+
 // InstallOrUpdate downloads driver if it is not present, or updates it if there's a newer version
 func InstallOrUpdate(driver, destination string, minikubeVersion semver.Version) error {
-	_, err := exec.LookPath(driver)
+	_, err := InstallOrUpdateWithResult(driver, destination, minikubeVersion, InstallOptions{})
+	return err
+}
+
+// InstallOrUpdateWithResult is InstallOrUpdate with options, additionally reporting what was done
+func InstallOrUpdateWithResult(driver, destination string, minikubeVersion semver.Version, opts InstallOptions) (InstallOrUpdateResult, error) {
+	result := InstallOrUpdateResult{Action: DriverUpdated, ToVersion: minikubeVersion.String()}
+	if err := validateDriverName(driver); err != nil {
+		return result, err
+	}
+	if err := validateBareName(opts.filename(driver)); err != nil {
+		return result, errors.Wrap(err, "driver filename")
+	}
+	opts.targetVersion = version.VersionPrefix + minikubeVersion.String()
+
+	bin := driver
+	if opts.VersionedCache {
+		destination = VersionedDriverDir(destination, minikubeVersion)
+		bin = filepath.Join(destination, opts.filename(driver))
+	}
+
+	// installUnlessUnmanaged installs with o, reporting a driver minikube doesn't download as kept
+	installUnlessUnmanaged := func(o InstallOptions) error {
+		downloaded, err := installAndProbe(driver, destination, o)
+		if err == nil && !downloaded {
+			result.Action = DriverUnmanaged
+			result.ToVersion = result.FromVersion
+		}
+		return err
+	}
+	install := func() error {
+		if opts.VersionRange == nil {
+			return installUnlessUnmanaged(opts)
+		}
+		if _, managed, _ := opts.resolveURL(driver); !managed {
+			if !opts.VersionRange(minikubeVersion) {
+				return errors.Errorf("%s %s is outside the allowed driver version range, and no other version can be downloaded", driver, minikubeVersion)
+			}
+			return installUnlessUnmanaged(opts)
+		}
+		// download the newest release inside the range, then check that is what arrived
+		v, err := opts.rangeVersion(driver, minikubeVersion)
+		if err != nil {
+			return err
+		}
+		ropts := opts
+		ropts.Version = version.VersionPrefix + v.String()
+		result.ToVersion = v.String()
+		if err := installUnlessUnmanaged(ropts); err != nil {
+			return err
+		}
+		return ropts.checkInstalledInRange(driver, destination)
+	}
+
+	bin, err := opts.lookPath()(bin)
 	// if file driver doesn't exist, download it
 	if err != nil {
-		return download(driver, destination)
+		result.Action = DriverDownloaded
+		return result, install()
+	}
+
+	// a driver built for another platform can't run, so replace it
+	if err := checkDriverPlatform(bin); err != nil {
+		out.WarningT("Replacing {{.driver}}: {{.error}}", out.V{"driver": driver, "error": err})
+		return result, install()
 	}
 
-	cmd := exec.Command(driver, "version")
-	output, err := cmd.Output()
+	output, err := opts.driverVersionOutput(bin)
 	// if driver doesnt support 'version', it is old, download it
 	if err != nil {
-		return download(driver, destination)
+		return result, install()
 	}
 
 	v := ExtractVMDriverVersion(string(output))
 
 	// if the driver doesn't return any version, download it
 	if len(v) == 0 {
-		return download(driver, destination)
+		return result, install()
 	}
+	result.FromVersion = v
 
-	vmDriverVersion, err := semver.Make(v)
-	if err != nil {
-		return errors.Wrap(err, "can't parse driver version")
+	if opts.VersionRange != nil {
+		driverVersion, err := semver.Make(v)
+		if err != nil {
+			return result, errors.Wrap(err, "can't parse driver version")
+		}
+		// outside the known-good window, in either direction, download a replacement
+		if !opts.VersionRange(driverVersion) {
+			return result, install()
+		}
+	} else {
+		cmp, err := CompareDriverVersions(v, minikubeVersion.String())
+		if err != nil {
+			return result, errors.Wrap(err, "can't parse driver version")
+		}
+
+		// if the current driver version is older, download newer
+		if cmp < 0 {
+			return result, install()
+		}
 	}
 
-	// if the current driver version is older, download newer
-	if vmDriverVersion.LT(minikubeVersion) {
-		return download(driver, destination)
+	if driverVersion, err := semver.Make(v); err == nil {
+		if ok, reason := CheckDriverCompatibility(driver, driverVersion, minikubeVersion); !ok {
+			out.WarningT("{{.driver}} {{.version}} is known not to work with this version of minikube: {{.reason}}", out.V{"driver": driver, "version": v, "reason": reason})
+		}
 	}
 
-	return nil
+	if opts.VerifyInstalled {
+		if err := verifyInstalledDriver(bin); err != nil {
+			out.WarningT("Replacing {{.driver}}: {{.error}}", out.V{"driver": driver, "error": err})
+			opts.Force = true
+			return result, install()
+		}
+	}
+
+	result.Action = DriverSkipped
+	result.ToVersion = v
+	return result, nil
 }
 
-func download(driver, destination string) error {
-	// only support kvm2 for now
-	if driver != "docker-machine-driver-kvm2" {
+// verifyInstalledDriver returns an error if the driver at bin no longer matches the checksum
+// recorded when it was downloaded. Drivers without one, e.g. installed by hand, pass.
+func verifyInstalledDriver(bin string) error {
+	// the checksum is recorded beside the file an atomically swapped link points at
+	real, err := filepath.EvalSymlinks(bin)
+	if err != nil {
+		return errors.Wrapf(err, "resolve %s", bin)
+	}
+	if _, err := os.Stat(real + checksumSuffix); os.IsNotExist(err) {
+		glog.Infof("%s has no recorded checksum, not verifying it", real)
 		return nil
 	}
+	return verifyChecksum(real)
+}
 
-	out.T(out.Happy, "Downloading driver {{.driver}}:", out.V{"driver": driver})
-
-	targetFilepath := path.Join(destination, "docker-machine-driver-kvm2")
-	os.Remove(targetFilepath)
+// runDriverVersion runs the driver's 'version' command, replaceable for testing
+var runDriverVersion = func(bin string) ([]byte, error) {
+	return exec.Command(bin, "version").Output()
+}
 
-	url := driverKVMDownloadURL
+// versionRetryDelay is how long to wait before running 'version' again after a transient failure
+var versionRetryDelay = 500 * time.Millisecond
 
-	opts := []getter.ClientOption{getter.WithProgress(util.DefaultProgressBar)}
-	client := &getter.Client{
-		Src:     url,
-		Dst:     targetFilepath,
-		Mode:    getter.ClientModeFile,
-		Options: opts,
+// driverVersionOutput returns the output of the driver's 'version' command, running it again
+// if the driver failed to start for a transient reason. A driver that ran and exited nonzero
+// doesn't support 'version', and is not retried.
+func (o InstallOptions) driverVersionOutput(bin string) ([]byte, error) {
+	policy := RetryPolicy{
+		MaxAttempts: o.versionRetries() + 1,
+		BaseDelay:   versionRetryDelay,
+		MaxDelay:    versionRetryDelay,
+		OnRetry: func(err error, _ time.Duration) {
+			o.logger().Warningf("unable to run %s version, retrying: %v", bin, err)
+		},
 	}
+	var output []byte
+	err := policy.Do(context.Background(), func() error {
+		var err error
+		output, err = runDriverVersion(bin)
+		return err
+	}, transientExecError)
+	return output, err
+}
 
-	if err := client.Get(); err != nil {
-		return errors.Wrapf(err, "can't download driver %s from: %s", driver, url)
+// transientExecError returns whether err is from a command that failed to start for a reason
+// that may pass, such as the host being out of processes or the binary still being written
+func transientExecError(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *exec.Error:
+			err = e.Err
+		case *os.PathError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case syscall.Errno:
+			return transientErrno(e)
+		default:
+			return false
+		}
 	}
+}
 
-	err := os.Chmod(targetFilepath, 0777)
-	if err != nil {
-		return errors.Wrap(err, "chmod error")
+// installAndProbe downloads driver, then checks that the result actually runs if asked to. It
+// returns whether the driver was downloaded, which it isn't if minikube doesn't manage it.
+func installAndProbe(driver, destination string, opts InstallOptions) (bool, error) {
+	downloaded := true
+	// drivers minikube doesn't download are installed by the user, so whatever is there is kept
+	if err := download(driver, destination, opts); errors.Cause(err) == ErrUnmanagedDriver {
+		glog.Infof("%s is not downloaded by minikube, keeping the installed driver, if any", driver)
+		downloaded = false
+	} else if err != nil {
+		return false, err
 	}
+	bin := filepath.Join(destination, opts.filename(driver))
+	if _, err := os.Stat(bin); err == nil {
+		if err := checkDriverPlatform(bin); err != nil {
+			return downloaded, errors.Wrap(err, "downloaded driver")
+		}
+	}
+	if !opts.Probe {
+		return downloaded, nil
+	}
+	return downloaded, probeDriver(driver, bin, opts)
+}
 
-	return nil
+func download(driver, destination string, opts InstallOptions) error {
+	dl := NewDownloader(opts)
+	defer dl.Close()
+	return dl.Download(driver, destination)
 }
 
 // ExtractVMDriverVersion extracts the driver version.
@@ -224,14 +1067,46 @@ func download(driver, destination string) error {
 // version: vX.X.X
 // commit: XXXX
 // This method returns the version 'vX.X.X' or empty if the version isn't found.
+// Only a line starting with 'version:' counts, so banners printed before it are ignored.
 func ExtractVMDriverVersion(s string) string {
-	versionRegex := regexp.MustCompile(`version:(.*)`)
-	matches := versionRegex.FindStringSubmatch(s)
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "version:") {
+			continue
+		}
+		v := strings.TrimSpace(strings.TrimPrefix(line, "version:"))
+		return strings.TrimPrefix(v, version.VersionPrefix)
+	}
+	return ""
+}
 
-	if len(matches) != 2 {
-		return ""
+// CompareDriverVersions compares two driver versions, returning -1, 0 or 1 as a is older than,
+// the same as, or newer than b. Each may be a bare version such as "v1.2.3" or the output of
+// the driver's 'version' command. Pre-releases sort before their release, as per semver.
+func CompareDriverVersions(a, b string) (int, error) {
+	va, err := parseDriverVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseDriverVersion(b)
+	if err != nil {
+		return 0, err
 	}
+	return va.Compare(vb), nil
+}
 
-	v := strings.TrimSpace(matches[1])
-	return strings.TrimPrefix(v, version.VersionPrefix)
+// parseDriverVersion parses s the same way as ExtractVMDriverVersion
+func parseDriverVersion(s string) (semver.Version, error) {
+	v := ExtractVMDriverVersion(s)
+	if v == "" {
+		v = strings.TrimPrefix(strings.TrimSpace(s), version.VersionPrefix)
+	}
+	if v == "" {
+		return semver.Version{}, errors.Errorf("no driver version in %q", s)
+	}
+	parsed, err := semver.Make(v)
+	if err != nil {
+		return semver.Version{}, errors.Wrapf(err, "parse driver version %q", v)
+	}
+	return parsed, nil
 }
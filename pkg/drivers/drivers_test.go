@@ -17,11 +17,26 @@ limitations under the License.
 package drivers
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/blang/semver"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/pkg/errors"
+	"k8s.io/minikube/pkg/minikube/constants"
 	"k8s.io/minikube/pkg/minikube/tests"
 )
 
@@ -36,8 +51,8 @@ func Test_createDiskImage(t *testing.T) {
 	diskPath := filepath.Join(tmpdir, "disk")
 
 	sizeInMb := 100
-	sizeInBytes := int64(sizeInMb) * 1000000
-	if err := createRawDiskImage(sshPath, diskPath, sizeInMb); err != nil {
+	sizeInBytes := int64(sizeInMb) * bytesPerMB
+	if err := createRawDiskImage(sshPath, diskPath, sizeInMb, 0, nil); err != nil {
 		t.Errorf("createDiskImage() error = %v", err)
 	}
 	fi, err := os.Lstat(diskPath)
@@ -49,6 +64,922 @@ func Test_createDiskImage(t *testing.T) {
 	}
 }
 
+func TestCreateFilledDiskImage(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	sshPath := filepath.Join(tmpdir, "ssh")
+	if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	pattern := []byte("MINIKUBE")
+
+	var tcs = []struct {
+		desc string
+		fill []byte
+	}{
+		{desc: "sparse"},
+		{desc: "filled", fill: pattern},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			diskPath := filepath.Join(tmpdir, tc.desc)
+			if err := createFilledDiskImage(context.Background(), sshPath, diskPath, 3, 0, nil, 0, "", tc.fill); err != nil {
+				t.Fatalf("createFilledDiskImage() error = %v", err)
+			}
+			b, err := ioutil.ReadFile(diskPath)
+			if err != nil {
+				t.Fatalf("readfile: %v", err)
+			}
+			if len(b) != 3*bytesPerMB {
+				t.Fatalf("disk size = %d, want %d", len(b), 3*bytesPerMB)
+			}
+			start := bytes.Index(b, pattern)
+			if tc.fill == nil {
+				if start >= 0 {
+					t.Errorf("found the pattern at %d in a disk without a fill", start)
+				}
+				return
+			}
+			if start < 0 || start > 64*1024 {
+				t.Fatalf("fill starts at %d, want it just after the tar", start)
+			}
+			for i := start; i < len(b); i++ {
+				if b[i] != pattern[(i-start)%len(pattern)] {
+					t.Fatalf("byte %d = %q, want the pattern repeated to the end of the disk", i, b[i])
+				}
+			}
+		})
+	}
+
+	// a canceled fill stops, and leaves no disk behind for the caller to race
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	diskPath := filepath.Join(tmpdir, "canceled")
+	if err := createFilledDiskImage(ctx, sshPath, diskPath, 3, 0, nil, 0, "", pattern); errors.Cause(err) != context.Canceled {
+		t.Errorf("createFilledDiskImage() with a canceled context error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(diskPath); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, stat error = %v", diskPath, err)
+	}
+}
+
+func TestMakeDiskImageFillPatternQcow2(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	opts := DiskImageOptions{Format: DiskFormatQcow2, DebugFillPattern: []byte("MINIKUBE")}
+	if err := MakeDiskImageContext(context.Background(), d, "file:///nonexistent.iso", defaultMinDiskSizeMB, opts); err == nil {
+		t.Error("MakeDiskImageContext() of a filled qcow2 disk succeeded")
+	}
+}
+
+func TestMakeDiskImageSwap(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	for _, swap := range []int{-1, defaultMinDiskSizeMB} {
+		if err := MakeDiskImageWithOptions(d, "file://"+iso, defaultMinDiskSizeMB, DiskImageOptions{SwapSizeMB: swap}); err == nil {
+			t.Errorf("MakeDiskImageWithOptions() with %dMB of swap succeeded", swap)
+		}
+	}
+
+	if err := MakeDiskImageWithOptions(d, "file://"+iso, defaultMinDiskSizeMB, DiskImageOptions{SwapSizeMB: 100}); err != nil {
+		t.Fatalf("MakeDiskImageWithOptions() error = %v", err)
+	}
+	fi, err := os.Stat(GetDiskPath(d))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if want := int64(defaultMinDiskSizeMB+100) * bytesPerMB; fi.Size() != want {
+		t.Errorf("disk size = %d, want %d including the swap", fi.Size(), want)
+	}
+	m, err := ReadDiskMetadata(d)
+	if err != nil {
+		t.Fatalf("ReadDiskMetadata() error = %v", err)
+	}
+	if m.SwapSize != 100*bytesPerMB {
+		t.Errorf("recorded swap size = %d, want %d", m.SwapSize, 100*bytesPerMB)
+	}
+
+	f, err := os.Open(GetDiskPath(d))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	var marker string
+	for _, e := range readTarEntries(t, f) {
+		if e.Name == swapMarkerName {
+			marker = e.Content
+		}
+	}
+	if marker != "100M\n" {
+		t.Errorf("swap marker = %q, want %q", marker, "100M\n")
+	}
+}
+
+func TestParseDiskSize(t *testing.T) {
+	var tcs = []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "20000", want: 20000},
+		{in: "20000mb", want: 20000},
+		{in: "20000MB", want: 20000},
+		{in: "20000m", want: 20000},
+		{in: "20000M", want: 20000},
+		{in: "20000MiB", want: 20000},
+		{in: "20000 mb", want: 20000},
+		{in: " 20g ", want: 20480},
+		{in: "20G", want: 20480},
+		{in: "20gb", want: 20480},
+		{in: "20GiB", want: 20480},
+		{in: "2097152k", want: 2048},
+		{in: "2097152KB", want: 2048},
+		{in: "2097152kib", want: 2048},
+		{in: "1048576b", want: 1},
+		{in: "1048576B", want: 1},
+		{in: "", wantErr: true},
+		{in: "g", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "0g", wantErr: true},
+		{in: "-20g", wantErr: true},
+		{in: "1.5g", wantErr: true},
+		{in: "20t", wantErr: true},
+		{in: "20gigs", wantErr: true},
+		{in: "20ib", wantErr: true},
+		{in: "20mi", wantErr: true},
+		{in: "20g20", wantErr: true},
+		{in: "512k", wantErr: true},
+		{in: "1000b", wantErr: true},
+		{in: "99999999999999999999", wantErr: true},
+		{in: "9999999999g", wantErr: true},
+	}
+	for _, tc := range tcs {
+		got, err := ParseDiskSize(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseDiskSize(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseDiskSize(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDiskFilenamePattern(t *testing.T) {
+	defer func() {
+		if err := SetDiskFilenamePattern(DefaultDiskFilenamePattern); err != nil {
+			t.Fatalf("restoring the default pattern: %v", err)
+		}
+	}()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "m01", StorePath: tmpdir}
+	machine := d.ResolveStorePath(".")
+
+	var tcs = []struct {
+		pattern   string
+		wantRaw   string
+		wantQcow2 string
+	}{
+		{pattern: DefaultDiskFilenamePattern, wantRaw: "m01.rawdisk", wantQcow2: "m01.qcow2"},
+		{pattern: "disk.img", wantRaw: "disk.img", wantQcow2: "disk.qcow2"},
+		{pattern: "{{.MachineName}}-root", wantRaw: "m01-root", wantQcow2: "m01-root.qcow2"},
+	}
+	for _, tc := range tcs {
+		if err := SetDiskFilenamePattern(tc.pattern); err != nil {
+			t.Fatalf("SetDiskFilenamePattern(%q) error = %v", tc.pattern, err)
+		}
+		if got, want := GetDiskPath(d), filepath.Join(machine, tc.wantRaw); got != want {
+			t.Errorf("GetDiskPath() with %q = %s, want %s", tc.pattern, got, want)
+		}
+		if got, want := qcow2DiskPath(d), filepath.Join(machine, tc.wantQcow2); got != want {
+			t.Errorf("qcow2DiskPath() with %q = %s, want %s", tc.pattern, got, want)
+		}
+	}
+
+	for _, bad := range []string{"", "{{", "{{.Profile}}", "../disk.img", "{{.MachineName}}/disk", "boot2docker.iso", "disk.qcow2", "id_rsa"} {
+		if err := SetDiskFilenamePattern(bad); err == nil {
+			t.Errorf("SetDiskFilenamePattern(%q) succeeded", bad)
+		}
+	}
+	// a rejected pattern leaves the last good one in place
+	if got := filepath.Base(GetDiskPath(d)); got != "m01-root" {
+		t.Errorf("disk filename after a rejected pattern = %s, want m01-root", got)
+	}
+}
+
+func TestDiskExists(t *testing.T) {
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+	if exists, err := DiskExists(d); err != nil || !exists {
+		t.Errorf("DiskExists() = %v, %v, want true", exists, err)
+	}
+
+	missing := &drivers.BaseDriver{MachineName: "missing", StorePath: d.StorePath}
+	if exists, err := DiskExists(missing); err != nil || exists {
+		t.Errorf("DiskExists() of a missing disk = %v, %v, want false", exists, err)
+	}
+
+	// a store path that is a file can't be looked in, which is not the same as an empty one
+	notDir := filepath.Join(d.StorePath, "file")
+	if err := ioutil.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	if exists, err := DiskExists(&drivers.BaseDriver{MachineName: "minikube", StorePath: notDir}); err == nil {
+		t.Errorf("DiskExists() in a file = %v, want an error", exists)
+	}
+}
+
+func TestDiskExistsPermissionDenied(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("needs a directory the user can't read")
+	}
+	d, cleanup := newTestDisk(t, 5)
+	defer cleanup()
+	machines := filepath.Join(d.StorePath, "machines")
+	if err := os.Chmod(machines, 0); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	defer os.Chmod(machines, 0755)
+
+	exists, err := DiskExists(d)
+	if err == nil || !os.IsPermission(errors.Cause(err)) {
+		t.Errorf("DiskExists() = %v, %v, want a permission error", exists, err)
+	}
+}
+
+func TestFixPermissionsOwnership(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "disk"), []byte("disk"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	type target struct {
+		path     string
+		uid, gid int
+	}
+	var got []target
+	chown = func(path string, uid, gid int) error {
+		got = append(got, target{path, uid, gid})
+		return nil
+	}
+	defer func() { chown = os.Chown }()
+
+	opts := DiskImageOptions{
+		Owner: &Ownership{UID: 1000, GID: 1000},
+		IDMap: func(uid, gid int) (int, int) { return uid + 100000, gid + 100000 },
+	}
+	uid, gid := opts.ownership()
+	if err := fixPermissions(tmpdir, uid, gid, PermissionsDefault); err != nil {
+		t.Fatalf("fixPermissions() error = %v", err)
+	}
+	if len(got) == 0 {
+		t.Fatal("chown was never called")
+	}
+	for _, c := range got {
+		if c.uid != 101000 || c.gid != 101000 {
+			t.Errorf("chown(%s) = %d:%d, want 101000:101000", c.path, c.uid, c.gid)
+		}
+	}
+	if got[0].path != tmpdir {
+		t.Errorf("first chown target = %s, want %s", got[0].path, tmpdir)
+	}
+}
+
+func TestFixPermissionsChownUnsupported(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	defer func() { chown = os.Chown }()
+
+	var tcs = []struct {
+		desc    string
+		err     error
+		wantErr bool
+	}{
+		{desc: "unsupported", err: syscall.ENOSYS},
+		{desc: "denied", err: syscall.EPERM, wantErr: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			chown = func(path string, uid, gid int) error {
+				return &os.PathError{Op: "chown", Path: path, Err: tc.err}
+			}
+			if err := fixPermissions(tmpdir, 1000, 1000, PermissionsDefault); (err != nil) != tc.wantErr {
+				t.Errorf("fixPermissions() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestFixPermissionsStrategy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows has no mode bits")
+	}
+	chown = func(path string, uid, gid int) error { return nil }
+	defer func() { chown = os.Chown }()
+
+	var tcs = []struct {
+		strategy PermissionsStrategy
+		want     map[string]os.FileMode
+	}{
+		{strategy: PermissionsDefault, want: map[string]os.FileMode{".": 0755, "disk": 0644, "id_rsa": 0600}},
+		{strategy: PermissionsStrict, want: map[string]os.FileMode{".": 0700, "disk": 0600, "id_rsa": 0600}},
+		{strategy: PermissionsGroupReadable, want: map[string]os.FileMode{".": 0750, "disk": 0640, "id_rsa": 0600}},
+	}
+	for _, tc := range tcs {
+		t.Run(string(tc.strategy), func(t *testing.T) {
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+			for name, mode := range map[string]os.FileMode{".": 0755, "disk": 0644, "id_rsa": 0600} {
+				p := filepath.Join(tmpdir, name)
+				if name != "." {
+					if err := ioutil.WriteFile(p, []byte(name), mode); err != nil {
+						t.Fatalf("writefile: %v", err)
+					}
+				}
+				// regardless of umask
+				if err := os.Chmod(p, mode); err != nil {
+					t.Fatalf("chmod: %v", err)
+				}
+			}
+
+			if err := fixPermissions(tmpdir, 1000, 1000, tc.strategy); err != nil {
+				t.Fatalf("fixPermissions() error = %v", err)
+			}
+			for name, want := range tc.want {
+				fi, err := os.Stat(filepath.Join(tmpdir, name))
+				if err != nil {
+					t.Fatalf("stat: %v", err)
+				}
+				if got := fi.Mode().Perm(); got != want {
+					t.Errorf("mode of %s = %o, want %o", name, got, want)
+				}
+			}
+		})
+	}
+
+	if err := validatePermissionsStrategy("loose"); err == nil {
+		t.Error("validatePermissionsStrategy() of an unknown strategy succeeded")
+	}
+}
+
+func TestMakeDiskImageContextCancel(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	orig := copyIsoToMachineDir
+	defer func() { copyIsoToMachineDir = orig }()
+	copyIsoToMachineDir = func(storePath, isoURL, machineName string) error {
+		// cancel once the ISO has landed, before the remaining steps run
+		defer cancel()
+		return ioutil.WriteFile(d.ResolveStorePath(isoFilename), []byte("iso"), 0644)
+	}
+
+	err := MakeDiskImageContext(ctx, d, "", defaultMinDiskSizeMB, DiskImageOptions{})
+	if err != context.Canceled {
+		t.Fatalf("MakeDiskImageContext() error = %v, want %v", err, context.Canceled)
+	}
+	for _, p := range []string{d.ResolveStorePath(isoFilename), d.GetSSHKeyPath(), GetDiskPath(d)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be cleaned up, stat error = %v", p, err)
+		}
+	}
+}
+
+func TestReconcileDiskSize(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	var tcs = []struct {
+		desc     string
+		grow     bool
+		wantSize int64
+	}{
+		{"warn only", false, 100 * bytesPerMB},
+		{"grow", true, 200 * bytesPerMB},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			diskPath := filepath.Join(tmpdir, "disk")
+			defer os.Remove(diskPath)
+			sshPath := filepath.Join(tmpdir, "ssh")
+			if err := ioutil.WriteFile(sshPath, []byte("mysshkey"), 0644); err != nil {
+				t.Fatalf("writefile: %v", err)
+			}
+			if err := createRawDiskImage(sshPath, diskPath, 100, 0, nil); err != nil {
+				t.Fatalf("createRawDiskImage() error = %v", err)
+			}
+			if err := reconcileDiskSize(diskPath, 100*bytesPerMB, 200, 0, tc.grow); err != nil {
+				t.Fatalf("reconcileDiskSize() error = %v", err)
+			}
+			fi, err := os.Stat(diskPath)
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if fi.Size() != tc.wantSize {
+				t.Errorf("Disk size is %v, want %v", fi.Size(), tc.wantSize)
+			}
+		})
+	}
+}
+
+// writeStubDriver writes an executable shell script named driver into dir
+func writeStubDriver(t *testing.T, dir, driver, script string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, driver), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+}
+
+// allowDriver lets driver be installed, returning a func that restores the allowed drivers
+func allowDriver(driver string) func() {
+	orig := allowedDrivers
+	allowedDrivers = append([]string{driver}, orig...)
+	return func() { allowedDrivers = orig }
+}
+
+func TestInstallOrUpdateWithResult(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	_, cleanup := serveDriver(t, "#!/bin/sh\necho version: v1.3.0\n")
+	defer cleanup()
+	// a driver minikube doesn't download is kept as it is
+	unmanaged := "docker-machine-driver-stub"
+	defer allowDriver(unmanaged)()
+	minikubeVersion := semver.MustParse("1.3.0")
+
+	var tcs = []struct {
+		desc   string
+		driver string
+		script string
+		want   InstallOrUpdateResult
+	}{
+		{
+			desc: "missing",
+			want: InstallOrUpdateResult{Action: DriverDownloaded, ToVersion: "1.3.0"},
+		},
+		{
+			desc:   "no version command",
+			script: "exit 1",
+			want:   InstallOrUpdateResult{Action: DriverUpdated, ToVersion: "1.3.0"},
+		},
+		{
+			desc:   "older",
+			script: "echo version: v1.2.0",
+			want:   InstallOrUpdateResult{Action: DriverUpdated, FromVersion: "1.2.0", ToVersion: "1.3.0"},
+		},
+		{
+			desc:   "up to date",
+			script: "echo version: v1.3.0",
+			want:   InstallOrUpdateResult{Action: DriverSkipped, FromVersion: "1.3.0", ToVersion: "1.3.0"},
+		},
+		{
+			desc:   "unmanaged missing",
+			driver: unmanaged,
+			want:   InstallOrUpdateResult{Action: DriverUnmanaged},
+		},
+		{
+			desc:   "unmanaged older",
+			driver: unmanaged,
+			script: "echo version: v1.2.0",
+			want:   InstallOrUpdateResult{Action: DriverUnmanaged, FromVersion: "1.2.0", ToVersion: "1.2.0"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+
+			driver := tc.driver
+			if driver == "" {
+				driver = kvmDriver
+			}
+			if tc.script != "" {
+				writeStubDriver(t, tmpdir, driver, tc.script)
+			}
+			defer os.Setenv("PATH", os.Getenv("PATH"))
+			os.Setenv("PATH", tmpdir)
+
+			got, err := InstallOrUpdateWithResult(driver, tmpdir, minikubeVersion, InstallOptions{Quiet: true})
+			if err != nil {
+				t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("InstallOrUpdateWithResult() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstallOrUpdateVersionRange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	driver := "docker-machine-driver-stub"
+	defer allowDriver(driver)()
+	versionRange := semver.MustParseRange(">=1.2.0 <2.0.0")
+
+	var tcs = []struct {
+		desc            string
+		installed       string
+		minikubeVersion string
+		want            InstallOrUpdateResult
+		wantErr         bool
+	}{
+		{
+			desc:      "older than minikube but in range",
+			installed: "1.2.0", minikubeVersion: "1.3.0",
+			want: InstallOrUpdateResult{Action: DriverSkipped, FromVersion: "1.2.0", ToVersion: "1.2.0"},
+		},
+		{
+			desc:      "below range",
+			installed: "1.1.0", minikubeVersion: "1.3.0",
+			want: InstallOrUpdateResult{Action: DriverUnmanaged, FromVersion: "1.1.0", ToVersion: "1.1.0"},
+		},
+		{
+			desc:      "above range",
+			installed: "2.0.0", minikubeVersion: "1.3.0",
+			want: InstallOrUpdateResult{Action: DriverUnmanaged, FromVersion: "2.0.0", ToVersion: "2.0.0"},
+		},
+		{
+			desc:      "nothing in range to download",
+			installed: "1.1.0", minikubeVersion: "2.1.0",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+			writeStubDriver(t, tmpdir, driver, "echo version: v"+tc.installed)
+			defer os.Setenv("PATH", os.Getenv("PATH"))
+			os.Setenv("PATH", tmpdir)
+
+			got, err := InstallOrUpdateWithResult(driver, tmpdir, semver.MustParse(tc.minikubeVersion), InstallOptions{VersionRange: versionRange})
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("InstallOrUpdateWithResult() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("InstallOrUpdateWithResult() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstallOrUpdateVersionRangeDownload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	// each release serves a driver reporting its version, except v1.8.0, which serves another
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/releases.json" {
+			fmt.Fprint(w, `[{"name": "v2.1.0"}, {"name": "v1.9.0-beta.0"}, {"name": "v1.8.0"}, {"name": "v1.7.0"}, {"name": "v1.1.0"}]`)
+			return
+		}
+		dir, file := path.Split(r.URL.Path)
+		if r.Method == http.MethodHead || file != kvmDriver {
+			if file != kvmDriver {
+				http.NotFound(w, r)
+			}
+			return
+		}
+		v := path.Base(dir)
+		if v == "v1.8.0" {
+			v = "v2.1.0"
+		}
+		fmt.Fprint(w, "#!/bin/sh\necho version: "+v+"\n")
+	}))
+	defer srv.Close()
+	defer func(u, r string) { driverKVMDownloadURL, driverReleasesURL = u, r }(driverKVMDownloadURL, driverReleasesURL)
+	driverKVMDownloadURL = srv.URL + "/releases/latest/" + kvmDriver
+	driverReleasesURL = srv.URL + "/releases.json"
+	defer allowSmallDrivers()()
+
+	var tcs = []struct {
+		desc    string
+		opts    InstallOptions
+		want    string
+		wantErr bool
+	}{
+		{desc: "newest stable release in range", opts: InstallOptions{VersionRange: semver.MustParseRange(">=1.2.0 <1.8.0")}, want: "1.7.0"},
+		{desc: "pre-releases off the stable channel", opts: InstallOptions{VersionRange: semver.MustParseRange(">=1.2.0 <2.0.0"), Channel: ChannelBeta}, want: "1.9.0-beta.0"},
+		{desc: "release outside the range served", opts: InstallOptions{VersionRange: semver.MustParseRange(">=1.8.0 <2.0.0")}, wantErr: true},
+		{desc: "pinned version outside the range", opts: InstallOptions{VersionRange: semver.MustParseRange(">=1.2.0 <2.0.0"), Version: "v2.1.0"}, wantErr: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+			writeStubDriver(t, tmpdir, kvmDriver, "echo version: v1.1.0")
+			tc.opts.Quiet = true
+			tc.opts.LookPath = func(file string) (string, error) { return filepath.Join(tmpdir, file), nil }
+
+			got, err := InstallOrUpdateWithResult(kvmDriver, tmpdir, semver.MustParse("1.3.0"), tc.opts)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("InstallOrUpdateWithResult() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+			}
+			want := InstallOrUpdateResult{Action: DriverUpdated, FromVersion: "1.1.0", ToVersion: tc.want}
+			if got != want {
+				t.Errorf("InstallOrUpdateWithResult() = %+v, want %+v", got, want)
+			}
+			out, err := exec.Command(filepath.Join(tmpdir, kvmDriver), "version").Output()
+			if err != nil {
+				t.Fatalf("run installed driver: %v", err)
+			}
+			if v := ExtractVMDriverVersion(string(out)); v != tc.want {
+				t.Errorf("installed driver version = %s, want %s", v, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstallOrUpdateVersionRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	driver := "docker-machine-driver-stub"
+	defer allowDriver(driver)()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	writeStubDriver(t, tmpdir, driver, "echo version: v1.3.0")
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", tmpdir)
+	defer func(d time.Duration) { versionRetryDelay = d }(versionRetryDelay)
+	versionRetryDelay = time.Millisecond
+	orig := runDriverVersion
+	defer func() { runDriverVersion = orig }()
+
+	var tcs = []struct {
+		desc     string
+		failures int
+		opts     InstallOptions
+		want     InstallAction
+	}{
+		{desc: "fails once", failures: 1, want: DriverSkipped},
+		{desc: "keeps failing", failures: 3, want: DriverUnmanaged},
+		{desc: "retries disabled", failures: 1, opts: InstallOptions{VersionRetries: -1}, want: DriverUnmanaged},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			runs := 0
+			runDriverVersion = func(bin string) ([]byte, error) {
+				runs++
+				if runs <= tc.failures {
+					return nil, &os.PathError{Op: "fork/exec", Path: bin, Err: syscall.EAGAIN}
+				}
+				return orig(bin)
+			}
+			got, err := InstallOrUpdateWithResult(driver, tmpdir, semver.MustParse("1.3.0"), tc.opts)
+			if err != nil {
+				t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+			}
+			if got.Action != tc.want {
+				t.Errorf("InstallOrUpdateWithResult() action = %v after %d transient failures, want %v", got.Action, tc.failures, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstallOrUpdateProbe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	driver := "docker-machine-driver-stub"
+	defer allowDriver(driver)()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	// an old driver, so that it is updated and then probed, whose hypervisor is unreachable
+	writeStubDriver(t, tmpdir, driver, `if [ "$1" = ping ]; then echo "hypervisor unreachable"; exit 1; fi; echo version: v1.2.0`)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", tmpdir)
+
+	minikubeVersion := semver.MustParse("1.3.0")
+	if _, err := InstallOrUpdateWithResult(driver, tmpdir, minikubeVersion, InstallOptions{Probe: true}); err != nil {
+		t.Errorf("InstallOrUpdateWithResult() with the default probe error = %v", err)
+	}
+	_, err := InstallOrUpdateWithResult(driver, tmpdir, minikubeVersion, InstallOptions{Probe: true, ProbeArgs: []string{"ping"}})
+	if err == nil || !strings.Contains(err.Error(), "hypervisor unreachable") {
+		t.Errorf("InstallOrUpdateWithResult() error = %v, want the failed probe's output", err)
+	}
+}
+
+func TestInstallOrUpdateVersionedCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	_, cleanup := serveDriver(t, "#!/bin/sh\necho version: v1.3.0\n")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	// the cache should be used rather than PATH
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(tmpdir, "empty"))
+
+	opts := InstallOptions{Quiet: true, VersionedCache: true}
+	for _, v := range []string{"1.3.0", "1.4.0"} {
+		got, err := InstallOrUpdateWithResult(kvmDriver, tmpdir, semver.MustParse(v), opts)
+		if err != nil {
+			t.Fatalf("InstallOrUpdateWithResult(%s) error = %v", v, err)
+		}
+		if got.Action != DriverDownloaded {
+			t.Errorf("InstallOrUpdateWithResult(%s) action = %v, want %v", v, got.Action, DriverDownloaded)
+		}
+		if _, err := os.Stat(filepath.Join(tmpdir, v, kvmDriver)); err != nil {
+			t.Errorf("expected a driver cached for %s: %v", v, err)
+		}
+	}
+
+	got, err := InstallOrUpdateWithResult(kvmDriver, tmpdir, semver.MustParse("1.3.0"), opts)
+	if err != nil {
+		t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+	}
+	if got.Action != DriverSkipped {
+		t.Errorf("InstallOrUpdateWithResult() of a cached driver action = %v, want %v", got.Action, DriverSkipped)
+	}
+}
+
+func TestInstallOrUpdateVerifyInstalled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	good := "#!/bin/sh\necho version: v1.3.0\n"
+	_, cleanup := serveDriver(t, good)
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", tmpdir)
+	bin := filepath.Join(tmpdir, kvmDriver)
+	minikubeVersion := semver.MustParse("1.3.0")
+	opts := InstallOptions{Quiet: true, VerifyInstalled: true}
+
+	if _, err := InstallOrUpdateWithResult(kvmDriver, tmpdir, minikubeVersion, opts); err != nil {
+		t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+	}
+	got, err := InstallOrUpdateWithResult(kvmDriver, tmpdir, minikubeVersion, opts)
+	if err != nil {
+		t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+	}
+	if got.Action != DriverSkipped {
+		t.Errorf("InstallOrUpdateWithResult() of an intact driver action = %v, want %v", got.Action, DriverSkipped)
+	}
+
+	// still runs and reports the right version, but is no longer what was downloaded
+	if err := ioutil.WriteFile(bin, []byte(good+"# corrupted\n"), 0755); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	if got, err = InstallOrUpdateWithResult(kvmDriver, tmpdir, minikubeVersion, opts); err != nil {
+		t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+	}
+	if got.Action != DriverUpdated {
+		t.Errorf("InstallOrUpdateWithResult() of a corrupted driver action = %v, want %v", got.Action, DriverUpdated)
+	}
+	if b, err := ioutil.ReadFile(bin); err != nil || string(b) != good {
+		t.Errorf("driver after reinstall = %q, %v, want %q", b, err, good)
+	}
+}
+
+func TestMakeDiskImageSkipPermissionFix(t *testing.T) {
+	var chowned int
+	chown = func(path string, uid, gid int) error {
+		chowned++
+		return nil
+	}
+	defer func() { chown = os.Chown }()
+
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+	for _, skip := range []bool{true, false} {
+		chowned = 0
+		d := &drivers.BaseDriver{MachineName: fmt.Sprintf("skip-%v", skip), StorePath: tmpdir}
+		if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		opts := DiskImageOptions{SkipPermissionFix: skip}
+		if err := MakeDiskImageWithOptions(d, "file://"+iso, defaultMinDiskSizeMB, opts); err != nil {
+			t.Fatalf("MakeDiskImageWithOptions() error = %v", err)
+		}
+		if skip && chowned != 0 {
+			t.Errorf("chown called %d times with SkipPermissionFix, want 0", chowned)
+		}
+		if !skip && chowned == 0 {
+			t.Error("chown was never called without SkipPermissionFix")
+		}
+	}
+}
+
+// fakeFlags is a drivers.DriverOptions of integer flags
+type fakeFlags map[string]int
+
+func (f fakeFlags) String(key string) string        { return "" }
+func (f fakeFlags) StringSlice(key string) []string { return nil }
+func (f fakeFlags) Int(key string) int              { return f[key] }
+func (f fakeFlags) Bool(key string) bool            { return false }
+
+func TestValidateFlags(t *testing.T) {
+	var tcs = []struct {
+		desc  string
+		flags fakeFlags
+		want  []string
+	}{
+		{desc: "unset", flags: fakeFlags{}},
+		{desc: "valid", flags: fakeFlags{FlagDiskSize: 20000, FlagMemory: 2048, FlagCPUs: 1, FlagSSHKeyBits: 4096}},
+		{desc: "small disk", flags: fakeFlags{FlagDiskSize: 100}},
+		{desc: "negative disk", flags: fakeFlags{FlagDiskSize: -1}, want: []string{"disk size"}},
+		{desc: "negative cpus", flags: fakeFlags{FlagCPUs: -2}, want: []string{"-2 CPUs"}},
+		{desc: "too many cpus", flags: fakeFlags{FlagCPUs: runtime.NumCPU() + 1}, want: []string{"host only has"}},
+		{
+			desc:  "several",
+			flags: fakeFlags{FlagDiskSize: -1, FlagMemory: 512, FlagSSHKeyBits: 1024},
+			want:  []string{"disk size", "memory", "key size"},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := (&CommonDriver{}).ValidateFlags(tc.flags)
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Errorf("ValidateFlags() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("ValidateFlags() expected an error mentioning %v", tc.want)
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("ValidateFlags() error = %v, want it to mention %q", err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestMinimumDiskSizeMB(t *testing.T) {
+	var tcs = []struct {
+		driver string
+		want   int
+	}{
+		{constants.DriverKvm2, 2000},
+		{constants.DriverHyperkit, 2000},
+		{"unknown", defaultMinDiskSizeMB},
+		{"", 0},
+	}
+	for _, tc := range tcs {
+		if got := MinimumDiskSizeMB(tc.driver); got != tc.want {
+			t.Errorf("MinimumDiskSizeMB(%q) = %d, want %d", tc.driver, got, tc.want)
+		}
+	}
+}
+
+func TestMakeDiskImageBelowMinimum(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	iso := filepath.Join(tmpdir, "boot2docker.iso")
+	writeISOFixture(t, iso)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	// the minimum is for the CLI to enforce, so that --force can bypass it
+	if err := MakeDiskImage(d, "file://"+iso, 100); err != nil {
+		t.Fatalf("MakeDiskImage() of a disk below the minimum error = %v", err)
+	}
+}
+
 func TestExtractVMDriverVersion(t *testing.T) {
 	v := ExtractVMDriverVersion("")
 	if len(v) != 0 {
@@ -71,4 +1002,140 @@ func TestExtractVMDriverVersion(t *testing.T) {
 	if expectedVersion != v {
 		t.Errorf("Expected version: %s, got: %s", expectedVersion, v)
 	}
+
+	// Banners printed before the version, including ones that mention a version
+	for _, output := range []string{
+		"docker-machine-driver-kvm2\nversion: v1.2.3\ncommit: 0123abcd\n",
+		"docker-machine-driver-kvm2 (libvirt version: 4.0.0)\nversion: v1.2.3\ncommit: 0123abcd\n",
+		"WARNING: this driver version: is deprecated\r\nversion: v1.2.3\r\n",
+	} {
+		if v := ExtractVMDriverVersion(output); v != expectedVersion {
+			t.Errorf("ExtractVMDriverVersion(%q) = %q, want %q", output, v, expectedVersion)
+		}
+	}
+}
+
+func TestCompareDriverVersions(t *testing.T) {
+	var tcs = []struct {
+		a, b    string
+		want    int
+		wantErr bool
+	}{
+		{a: "1.2.3", b: "1.2.3", want: 0},
+		{a: "v1.2.3", b: "1.2.3", want: 0},
+		{a: "version: v1.2.3\ncommit: abc", b: "v1.2.3", want: 0},
+		{a: "1.2.3", b: "1.3.0", want: -1},
+		{a: "2.0.0", b: "1.9.9", want: 1},
+		{a: "1.2.3-beta.0", b: "1.2.3", want: -1},
+		{a: "1.2.3", b: "1.2.3-beta.0", want: 1},
+		{a: "1.2.3-alpha.1", b: "1.2.3-beta.0", want: -1},
+		{a: "1.2.3-beta.2", b: "1.2.3-beta.10", want: -1},
+		{a: "1.2.3-beta.0", b: "1.2.2", want: 1},
+		{a: "", b: "1.2.3", wantErr: true},
+		{a: "1.2.3", b: "garbage", wantErr: true},
+	}
+	for _, tc := range tcs {
+		got, err := CompareDriverVersions(tc.a, tc.b)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("CompareDriverVersions(%q, %q) error = %v, wantErr %v", tc.a, tc.b, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("CompareDriverVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestMakeDiskImageSectorSize(t *testing.T) {
+	for _, bad := range []int{-1, 256, 1000, 4097} {
+		if err := validateSectorSize(bad); err == nil {
+			t.Errorf("validateSectorSize(%d) expected an error", bad)
+		}
+	}
+
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+
+	sizeMb := defaultMinDiskSizeMB + 1
+	opts := DiskImageOptions{SectorSize: 4096}
+	if err := MakeDiskImageContext(context.Background(), d, "file://"+iso, sizeMb, opts); err != nil {
+		t.Fatalf("MakeDiskImageContext() error = %v", err)
+	}
+	fi, err := os.Stat(GetDiskPath(d))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if fi.Size()%4096 != 0 || fi.Size() < int64(sizeMb)*bytesPerMB || fi.Size()-int64(sizeMb)*bytesPerMB >= 4096 {
+		t.Errorf("disk size = %d, want %dMB rounded up to a multiple of 4096", fi.Size(), sizeMb)
+	}
+	m, err := ReadDiskMetadata(d)
+	if err != nil {
+		t.Fatalf("ReadDiskMetadata() error = %v", err)
+	}
+	if m.SectorSize != 4096 {
+		t.Errorf("recorded sector size = %d, want 4096", m.SectorSize)
+	}
+}
+
+func TestInstallOrUpdateLookPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	writeStubDriver(t, tmpdir, kvmDriver, "echo version: v1.3.0")
+	// the real PATH must not be consulted
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(tmpdir, "empty"))
+
+	var looked []string
+	opts := InstallOptions{LookPath: func(file string) (string, error) {
+		looked = append(looked, file)
+		p := filepath.Join(tmpdir, file)
+		if _, err := os.Stat(p); err != nil {
+			return "", err
+		}
+		return p, nil
+	}}
+	got, err := InstallOrUpdateWithResult(kvmDriver, filepath.Join(tmpdir, "bin"), semver.MustParse("1.3.0"), opts)
+	if err != nil {
+		t.Fatalf("InstallOrUpdateWithResult() error = %v", err)
+	}
+	if got.Action != DriverSkipped {
+		t.Errorf("InstallOrUpdateWithResult() action = %v, want %v for the stub in the lookup dir", got.Action, DriverSkipped)
+	}
+	if len(looked) != 1 || looked[0] != kvmDriver {
+		t.Errorf("looked up %v, want [%s]", looked, kvmDriver)
+	}
+}
+
+func TestInstallOrUpdateRejectsUnsafeNames(t *testing.T) {
+	var tcs = []struct {
+		desc   string
+		driver string
+		opts   InstallOptions
+	}{
+		{desc: "traversal", driver: "../../bin/sh"},
+		{desc: "leading dash", driver: "-docker-machine-driver-kvm2"},
+		{desc: "not allowed", driver: "docker-machine-driver-evil"},
+		{desc: "traversal filename", driver: kvmDriver, opts: InstallOptions{Filename: "../kvm2"}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+			if _, err := InstallOrUpdateWithResult(tc.driver, tmpdir, semver.MustParse("1.3.0"), tc.opts); err == nil {
+				t.Errorf("InstallOrUpdateWithResult(%q) succeeded, want an error", tc.driver)
+			}
+			if err := download(tc.driver, tmpdir, tc.opts); err == nil {
+				t.Errorf("download(%q) succeeded, want an error", tc.driver)
+			}
+		})
+	}
 }
@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// iso9660Magic is the standard identifier of the first ISO9660 volume descriptor
+var iso9660Magic = []byte("CD001")
+
+// iso9660MagicOffset is where iso9660Magic is found: 16 sectors of 2048 bytes, plus the descriptor type byte
+const iso9660MagicOffset = 16*2048 + 1
+
+// isoDecompressors open a decompressing reader for each supported ISO compression, by extension
+var isoDecompressors = map[string]func(io.Reader) (io.Reader, error){
+	".gz":  func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	".bz2": func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+	".xz":  func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) },
+}
+
+// CopyISO copies the boot2docker ISO at isoURL into the machine directory, and checks that it is an ISO.
+// ISOs compressed with gzip, bzip2 or xz, as told by the extension, are decompressed.
+// This refreshes the ISO without rebuilding the machine disk.
+func CopyISO(d *drivers.BaseDriver, isoURL string) error {
+	return CopyISOWithOptions(d, isoURL, DiskImageOptions{})
+}
+
+// CopyISOWithOptions copies the boot2docker ISO like CopyISO, honoring opts.VerifySegments.
+func CopyISOWithOptions(d *drivers.BaseDriver, isoURL string, opts DiskImageOptions) error {
+	return copyISOContext(context.Background(), d, isoURL, opts)
+}
+
+// copyISOContext is CopyISOWithOptions, stopping downloads and decompression early once ctx is
+// done. A copy by copyIsoToMachineDir can't be interrupted, and runs to completion.
+func copyISOContext(ctx context.Context, d *drivers.BaseDriver, isoURL string, opts DiskImageOptions) error {
+	start := time.Now()
+	isoPath := d.ResolveStorePath(isoFilename)
+	// the ISO may be a hard link into an ISO cache, which copying over it would corrupt
+	if err := os.Remove(isoPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove old iso")
+	}
+	copyISO := func() error { return copyIsoToMachineDir(d.StorePath, isoURL, d.MachineName) }
+	if opts.VerifySegments && isHTTPURL(isoURL) {
+		client := (InstallOptions{}).httpClient()
+		m, err := fetchSegmentManifest(client, isoURL)
+		if err != nil {
+			return errors.Wrap(err, "segment manifest")
+		}
+		if m != nil {
+			copyISO = func() error { return downloadISOSegments(ctx, client, isoURL, isoPath, m) }
+		} else {
+			glog.Infof("no segment manifest for %s, only verifying the whole ISO", isoURL)
+		}
+	}
+	if err := copyISO(); err != nil {
+		return errors.Wrap(err, "copy iso to machine dir")
+	}
+	if ext := isoCompression(isoURL); ext != "" {
+		if err := decompressISO(ctx, isoPath, ext); err != nil {
+			return errors.Wrapf(err, "decompress %s", isoURL)
+		}
+	}
+	if err := verifyISO(isoPath); err != nil {
+		return errors.Wrapf(err, "verify %s", isoPath)
+	}
+	glog.Infof("Copied ISO to %s in %s", isoPath, time.Since(start))
+	return nil
+}
+
+// isHTTPURL returns whether isoURL is fetched over HTTP(S)
+func isHTTPURL(isoURL string) bool {
+	u, err := url.Parse(isoURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// downloadISOSegments downloads the ISO at isoURL to isoPath, checking each segment against m
+// as it streams in, so that a corrupt ISO fails at the first bad segment
+func downloadISOSegments(ctx context.Context, client *http.Client, isoURL, isoPath string, m *segmentManifest) error {
+	req, err := http.NewRequest(http.MethodGet, isoURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	// closing the body before it is drained also drops the connection of an abandoned download
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s: %s", isoURL, resp.Status)
+	}
+	f, err := os.OpenFile(isoPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, newSegmentReader(resp.Body, m, isoURL)); err != nil {
+		f.Close()
+		os.Remove(isoPath)
+		return err
+	}
+	return f.Close()
+}
+
+// isoCompression returns the compression extension of isoURL, or "" if it is not compressed
+func isoCompression(isoURL string) string {
+	p := isoURL
+	if u, err := url.Parse(isoURL); err == nil && u.Path != "" {
+		p = u.Path
+	}
+	ext := strings.ToLower(path.Ext(p))
+	if _, ok := isoDecompressors[ext]; ok {
+		return ext
+	}
+	return ""
+}
+
+// decompressISO replaces the compressed ISO at isoPath with its contents
+func decompressISO(ctx context.Context, isoPath, ext string) error {
+	compressed := isoPath + ext
+	if err := os.Rename(isoPath, compressed); err != nil {
+		return err
+	}
+	defer os.Remove(compressed)
+
+	in, err := os.Open(compressed)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, err := isoDecompressors[ext](ctxReader{ctx, in})
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(isoPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(isoPath)
+		return err
+	}
+	return out.Close()
+}
+
+// verifyISO returns an error if the file at path is not an ISO9660 image
+func verifyISO(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, len(iso9660Magic))
+	if _, err := f.ReadAt(magic, iso9660MagicOffset); err != nil && err != io.EOF {
+		return errors.Wrap(err, "read volume descriptor")
+	}
+	if !bytes.Equal(magic, iso9660Magic) {
+		return errors.New("not an ISO9660 image")
+	}
+	return nil
+}
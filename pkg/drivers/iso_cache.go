@@ -0,0 +1,225 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// isoCacheIndex is the file in an ISO cache recording which content each ISO URL resolved to
+const isoCacheIndex = "index.json"
+
+// isoCacheMu serializes updates to ISO cache indexes within this process
+var isoCacheMu sync.Mutex
+
+// isoCacheEntry records the content an ISO URL resolved to
+type isoCacheEntry struct {
+	// SHA256 is the hex digest of the decompressed ISO, which names it in the cache
+	SHA256 string `json:"sha256"`
+	// SourceSize and SourceModTime describe a local source ISO when it was cached, so that
+	// changing it invalidates the entry.
+	SourceSize    int64     `json:"sourceSize,omitempty"`
+	SourceModTime time.Time `json:"sourceModTime,omitempty"`
+	// ETag and LastModified are the validators a remote ISO was served with, so that the entry
+	// is only used while the server still serves the same ISO. A remote ISO served with neither
+	// is never taken from the cache.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// isoValidators returns the ETag and Last-Modified headers the ISO at isoURL is served with
+func isoValidators(client *http.Client, isoURL string) (string, string, error) {
+	resp, err := client.Head(isoURL)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "HEAD %s", isoURL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", errors.Errorf("HEAD %s: %s", isoURL, resp.Status)
+	}
+	return resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// stillServed returns whether the server still serves the ISO that e was cached from
+func (e isoCacheEntry) stillServed(client *http.Client, isoURL string) bool {
+	if e.ETag == "" && e.LastModified == "" {
+		glog.Infof("%s was served without validators, so can't be taken from the cache", isoURL)
+		return false
+	}
+	etag, lastModified, err := isoValidators(client, isoURL)
+	if err != nil {
+		glog.Infof("unable to revalidate the cached %s: %v", isoURL, err)
+		return false
+	}
+	if (e.ETag != "" && etag != e.ETag) || (e.ETag == "" && lastModified != e.LastModified) {
+		glog.Infof("%s changed since it was cached", isoURL)
+		return false
+	}
+	return true
+}
+
+// isoCacheBlob returns the path of the ISO with the given digest in cacheDir
+func isoCacheBlob(cacheDir, digest string) string {
+	return filepath.Join(cacheDir, "sha256", digest+".iso")
+}
+
+// localISOSource returns the file isoURL refers to, if it is local
+func localISOSource(isoURL string) (string, bool) {
+	u, err := url.Parse(isoURL)
+	if err != nil || u.Scheme == "" {
+		return isoURL, true
+	}
+	if u.Scheme == "file" {
+		return u.Path, true
+	}
+	return "", false
+}
+
+// readISOCacheIndex returns the entries of the ISO cache in cacheDir, by URL
+func readISOCacheIndex(cacheDir string) (map[string]isoCacheEntry, error) {
+	index := map[string]isoCacheEntry{}
+	b, err := ioutil.ReadFile(filepath.Join(cacheDir, isoCacheIndex))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, errors.Wrap(err, "parse iso cache index")
+	}
+	return index, nil
+}
+
+// cachedISO returns the cache entry for isoURL, if it's still valid and its ISO is present
+func cachedISO(cacheDir, isoURL string) (isoCacheEntry, bool) {
+	index, err := readISOCacheIndex(cacheDir)
+	if err != nil {
+		glog.Warningf("ignoring unreadable iso cache: %v", err)
+		return isoCacheEntry{}, false
+	}
+	e, ok := index[isoURL]
+	if !ok {
+		return e, false
+	}
+	if src, local := localISOSource(isoURL); local {
+		fi, err := os.Stat(src)
+		if err != nil || fi.Size() != e.SourceSize || !fi.ModTime().Equal(e.SourceModTime) {
+			glog.Infof("%s changed since it was cached", src)
+			return e, false
+		}
+	} else if !e.stillServed((InstallOptions{}).httpClient(), isoURL) {
+		return e, false
+	}
+	if _, err := os.Stat(isoCacheBlob(cacheDir, e.SHA256)); err != nil {
+		return e, false
+	}
+	return e, true
+}
+
+// copyISOCached puts the ISO at isoURL in the machine directory like CopyISOWithOptions, but
+// through the content addressed cache in opts.ISOCacheDir, so that machines booting the same ISO
+// share one copy of it rather than each fetching it again
+func copyISOCached(ctx context.Context, d *drivers.BaseDriver, isoURL string, opts DiskImageOptions) error {
+	cacheDir := opts.ISOCacheDir
+	isoPath := d.ResolveStorePath(isoFilename)
+	if e, ok := cachedISO(cacheDir, isoURL); ok {
+		glog.Infof("Using cached ISO %s for %s", e.SHA256, isoURL)
+		if err := os.Remove(isoPath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove old iso")
+		}
+		return linkOrCopy(isoCacheBlob(cacheDir, e.SHA256), isoPath)
+	}
+
+	var e isoCacheEntry
+	if src, local := localISOSource(isoURL); local {
+		if fi, err := os.Stat(src); err == nil {
+			e.SourceSize, e.SourceModTime = fi.Size(), fi.ModTime()
+		}
+	} else if isHTTPURL(isoURL) {
+		// taken before the download, so that an ISO replaced meanwhile only costs another fetch
+		var err error
+		if e.ETag, e.LastModified, err = isoValidators((InstallOptions{}).httpClient(), isoURL); err != nil {
+			glog.Infof("unable to get the validators of %s, it won't be taken from the cache: %v", isoURL, err)
+		}
+	}
+	if err := copyISOContext(ctx, d, isoURL, opts); err != nil {
+		return err
+	}
+	sum, err := fileSHA256(isoPath)
+	if err != nil {
+		return errors.Wrap(err, "hash iso")
+	}
+	e.SHA256 = hex.EncodeToString(sum)
+
+	// failing to populate the cache only costs a fetch next time
+	if err := addToISOCache(cacheDir, isoURL, isoPath, e); err != nil {
+		glog.Warningf("unable to cache %s: %v", isoURL, err)
+	}
+	return nil
+}
+
+// addToISOCache stores the ISO at isoPath in cacheDir, and records that isoURL resolves to it
+func addToISOCache(cacheDir, isoURL, isoPath string, e isoCacheEntry) error {
+	blob := isoCacheBlob(cacheDir, e.SHA256)
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err := linkOrCopy(isoPath, blob); err != nil {
+			return errors.Wrap(err, "store iso")
+		}
+	}
+
+	isoCacheMu.Lock()
+	defer isoCacheMu.Unlock()
+	index, err := readISOCacheIndex(cacheDir)
+	if err != nil {
+		index = map[string]isoCacheEntry{}
+	}
+	index[isoURL] = e
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(cacheDir, isoCacheIndex+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(cacheDir, isoCacheIndex))
+}
+
+// linkOrCopy hard links src to dst, copying it where links aren't possible, e.g. across filesystems
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyDisk(src, dst)
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestCopyISOCached(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+	isoURL := "file://" + iso
+	cacheDir := filepath.Join(tmpdir, "cache")
+
+	var fetches int
+	orig := copyIsoToMachineDir
+	defer func() { copyIsoToMachineDir = orig }()
+	copyIsoToMachineDir = func(storePath, url, machineName string) error {
+		fetches++
+		return orig(storePath, url, machineName)
+	}
+
+	copyTo := func(name string) *drivers.BaseDriver {
+		d := &drivers.BaseDriver{MachineName: name, StorePath: tmpdir}
+		if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := copyISOCached(context.Background(), d, isoURL, DiskImageOptions{ISOCacheDir: cacheDir}); err != nil {
+			t.Fatalf("copyISOCached(%s) error = %v", name, err)
+		}
+		if err := verifyISO(d.ResolveStorePath(isoFilename)); err != nil {
+			t.Errorf("%s ISO: %v", name, err)
+		}
+		return d
+	}
+	for i := 0; i < 2; i++ {
+		copyTo(fmt.Sprintf("profile%d", i))
+	}
+	if fetches != 1 {
+		t.Errorf("ISO was fetched %d times, want 1", fetches)
+	}
+
+	// changing the source makes the cached copy stale
+	writeISOFixture(t, iso)
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(iso, future, future); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	copyTo("profile2")
+	if fetches != 2 {
+		t.Errorf("ISO was fetched %d times after it changed, want 2", fetches)
+	}
+}
+
+func TestCopyISOCachedRemote(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+	cacheDir := filepath.Join(tmpdir, "cache")
+
+	var etag, lastModified string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if lastModified != "" {
+			w.Header().Set("Last-Modified", lastModified)
+		}
+		if r.Method == http.MethodGet {
+			b, _ := ioutil.ReadFile(iso)
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+	isoURL := srv.URL + "/minikube.iso"
+
+	var fetches int
+	orig := copyIsoToMachineDir
+	defer func() { copyIsoToMachineDir = orig }()
+	copyIsoToMachineDir = func(storePath, url, machineName string) error {
+		fetches++
+		d := &drivers.BaseDriver{MachineName: machineName, StorePath: storePath}
+		return copyDisk(iso, d.ResolveStorePath(isoFilename))
+	}
+	profiles := 0
+	copyTo := func() {
+		d := &drivers.BaseDriver{MachineName: fmt.Sprintf("profile%d", profiles), StorePath: tmpdir}
+		profiles++
+		if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := copyISOCached(context.Background(), d, isoURL, DiskImageOptions{ISOCacheDir: cacheDir}); err != nil {
+			t.Fatalf("copyISOCached() error = %v", err)
+		}
+	}
+
+	tcs := []struct {
+		desc         string
+		etag         string
+		lastModified string
+		wantFetch    bool
+	}{
+		{desc: "first fetch", etag: `"v1"`, wantFetch: true},
+		{desc: "same etag", etag: `"v1"`},
+		{desc: "new etag", etag: `"v2"`, wantFetch: true},
+		{desc: "last modified", lastModified: "Mon, 01 Jun 2020 00:00:00 GMT", wantFetch: true},
+		{desc: "same last modified", lastModified: "Mon, 01 Jun 2020 00:00:00 GMT"},
+		{desc: "new last modified", lastModified: "Tue, 02 Jun 2020 00:00:00 GMT", wantFetch: true},
+		{desc: "no validators", wantFetch: true},
+		{desc: "still no validators", wantFetch: true},
+	}
+	for _, tc := range tcs {
+		etag, lastModified = tc.etag, tc.lastModified
+		before := fetches
+		copyTo()
+		if fetched := fetches > before; fetched != tc.wantFetch {
+			t.Errorf("%s: fetched = %v, want %v", tc.desc, fetched, tc.wantFetch)
+		}
+	}
+}
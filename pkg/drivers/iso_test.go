@@ -0,0 +1,226 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/ulikunitz/xz"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+// writeISOFixture writes a minimal file carrying an ISO9660 volume descriptor to path
+func writeISOFixture(t *testing.T, path string) []byte {
+	t.Helper()
+	b := make([]byte, 18*2048)
+	b[iso9660MagicOffset-1] = 1
+	copy(b[iso9660MagicOffset:], iso9660Magic)
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	return b
+}
+
+func TestCopyISO(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	src := filepath.Join(tmpdir, "fixture.iso")
+	want := writeISOFixture(t, src)
+	if err := CopyISO(d, "file://"+src); err != nil {
+		t.Fatalf("CopyISO() error = %v", err)
+	}
+	got, err := ioutil.ReadFile(d.ResolveStorePath(isoFilename))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("copied ISO differs from the fixture")
+	}
+
+	bogus := filepath.Join(tmpdir, "bogus.iso")
+	if err := ioutil.WriteFile(bogus, []byte("not an iso"), 0644); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+	if err := CopyISO(d, "file://"+bogus); err == nil {
+		t.Error("CopyISO() expected an error for a file that is not an ISO")
+	}
+}
+
+func TestCopyCompressedISO(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	want := writeISOFixture(t, filepath.Join(tmpdir, "fixture.iso"))
+
+	var tcs = []struct {
+		ext      string
+		compress func(io.Writer) (io.WriteCloser, error)
+	}{
+		{".xz", func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }},
+		{".gz", func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.ext, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := tc.compress(&buf)
+			if err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+			if _, err := w.Write(want); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			src := filepath.Join(tmpdir, "fixture.iso"+tc.ext)
+			if err := ioutil.WriteFile(src, buf.Bytes(), 0644); err != nil {
+				t.Fatalf("writefile: %v", err)
+			}
+
+			if err := CopyISO(d, "file://"+src); err != nil {
+				t.Fatalf("CopyISO() error = %v", err)
+			}
+			got, err := ioutil.ReadFile(d.ResolveStorePath(isoFilename))
+			if err != nil {
+				t.Fatalf("readfile: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("decompressed ISO differs from the fixture")
+			}
+			if _, err := os.Stat(d.ResolveStorePath(isoFilename + tc.ext)); !os.IsNotExist(err) {
+				t.Errorf("compressed ISO left in the machine directory, stat error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCopyISOVerifySegments(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	iso := writeISOFixture(t, filepath.Join(tmpdir, "fixture.iso"))
+	const segmentSize = 4096
+	var good []string
+	for b := iso; len(b) > 0; b = b[segmentSize:] {
+		sum := sha256.Sum256(b[:segmentSize])
+		good = append(good, hex.EncodeToString(sum[:]))
+	}
+	bad := append([]string{}, good...)
+	bad[2] = strings.Repeat("0", 64)
+
+	var fellBack bool
+	orig := copyIsoToMachineDir
+	defer func() { copyIsoToMachineDir = orig }()
+	copyIsoToMachineDir = func(storePath, isoURL, machineName string) error {
+		fellBack = true
+		return ioutil.WriteFile(d.ResolveStorePath(isoFilename), iso, 0644)
+	}
+
+	var tcs = []struct {
+		desc         string
+		manifest     []string
+		wantErr      string
+		wantFallBack bool
+	}{
+		{desc: "good", manifest: good},
+		{desc: "bad segment", manifest: bad, wantErr: "segment 2 of"},
+		{desc: "no manifest", wantFallBack: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			fellBack = false
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, segmentManifestSuffix) {
+					if tc.manifest == nil {
+						http.NotFound(w, r)
+						return
+					}
+					json.NewEncoder(w).Encode(segmentManifest{SegmentSize: segmentSize, SHA256: tc.manifest})
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(iso)))
+				for i := 0; i < len(iso); i += segmentSize {
+					w.Write(iso[i : i+segmentSize])
+					w.(http.Flusher).Flush()
+					if i == 3*segmentSize && tc.wantErr != "" {
+						// hold back the rest, so that only an early abort can fail the copy quickly
+						select {
+						case <-r.Context().Done():
+							return
+						case <-time.After(10 * time.Second):
+						}
+					}
+				}
+			}))
+			defer srv.Close()
+
+			start := time.Now()
+			err := CopyISOWithOptions(d, srv.URL+"/minikube.iso", DiskImageOptions{VerifySegments: true})
+			if fellBack != tc.wantFallBack {
+				t.Errorf("fell back to copying the whole ISO = %v, want %v", fellBack, tc.wantFallBack)
+			}
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CopyISOWithOptions() error = %v", err)
+				}
+				got, err := ioutil.ReadFile(d.ResolveStorePath(isoFilename))
+				if err != nil {
+					t.Fatalf("readfile: %v", err)
+				}
+				if !bytes.Equal(got, iso) {
+					t.Error("copied ISO differs from the fixture")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("CopyISOWithOptions() error = %v, want one mentioning %q", err, tc.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("copy took %v to fail, want it to abort at the bad segment", elapsed)
+			}
+			if _, err := os.Stat(d.ResolveStorePath(isoFilename)); !os.IsNotExist(err) {
+				t.Errorf("expected no ISO after a bad segment, stat error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// backupManifest is the archive entry listing the sha256 of every backed up file, written last
+const backupManifest = "SHA256SUMS"
+
+// volatileSuffixes mark machine files that only describe a running VM, and are left out of backups
+var volatileSuffixes = []string{".pid", ".sock", ".lock", ".log"}
+
+// isVolatile returns whether the machine file name only matters while the VM is running
+func isVolatile(name string) bool {
+	for _, s := range volatileSuffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGzipped returns whether an archive path should be gzip compressed, by its extension
+func isGzipped(p string) bool {
+	return strings.HasSuffix(p, ".gz") || strings.HasSuffix(p, ".tgz")
+}
+
+// BackupMachine archives the machine directory, including its disk, keys and config, to destTar
+// along with a manifest of checksums. destTar is gzipped if it ends in .gz or .tgz. Files that
+// only matter while the VM runs, such as pid files and sockets, are left out.
+func BackupMachine(d *drivers.BaseDriver, destTar string) error {
+	machPath := d.ResolveStorePath(".")
+	files, err := ioutil.ReadDir(machPath)
+	if err != nil {
+		return errors.Wrap(err, "read machine dir")
+	}
+	f, err := os.OpenFile(destTar, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "create backup")
+	}
+	if err := writeBackup(f, isGzipped(destTar), d.GetMachineName(), machPath, files); err != nil {
+		f.Close()
+		os.Remove(destTar)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(destTar)
+		return errors.Wrapf(err, "closing file %s", destTar)
+	}
+	return nil
+}
+
+// writeBackup writes the regular files among files in machPath to w as a tar, under name
+func writeBackup(w io.Writer, gzipped bool, name, machPath string, files []os.FileInfo) error {
+	bw := bufio.NewWriter(w)
+	out := io.Writer(bw)
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(bw)
+		out = gz
+	}
+	tw := tar.NewWriter(out)
+
+	var sums strings.Builder
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() || isVolatile(fi.Name()) {
+			continue
+		}
+		entry := path.Join(name, fi.Name())
+		glog.Infof("Backing up %s ...", entry)
+		sum, err := writeBackupFile(tw, entry, filepath.Join(machPath, fi.Name()), fi)
+		if err != nil {
+			return errors.Wrapf(err, "back up %s", fi.Name())
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, entry)
+	}
+	if err := writeTarFile(tw, backupManifest, 0644, []byte(sums.String())); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "close tar")
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return errors.Wrap(err, "close gzip")
+		}
+	}
+	return bw.Flush()
+}
+
+// writeBackupFile adds the file at p to tw as entry, returning its hex sha256
+func writeBackupFile(tw *tar.Writer, entry, p string, fi os.FileInfo) (string, error) {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return "", err
+	}
+	hdr.Name = entry
+	if err := tw.WriteHeader(hdr); err != nil {
+		return "", err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// RestoreMachine restores a machine archived by BackupMachine into storePath. Every file is
+// verified against the archived checksums before the machine directory is put in place, and an
+// existing machine of the same name is never overwritten.
+func RestoreMachine(storePath, srcTar string) error {
+	f, err := os.Open(srcTar)
+	if err != nil {
+		return errors.Wrap(err, "open backup")
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if isGzipped(srcTar) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "gunzip backup")
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	machines := filepath.Join(storePath, "machines")
+	if err := os.MkdirAll(machines, 0700); err != nil {
+		return errors.Wrap(err, "create machines dir")
+	}
+	stage, err := ioutil.TempDir(machines, ".restore-")
+	if err != nil {
+		return errors.Wrap(err, "staging directory")
+	}
+	defer os.RemoveAll(stage)
+
+	name, got, want, err := extractBackup(tar.NewReader(r), stage)
+	if err != nil {
+		return err
+	}
+	if want == nil {
+		return errors.Errorf("%s has no %s, it is not a machine backup", srcTar, backupManifest)
+	}
+	for entry, sum := range want {
+		if got[entry] != sum {
+			return errors.Errorf("%s in %s is corrupt or missing", entry, srcTar)
+		}
+	}
+	for entry := range got {
+		if _, ok := want[entry]; !ok {
+			return errors.Errorf("%s in %s is not in its %s", entry, srcTar, backupManifest)
+		}
+	}
+
+	d := &drivers.BaseDriver{MachineName: name, StorePath: storePath}
+	machPath := d.ResolveStorePath(".")
+	if _, err := os.Stat(machPath); err == nil {
+		return errors.Errorf("machine %s already exists in %s", name, storePath)
+	}
+	if err := os.Rename(filepath.Join(stage, name), machPath); err != nil {
+		return errors.Wrap(err, "move restored machine into place")
+	}
+	uid, gid := DiskImageOptions{}.ownership()
+	if err := fixPermissions(machPath, uid, gid, PermissionsDefault); err != nil {
+		return errors.Wrapf(err, "fixing permissions on %s", machPath)
+	}
+	return EnsureSSHKeyPermissions(d)
+}
+
+// extractBackup unpacks the machine files in tr into stage, returning the machine name, the
+// sha256 of each extracted entry, and the checksums listed in the archive's manifest
+func extractBackup(tr *tar.Reader, stage string) (string, map[string]string, map[string]string, error) {
+	var name string
+	got := map[string]string{}
+	var want map[string]string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return name, got, want, nil
+		}
+		if err != nil {
+			return "", nil, nil, errors.Wrap(err, "read backup")
+		}
+		if hdr.Name == backupManifest {
+			if want, err = parseBackupManifest(tr); err != nil {
+				return "", nil, nil, err
+			}
+			continue
+		}
+
+		dir, file := path.Split(path.Clean(hdr.Name))
+		dir = strings.TrimSuffix(dir, "/")
+		if hdr.Typeflag != tar.TypeReg || dir == "" || strings.Contains(dir, "/") || dir == ".." || file == ".." {
+			return "", nil, nil, errors.Errorf("unexpected entry %q in backup", hdr.Name)
+		}
+		if name == "" {
+			name = dir
+			if err := os.Mkdir(filepath.Join(stage, name), 0700); err != nil {
+				return "", nil, nil, err
+			}
+		} else if dir != name {
+			return "", nil, nil, errors.Errorf("backup holds more than one machine: %s and %s", name, dir)
+		}
+
+		out, err := os.OpenFile(filepath.Join(stage, name, file), os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return "", nil, nil, err
+		}
+		// disks are mostly zeros, so keep them sparse
+		h := sha256.New()
+		err = sparseCopy(out, io.TeeReader(tr, h))
+		if err == nil {
+			err = out.Truncate(hdr.Size)
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", nil, nil, errors.Wrapf(err, "restore %s", hdr.Name)
+		}
+		got[path.Join(name, file)] = hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// parseBackupManifest reads a manifest of "<sha256>  <entry>" lines
+func parseBackupManifest(r io.Reader) (map[string]string, error) {
+	sums := map[string]string{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 || !validSHA256(fields[0]) {
+			return nil, errors.Errorf("malformed %s line %q", backupManifest, s.Text())
+		}
+		sums[fields[1]] = fields[0]
+	}
+	return sums, errors.Wrap(s.Err(), "read manifest")
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestBackupRestoreMachine(t *testing.T) {
+	for _, archive := range []string{"backup.tar", "backup.tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			d, _, cleanup := newKeyedMachine(t)
+			defer cleanup()
+			machPath := d.ResolveStorePath(".")
+			if err := createRawDiskImage(publicSSHKeyPath(d), GetDiskPath(d), 5, 0, nil); err != nil {
+				t.Fatalf("createRawDiskImage() error = %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(machPath, "config.json"), []byte("{}"), 0644); err != nil {
+				t.Fatalf("writefile: %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(machPath, "hyperkit.pid"), []byte("123"), 0644); err != nil {
+				t.Fatalf("writefile: %v", err)
+			}
+
+			dest := filepath.Join(d.StorePath, archive)
+			if err := BackupMachine(d, dest); err != nil {
+				t.Fatalf("BackupMachine() error = %v", err)
+			}
+			restored := tests.MakeTempDir()
+			defer os.RemoveAll(restored)
+			if err := RestoreMachine(restored, dest); err != nil {
+				t.Fatalf("RestoreMachine() error = %v", err)
+			}
+
+			r := &drivers.BaseDriver{MachineName: d.MachineName, StorePath: restored}
+			for _, name := range []string{filepath.Base(GetDiskPath(d)), "id_rsa", "id_rsa.pub", "config.json"} {
+				want, err := ioutil.ReadFile(filepath.Join(machPath, name))
+				if err != nil {
+					t.Fatalf("readfile: %v", err)
+				}
+				got, err := ioutil.ReadFile(r.ResolveStorePath(name))
+				if err != nil {
+					t.Errorf("%s was not restored: %v", name, err)
+					continue
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("restored %s doesn't match the original", name)
+				}
+			}
+			if _, err := os.Stat(r.ResolveStorePath("hyperkit.pid")); !os.IsNotExist(err) {
+				t.Errorf("volatile hyperkit.pid should not be restored: %v", err)
+			}
+			if fi, err := os.Stat(r.GetSSHKeyPath()); err == nil && fi.Mode().Perm() != privateKeyMode {
+				t.Errorf("restored private key mode = %v, want %v", fi.Mode().Perm(), os.FileMode(privateKeyMode))
+			}
+
+			if err := RestoreMachine(restored, dest); err == nil {
+				t.Error("RestoreMachine() over an existing machine succeeded")
+			}
+		})
+	}
+}
+
+func TestRestoreMachineCorrupt(t *testing.T) {
+	d, _, cleanup := newKeyedMachine(t)
+	defer cleanup()
+	dest := filepath.Join(d.StorePath, "backup.tar")
+	if err := BackupMachine(d, dest); err != nil {
+		t.Fatalf("BackupMachine() error = %v", err)
+	}
+	b, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	// flip a byte of the private key, which starts the first entry's content
+	b[512] ^= 0xff
+	if err := ioutil.WriteFile(dest, b, 0600); err != nil {
+		t.Fatalf("writefile: %v", err)
+	}
+
+	restored := tests.MakeTempDir()
+	defer os.RemoveAll(restored)
+	if err := RestoreMachine(restored, dest); err == nil {
+		t.Fatal("RestoreMachine() of a corrupt backup succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(restored, "machines", d.MachineName)); !os.IsNotExist(err) {
+		t.Errorf("corrupt backup left a machine behind: %v", err)
+	}
+}
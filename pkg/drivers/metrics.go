@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import "time"
+
+// The phases reported to a MetricsSink
+const (
+	// PhaseISOCopy is copying the ISO into the machine directory
+	PhaseISOCopy = "iso-copy"
+	// PhaseKeyGen is generating the machine SSH key
+	PhaseKeyGen = "ssh-keygen"
+	// PhaseDiskCreate is building the raw disk image
+	PhaseDiskCreate = "disk-create"
+	// PhaseDownload is fetching a driver binary
+	PhaseDownload = "download"
+)
+
+// MetricsSink receives how long each phase of an operation took, e.g. to surface slow starts.
+// It may be called from a goroutine other than the caller's.
+type MetricsSink func(phase string, d time.Duration)
+
+// record reports d for phase, if m is set
+func (m MetricsSink) record(phase string, d time.Duration) {
+	if m != nil {
+		m(phase, d)
+	}
+}
+
+// timed wraps fn so that its duration is reported for phase
+func (m MetricsSink) timed(phase string, fn func() error) func() error {
+	return func() error {
+		start := time.Now()
+		err := fn()
+		m.record(phase, time.Since(start))
+		return err
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+// phaseRecorder is a MetricsSink that remembers the phases it was given
+type phaseRecorder struct {
+	mu     sync.Mutex
+	phases []string
+}
+
+func (r *phaseRecorder) record(phase string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d > 0 {
+		r.phases = append(r.phases, phase)
+	}
+}
+
+func TestMakeDiskImageMetrics(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+
+	r := &phaseRecorder{}
+	opts := DiskImageOptions{Metrics: r.record}
+	if err := MakeDiskImageContext(context.Background(), d, "file://"+iso, defaultMinDiskSizeMB, opts); err != nil {
+		t.Fatalf("MakeDiskImageContext() error = %v", err)
+	}
+	want := []string{PhaseISOCopy, PhaseKeyGen, PhaseDiskCreate}
+	if !reflect.DeepEqual(r.phases, want) {
+		t.Errorf("reported phases = %v, want %v", r.phases, want)
+	}
+}
+
+func TestDownloadMetrics(t *testing.T) {
+	_, cleanup := serveDriver(t, "driver")
+	defer cleanup()
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	r := &phaseRecorder{}
+	if err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, Metrics: r.record}); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+	if want := []string{PhaseDownload}; !reflect.DeepEqual(r.phases, want) {
+		t.Errorf("reported phases = %v, want %v", r.phases, want)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/pkg/errors"
+)
+
+// diskImageParallelism bounds how many node disks MakeDiskImages builds at once
+const diskImageParallelism = 4
+
+// NodeSpec describes the disk image of one node of a cluster
+type NodeSpec struct {
+	// Driver is the node's machine
+	Driver *drivers.BaseDriver
+	// Boot2DockerURL is the ISO to boot the node from
+	Boot2DockerURL string
+	// DiskSize is the size of the node's disk, in megabytes
+	DiskSize int
+	// Options are the optional disk image settings for the node
+	Options DiskImageOptions
+}
+
+// MakeDiskImages makes the disk images of several nodes concurrently. Each ISO is fetched once
+// and copied locally to the nodes that boot from it, while each node gets its own SSH key.
+func MakeDiskImages(nodes []NodeSpec) error {
+	seen := map[string]bool{}
+	for _, n := range nodes {
+		dir := n.Driver.ResolveStorePath(".")
+		if seen[dir] {
+			return errors.Errorf("more than one node uses the machine directory %s", dir)
+		}
+		seen[dir] = true
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	stage, err := ioutil.TempDir(nodes[0].Driver.StorePath, ".iso-")
+	if err != nil {
+		return errors.Wrap(err, "create iso staging directory")
+	}
+	defer os.RemoveAll(stage)
+	isos := map[string]string{}
+	for _, n := range nodes {
+		if _, ok := isos[n.Boot2DockerURL]; ok {
+			continue
+		}
+		// Copy into a machine of our own, so that CopyISOWithOptions can be reused
+		shared := &drivers.BaseDriver{MachineName: fmt.Sprintf("iso%d", len(isos)), StorePath: stage}
+		if err := os.MkdirAll(shared.ResolveStorePath("."), 0755); err != nil {
+			return errors.Wrap(err, "create iso staging directory")
+		}
+		if err := CopyISOWithOptions(shared, n.Boot2DockerURL, n.Options); err != nil {
+			return err
+		}
+		isos[n.Boot2DockerURL] = "file://" + shared.ResolveStorePath(isoFilename)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed []string
+	sem := make(chan struct{}, diskImageParallelism)
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n NodeSpec) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := MakeDiskImageContext(context.Background(), n.Driver, isos[n.Boot2DockerURL], n.DiskSize, n.Options); err != nil {
+				mu.Lock()
+				failed = append(failed, n.Driver.MachineName+": "+err.Error())
+				mu.Unlock()
+			}
+		}(n)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return errors.Errorf("making node disk images: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestMakeDiskImages(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	iso := filepath.Join(tmpdir, "fixture.iso")
+	writeISOFixture(t, iso)
+	isoURL := "file://" + iso
+
+	// Count fetches of the original ISO, which should happen once however many nodes there are
+	var mu sync.Mutex
+	var fetches int
+	orig := copyIsoToMachineDir
+	defer func() { copyIsoToMachineDir = orig }()
+	copyIsoToMachineDir = func(storePath, url, machineName string) error {
+		if url == isoURL {
+			mu.Lock()
+			fetches++
+			mu.Unlock()
+		}
+		return orig(storePath, url, machineName)
+	}
+
+	var nodes []NodeSpec
+	for i := 0; i < 3; i++ {
+		d := &drivers.BaseDriver{MachineName: fmt.Sprintf("node%d", i), StorePath: tmpdir}
+		if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		nodes = append(nodes, NodeSpec{Driver: d, Boot2DockerURL: isoURL, DiskSize: defaultMinDiskSizeMB})
+	}
+	if err := MakeDiskImages(nodes); err != nil {
+		t.Fatalf("MakeDiskImages() error = %v", err)
+	}
+	if fetches != 1 {
+		t.Errorf("ISO was fetched %d times, want 1", fetches)
+	}
+
+	keys := map[string]bool{}
+	for _, n := range nodes {
+		if err := verifyISO(n.Driver.ResolveStorePath(isoFilename)); err != nil {
+			t.Errorf("%s ISO: %v", n.Driver.MachineName, err)
+		}
+		if err := VerifyDiskImage(GetDiskPath(n.Driver), DiskFormatRaw); err != nil {
+			t.Errorf("%s disk: %v", n.Driver.MachineName, err)
+		}
+		pub, err := ioutil.ReadFile(publicSSHKeyPath(n.Driver))
+		if err != nil {
+			t.Fatalf("readfile: %v", err)
+		}
+		keys[string(pub)] = true
+	}
+	if len(keys) != len(nodes) {
+		t.Errorf("%d distinct ssh keys for %d nodes, want one each", len(keys), len(nodes))
+	}
+
+	if err := MakeDiskImages([]NodeSpec{nodes[0], nodes[0]}); err == nil {
+		t.Error("MakeDiskImages() expected an error for nodes sharing a machine directory")
+	}
+}
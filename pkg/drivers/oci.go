@@ -0,0 +1,308 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/go-getter"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ociManifestMediaType is the media type of OCI image manifests
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// ociTitleAnnotation names the file a layer holds
+	ociTitleAnnotation = "org.opencontainers.image.title"
+	// ociScheme optionally prefixes registry references
+	ociScheme = "oci://"
+)
+
+// ociReference is a parsed registry reference, e.g. registry.example.com/tools/kvm2:v1.3.0
+type ociReference struct {
+	// registry is the registry host, with its port if any
+	registry string
+	// repository is the path of the repository within the registry
+	repository string
+	// reference is a tag, or a digest such as sha256:...
+	reference string
+}
+
+// parseOCIReference parses ref, which must name a registry host. The tag defaults to latest.
+func parseOCIReference(ref string) (ociReference, error) {
+	s := strings.TrimPrefix(ref, ociScheme)
+	i := strings.Index(s, "/")
+	if i <= 0 || i == len(s)-1 {
+		return ociReference{}, errors.Errorf("invalid OCI reference %q, want registry/repository[:tag|@digest]", ref)
+	}
+	r := ociReference{registry: s[:i], repository: s[i+1:], reference: "latest"}
+	if j := strings.Index(r.repository, "@"); j >= 0 {
+		r.repository, r.reference = r.repository[:j], r.repository[j+1:]
+	} else if j := strings.LastIndex(r.repository, ":"); j > strings.LastIndex(r.repository, "/") {
+		r.repository, r.reference = r.repository[:j], r.repository[j+1:]
+	}
+	if r.repository == "" || r.reference == "" {
+		return ociReference{}, errors.Errorf("invalid OCI reference %q", ref)
+	}
+	return r, nil
+}
+
+// ociDescriptor describes content in a registry
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest is the part of an OCI image manifest needed to find a driver
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// ociClient pulls content from a single registry, anonymously
+type ociClient struct {
+	client *http.Client
+	// base is the registry API root, e.g. https://registry.example.com/v2/
+	base string
+	// token is the bearer token the registry issued, if it asked for one
+	token string
+}
+
+// get fetches path below the registry API root, obtaining an anonymous token if challenged
+func (c *ociClient) get(p, accept string) (*http.Response, error) {
+	resp, err := c.do(p, accept)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.token != "" {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if c.token, err = c.anonymousToken(challenge); err != nil {
+		return nil, errors.Wrap(err, "authenticate to registry")
+	}
+	return c.do(p, accept)
+}
+
+// do sends a single GET for path below the registry API root
+func (c *ociClient) do(p, accept string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.base+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.client.Do(req)
+}
+
+// anonymousToken requests a pull token from the realm in a Bearer WWW-Authenticate challenge
+func (c *ociClient) anonymousToken(challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", errors.Errorf("unsupported registry authentication %q", challenge)
+	}
+	params := map[string]string{}
+	for _, kv := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			params[strings.TrimSpace(kv[:i])] = strings.Trim(strings.TrimSpace(kv[i+1:]), "\"")
+		}
+	}
+	req, err := http.NewRequest("GET", params["realm"], nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("token request: %s", resp.Status)
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", errors.Wrap(err, "decode token")
+	}
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	return t.Token, nil
+}
+
+// pullOCIDriver downloads driver from the registry artifact ref to dst, verifying the layer
+// against the digest in the manifest. Layers may hold the bare binary, or a tar or gzipped tar
+// containing it.
+func pullOCIDriver(client *http.Client, ref string, plainHTTP bool, driver, dst string, progress getter.ProgressTracker) error {
+	r, err := parseOCIReference(ref)
+	if err != nil {
+		return err
+	}
+	scheme := "https"
+	if plainHTTP {
+		scheme = "http"
+	}
+	c := &ociClient{client: client, base: fmt.Sprintf("%s://%s/v2/%s/", scheme, r.registry, r.repository)}
+
+	resp, err := c.get("manifests/"+r.reference, ociManifestMediaType)
+	if err != nil {
+		return errors.Wrap(err, "fetch manifest")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("fetch manifest %s: %s", ref, resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return errors.Wrap(err, "read manifest")
+	}
+	if strings.HasPrefix(r.reference, "sha256:") {
+		if err := verifyDigest(b, r.reference); err != nil {
+			return errors.Wrap(err, "manifest")
+		}
+	}
+	var m ociManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return errors.Wrap(err, "parse manifest")
+	}
+	layer, err := driverLayer(m, driver)
+	if err != nil {
+		return errors.Wrap(err, ref)
+	}
+	if !strings.HasPrefix(layer.Digest, "sha256:") {
+		return errors.Errorf("unsupported layer digest %q", layer.Digest)
+	}
+
+	blob, err := c.get("blobs/"+layer.Digest, "")
+	if err != nil {
+		return errors.Wrap(err, "fetch layer")
+	}
+	defer blob.Body.Close()
+	if blob.StatusCode != http.StatusOK {
+		return errors.Errorf("fetch layer %s: %s", layer.Digest, blob.Status)
+	}
+
+	// stage the layer and check it before unpacking anything from it
+	staged := dst + ".layer"
+	defer os.Remove(staged)
+	f, err := os.OpenFile(staged, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	body := progress.TrackProgress(ref, 0, layer.Size, blob.Body)
+	_, err = io.Copy(io.MultiWriter(f, h), body)
+	body.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "download layer")
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != layer.Digest {
+		return errors.Errorf("layer checksum did not match: expected %s, got %s", layer.Digest, got)
+	}
+
+	if !strings.Contains(layer.MediaType, "tar") {
+		return os.Rename(staged, dst)
+	}
+	return extractDriver(staged, strings.Contains(layer.MediaType, "gzip"), driver, dst)
+}
+
+// verifyDigest returns an error if b doesn't hash to digest
+func verifyDigest(b []byte, digest string) error {
+	sum := sha256.Sum256(b)
+	if got := "sha256:" + hex.EncodeToString(sum[:]); got != digest {
+		return errors.Errorf("checksum did not match: expected %s, got %s", digest, got)
+	}
+	return nil
+}
+
+// driverLayer returns the manifest layer titled driver, or the only layer if none are titled
+func driverLayer(m ociManifest, driver string) (ociDescriptor, error) {
+	for _, l := range m.Layers {
+		if l.Annotations[ociTitleAnnotation] == driver {
+			return l, nil
+		}
+	}
+	if len(m.Layers) == 1 {
+		return m.Layers[0], nil
+	}
+	return ociDescriptor{}, errors.Errorf("no layer holds %s", driver)
+}
+
+// extractDriver copies the file named driver out of the tar archive at archive to dst
+func extractDriver(archive string, gzipped bool, driver, dst string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrap(err, "gunzip layer")
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errors.Errorf("layer doesn't contain %s", driver)
+		}
+		if err != nil {
+			return errors.Wrap(err, "read layer")
+		}
+		if hdr.Typeflag != tar.TypeReg || path.Base(hdr.Name) != driver {
+			continue
+		}
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return errors.Wrap(err, "extract driver")
+		}
+		return out.Close()
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestParseOCIReference(t *testing.T) {
+	var tcs = []struct {
+		ref     string
+		want    ociReference
+		wantErr bool
+	}{
+		{ref: "registry.example.com/tools/kvm2:v1.3.0", want: ociReference{"registry.example.com", "tools/kvm2", "v1.3.0"}},
+		{ref: "oci://localhost:5000/kvm2", want: ociReference{"localhost:5000", "kvm2", "latest"}},
+		{ref: "localhost:5000/kvm2@sha256:abc", want: ociReference{"localhost:5000", "kvm2", "sha256:abc"}},
+		{ref: "kvm2", wantErr: true},
+		{ref: "localhost:5000/", wantErr: true},
+	}
+	for _, tc := range tcs {
+		got, err := parseOCIReference(tc.ref)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseOCIReference(%q) error = %v, wantErr %v", tc.ref, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseOCIReference(%q) = %+v, want %+v", tc.ref, got, tc.want)
+		}
+	}
+}
+
+// registryFixture is a minimal OCI registry serving a single artifact that requires an anonymous token
+type registryFixture struct {
+	manifest []byte
+	blob     []byte
+}
+
+func (f *registryFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		fmt.Fprint(w, `{"token": "anonymous"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer anonymous" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fixture",scope="repository:tools/kvm2:pull"`, r.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == "/v2/tools/kvm2/manifests/v1.3.0":
+		w.Header().Set("Content-Type", ociManifestMediaType)
+		w.Write(f.manifest)
+	case strings.HasPrefix(r.URL.Path, "/v2/tools/kvm2/blobs/"):
+		w.Write(f.blob)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newRegistryFixture serves blob as the only layer of tools/kvm2:v1.3.0, declaring digest for it
+func newRegistryFixture(t *testing.T, mediaType string, blob []byte, digest string) *httptest.Server {
+	t.Helper()
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	}
+	m, err := json.Marshal(ociManifest{Layers: []ociDescriptor{{MediaType: mediaType, Digest: digest, Size: int64(len(blob))}}})
+	if err != nil {
+		t.Fatalf("marshal manifest: %v", err)
+	}
+	return httptest.NewServer(&registryFixture{manifest: m, blob: blob})
+}
+
+// tarGz returns a gzipped tar holding a single file
+func tarGz(t *testing.T, name, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("tar header: %v", err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatalf("tar write: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar close: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadOCI(t *testing.T) {
+	const driver = "ocidriver"
+	defer allowSmallDrivers()()
+	var tcs = []struct {
+		desc      string
+		mediaType string
+		blob      []byte
+		digest    string
+		wantErr   bool
+	}{
+		{desc: "raw layer", mediaType: "application/octet-stream", blob: []byte(driver)},
+		{desc: "tar layer", mediaType: "application/vnd.oci.image.layer.v1.tar+gzip", blob: tarGz(t, "bin/"+kvmDriver, driver)},
+		{desc: "digest mismatch", mediaType: "application/octet-stream", blob: []byte(driver), digest: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("other"))), wantErr: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := newRegistryFixture(t, tc.mediaType, tc.blob, tc.digest)
+			defer srv.Close()
+			tmpdir := tests.MakeTempDir()
+			defer os.RemoveAll(tmpdir)
+
+			ref := strings.TrimPrefix(srv.URL, "http://") + "/tools/kvm2:v1.3.0"
+			err := download(kvmDriver, tmpdir, InstallOptions{Quiet: true, OCIRef: ref, OCIPlainHTTP: true})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("download() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			b, readErr := ioutil.ReadFile(filepath.Join(tmpdir, kvmDriver))
+			if tc.wantErr {
+				if readErr == nil {
+					t.Error("expected no driver after a failed pull")
+				}
+				return
+			}
+			if string(b) != driver {
+				t.Errorf("driver = %q, %v, want %q", b, readErr, driver)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"sort"
+	"strings"
+
+	"k8s.io/minikube/pkg/minikube/constants"
+)
+
+// anyArch matches every architecture in driverPlatforms
+const anyArch = "*"
+
+// driverPlatforms are the GOOS/GOARCH pairs each driver runs on, by driver name. Managed
+// drivers that aren't listed are taken to run anywhere.
+var driverPlatforms = map[string][]string{
+	constants.DriverVirtualbox:   {"linux/amd64", "darwin/amd64", "windows/amd64"},
+	constants.DriverParallels:    {"linux/amd64", "darwin/amd64"},
+	constants.DriverVmwareFusion: {"linux/amd64", "darwin/amd64", "windows/amd64"},
+	constants.DriverVmware:       {"linux/amd64", "darwin/amd64", "windows/amd64"},
+	constants.DriverKvm2:         {"linux/amd64"},
+	constants.DriverHyperkit:     {"darwin/amd64"},
+	constants.DriverHyperv:       {"windows/amd64"},
+	constants.DriverNone:         {"linux/" + anyArch},
+}
+
+// SupportedDrivers returns the names of the drivers that run on goos/goarch, sorted, e.g. to
+// suggest drivers in help text. It covers the built-in drivers and those minikube installs.
+func SupportedDrivers(goos, goarch string) []string {
+	names := map[string]bool{}
+	for name := range driverPlatforms {
+		names[name] = true
+	}
+	for _, bin := range allowedDrivers {
+		names[strings.TrimPrefix(bin, "docker-machine-driver-")] = true
+	}
+
+	var supported []string
+	for name := range names {
+		platforms, ok := driverPlatforms[name]
+		if !ok || runsOn(platforms, goos, goarch) {
+			supported = append(supported, name)
+		}
+	}
+	sort.Strings(supported)
+	return supported
+}
+
+// runsOn returns whether platforms includes goos/goarch
+func runsOn(platforms []string, goos, goarch string) bool {
+	for _, p := range platforms {
+		if p == goos+"/"+goarch || p == goos+"/"+anyArch {
+			return true
+		}
+	}
+	return false
+}
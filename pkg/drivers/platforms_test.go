@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSupportedDrivers(t *testing.T) {
+	var tcs = []struct {
+		goos, goarch string
+		want         []string
+	}{
+		{goos: "linux", goarch: "amd64", want: []string{"kvm2", "none", "parallels", "virtualbox", "vmware", "vmwarefusion"}},
+		{goos: "linux", goarch: "arm64", want: []string{"none"}},
+		{goos: "darwin", goarch: "amd64", want: []string{"hyperkit", "parallels", "virtualbox", "vmware", "vmwarefusion"}},
+		{goos: "darwin", goarch: "arm64", want: nil},
+		{goos: "windows", goarch: "amd64", want: []string{"hyperv", "virtualbox", "vmware", "vmwarefusion"}},
+		{goos: "plan9", goarch: "386", want: nil},
+	}
+	for _, tc := range tcs {
+		if got := SupportedDrivers(tc.goos, tc.goarch); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SupportedDrivers(%s, %s) = %v, want %v", tc.goos, tc.goarch, got, tc.want)
+		}
+	}
+
+	// a managed driver without platform constraints is offered everywhere
+	defer allowDriver("docker-machine-driver-stub")()
+	if got := SupportedDrivers("darwin", "arm64"); !reflect.DeepEqual(got, []string{"stub"}) {
+		t.Errorf("SupportedDrivers(darwin, arm64) with an unconstrained driver = %v, want [stub]", got)
+	}
+}
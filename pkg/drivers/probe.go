@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// probeEmulator is the emulator used to check that disk images boot
+const probeEmulator = "qemu-system-x86_64"
+
+// probeTimeout is how long ProbeDiskBoot waits for the guest to start SSH
+const probeTimeout = 3 * time.Minute
+
+// ErrNoEmulator is returned by ProbeDiskBoot when no emulator is installed
+var ErrNoEmulator = errors.New(probeEmulator + " not found")
+
+// ProbeDiskBoot boots the disk at diskPath, along with the boot2docker ISO beside it, in a throwaway emulator,
+// and reports whether the guest started an SSH server. The disk itself is never written to.
+// It is intended for use in tests, which should skip if ErrNoEmulator is returned.
+func ProbeDiskBoot(diskPath string) (bool, error) {
+	return probeDiskBoot(diskPath, probeTimeout)
+}
+
+func probeDiskBoot(diskPath string, timeout time.Duration) (bool, error) {
+	if _, err := exec.LookPath(probeEmulator); err != nil {
+		return false, ErrNoEmulator
+	}
+	isoPath := filepath.Join(filepath.Dir(diskPath), isoFilename)
+	for _, p := range []string{diskPath, isoPath} {
+		if _, err := os.Stat(p); err != nil {
+			return false, err
+		}
+	}
+	port, err := freePort()
+	if err != nil {
+		return false, errors.Wrap(err, "find free port")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, probeEmulator,
+		"-nographic", "-m", "1024", "-boot", "d",
+		"-cdrom", isoPath,
+		"-drive", fmt.Sprintf("file=%s,format=raw,snapshot=on", diskPath),
+		"-netdev", fmt.Sprintf("user,id=net0,hostfwd=tcp:127.0.0.1:%d-:22", port),
+		"-device", "virtio-net-pci,netdev=net0")
+	glog.Infof("Probing disk boot: %s", strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		return false, errors.Wrap(err, "start emulator")
+	}
+	defer func() {
+		cancel()
+		if err := cmd.Wait(); err != nil {
+			glog.Infof("emulator exited: %v", err)
+		}
+	}()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for ctx.Err() == nil {
+		if sshBanner(addr) {
+			return true, nil
+		}
+		time.Sleep(time.Second)
+	}
+	return false, nil
+}
+
+// freePort returns a local TCP port that is not currently in use
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// sshBanner returns whether an SSH server answers at addr.
+// Merely connecting is not enough, as user-mode networking accepts forwarded connections before the guest does.
+func sshBanner(addr string) bool {
+	c, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return false
+	}
+	defer c.Close()
+	if err := c.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		return false
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	return err == nil && strings.HasPrefix(line, "SSH-")
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestProbeDiskBootUnbootable(t *testing.T) {
+	if _, err := exec.LookPath(probeEmulator); err != nil {
+		t.Skipf("%s not available", probeEmulator)
+	}
+	d, cleanup := newTestDisk(t, 10)
+	defer cleanup()
+	// An ISO descriptor with nothing bootable behind it
+	writeISOFixture(t, d.ResolveStorePath(isoFilename))
+
+	ok, err := probeDiskBoot(GetDiskPath(d), 5*time.Second)
+	if err != nil {
+		t.Fatalf("probeDiskBoot() error = %v", err)
+	}
+	if ok {
+		t.Error("probeDiskBoot() = true for a disk that cannot boot")
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how an operation that may fail transiently is retried, so that
+// downloads, driver execs and other network operations back off the same way
+type RetryPolicy struct {
+	// MaxAttempts is how many times the operation is run in total, including the first.
+	// Less than 1 runs it once.
+	MaxAttempts int
+	// BaseDelay is how long to wait before the first retry. Each later retry waits twice as
+	// long as the one before. Zero retries immediately.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts. Zero leaves it uncapped.
+	MaxDelay time.Duration
+	// Jitter is the fraction, from 0 to 1, by which each wait is randomly shortened, so that
+	// many clients failing together don't retry in lockstep
+	Jitter float64
+	// OnRetry, if set, is called with the error and the wait before each retry, e.g. to log it
+	OnRetry func(err error, delay time.Duration)
+}
+
+// jitterRand returns a random number in [0, 1) to apply Jitter with
+var jitterRand = rand.Float64
+
+// attempts returns how many times the operation is run in total
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// delay returns how long to wait before retry n, counting from 1
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && d > 0; i++ {
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if j := p.Jitter; j > 0 {
+		if j > 1 {
+			j = 1
+		}
+		d -= time.Duration(float64(d) * j * jitterRand())
+	}
+	return d
+}
+
+// Do runs fn until it succeeds, it returns an error isRetryable rejects, the attempts run out
+// or ctx is done, waiting between attempts as the policy says. It returns the last error from
+// fn, or ctx's error if ctx ended the retries.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error, isRetryable func(error) bool) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) || attempt >= p.attempts() {
+			return err
+		}
+		d := p.delay(attempt)
+		if p.OnRetry != nil {
+			p.OnRetry(err, d)
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	var tcs = []struct {
+		desc   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{
+			desc:   "immediate",
+			policy: RetryPolicy{},
+			want:   []time.Duration{0, 0, 0},
+		},
+		{
+			desc:   "doubling",
+			policy: RetryPolicy{BaseDelay: time.Second},
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			desc:   "capped",
+			policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: 3 * time.Second},
+			want:   []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			desc:   "constant",
+			policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second},
+			want:   []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			desc:   "jitter",
+			policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: 4 * time.Second, Jitter: 0.5},
+			want:   []time.Duration{750 * time.Millisecond, 1500 * time.Millisecond, 3 * time.Second, 3 * time.Second},
+		},
+	}
+	defer func(r func() float64) { jitterRand = r }(jitterRand)
+	jitterRand = func() float64 { return 0.5 }
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []time.Duration
+			for n := 1; n <= len(tc.want); n++ {
+				got = append(got, tc.policy.delay(n))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("delays = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	errTransient := errors.New("transient")
+	errFatal := errors.New("fatal")
+	isTransient := func(err error) bool { return err == errTransient }
+
+	var tcs = []struct {
+		desc        string
+		maxAttempts int
+		errs        []error
+		wantCalls   int
+		wantRetries int
+		wantErr     error
+	}{
+		{desc: "success", maxAttempts: 3, errs: []error{nil}, wantCalls: 1, wantErr: nil},
+		{desc: "recovers", maxAttempts: 3, errs: []error{errTransient, errTransient, nil}, wantCalls: 3, wantRetries: 2, wantErr: nil},
+		{desc: "exhausted", maxAttempts: 3, errs: []error{errTransient, errTransient, errTransient, nil}, wantCalls: 3, wantRetries: 2, wantErr: errTransient},
+		{desc: "not retryable", maxAttempts: 3, errs: []error{errTransient, errFatal, nil}, wantCalls: 2, wantRetries: 1, wantErr: errFatal},
+		{desc: "no attempts set", maxAttempts: 0, errs: []error{errTransient, nil}, wantCalls: 1, wantErr: errTransient},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			calls, retries := 0, 0
+			p := RetryPolicy{
+				MaxAttempts: tc.maxAttempts,
+				OnRetry:     func(error, time.Duration) { retries++ },
+			}
+			err := p.Do(context.Background(), func() error {
+				calls++
+				return tc.errs[calls-1]
+			}, isTransient)
+			if err != tc.wantErr {
+				t.Errorf("Do() error = %v, want %v", err, tc.wantErr)
+			}
+			if calls != tc.wantCalls || retries != tc.wantRetries {
+				t.Errorf("Do() ran %d times with %d retries, want %d times with %d retries", calls, retries, tc.wantCalls, tc.wantRetries)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}
+	err := p.Do(ctx, func() error {
+		calls++
+		cancel()
+		return errors.New("transient")
+	}, func(error) bool { return true })
+	if err != context.Canceled {
+		t.Errorf("Do() error = %v, want %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("Do() ran %d times after being canceled, want 1", calls)
+	}
+}
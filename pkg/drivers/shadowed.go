@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DetectShadowedDrivers returns the drivers minikube installs or runs that are found more than
+// once in $PATH, with every path each is found at in PATH order. The first is the one that
+// runs, which may not be the one minikube manages, so the CLI can warn about the others.
+// PATH entries that can't be read are skipped.
+func DetectShadowedDrivers() (map[string][]string, error) {
+	shadowed := map[string][]string{}
+	seen := map[string]bool{}
+	for _, driver := range allowedDrivers {
+		if seen[driver] {
+			continue
+		}
+		seen[driver] = true
+		var found []string
+		var infos []os.FileInfo
+		for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+			if dir == "" {
+				dir = "."
+			}
+			p := filepath.Join(dir, driver)
+			if runtime.GOOS == "windows" {
+				p += ".exe"
+			}
+			fi, err := os.Stat(p)
+			if err != nil || !isExecutable(fi) {
+				continue
+			}
+			// the same directory may appear in PATH twice, or by way of a symlink
+			dup := false
+			for _, other := range infos {
+				if os.SameFile(fi, other) {
+					dup = true
+					break
+				}
+			}
+			if dup {
+				continue
+			}
+			found = append(found, p)
+			infos = append(infos, fi)
+		}
+		if len(found) > 1 {
+			shadowed[driver] = found
+		}
+	}
+	return shadowed, nil
+}
+
+// isExecutable returns whether fi is a file that can be run, as exec.LookPath would
+func isExecutable(fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return false
+	}
+	return runtime.GOOS == "windows" || fi.Mode()&0111 != 0
+}
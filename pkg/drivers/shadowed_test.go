@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+func TestDetectShadowedDrivers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stub drivers are shell scripts")
+	}
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+	first, second, other := filepath.Join(tmpdir, "first"), filepath.Join(tmpdir, "second"), filepath.Join(tmpdir, "other")
+	for _, dir := range []string{first, second, other} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	writeStubDriver(t, first, kvmDriver, "echo version: v1.3.0")
+	writeStubDriver(t, second, kvmDriver, "echo version: v1.2.0")
+	// only found once, so not shadowed
+	writeStubDriver(t, other, "docker-machine-driver-hyperkit", "echo version: v1.3.0")
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	// the first directory twice is still the same binary
+	os.Setenv("PATH", strings.Join([]string{first, other, first, second}, string(os.PathListSeparator)))
+
+	got, err := DetectShadowedDrivers()
+	if err != nil {
+		t.Fatalf("DetectShadowedDrivers() error = %v", err)
+	}
+	want := map[string][]string{kvmDriver: {filepath.Join(first, kvmDriver), filepath.Join(second, kvmDriver)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectShadowedDrivers() = %v, want %v", got, want)
+	}
+}
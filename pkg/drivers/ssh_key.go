@@ -0,0 +1,368 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/ssh"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHKeyBits is the size of generated RSA machine keys
+const DefaultSSHKeyBits = 2048
+
+// The permissions of the machine key pair. ssh refuses private keys that others can read.
+const (
+	privateKeyMode os.FileMode = 0600
+	publicKeyMode  os.FileMode = 0644
+)
+
+// allowedSSHKeyBits are the RSA key sizes that may be generated
+var allowedSSHKeyBits = []int{2048, 3072, 4096}
+
+// validateSSHKeyBits returns an error unless bits is an allowed RSA key size
+func validateSSHKeyBits(bits int) error {
+	for _, b := range allowedSSHKeyBits {
+		if bits == b {
+			return nil
+		}
+	}
+	return errors.Errorf("unsupported RSA key size %d, must be one of %v", bits, allowedSSHKeyBits)
+}
+
+// validateAuthorizedKeys returns an error unless each key is a single well-formed SSH public key
+func validateAuthorizedKeys(keys []string) error {
+	for i, k := range keys {
+		k = strings.TrimSpace(k)
+		if strings.ContainsAny(k, "\r\n") {
+			return errors.Errorf("authorized key %d must be a single line", i)
+		}
+		if _, _, _, _, err := gossh.ParseAuthorizedKey([]byte(k)); err != nil {
+			return errors.Wrapf(err, "authorized key %d is not a valid SSH public key", i)
+		}
+	}
+	return nil
+}
+
+// validateSSHKeyComment returns an error if comment would not fit on the public key's line
+func validateSSHKeyComment(comment string) error {
+	if strings.ContainsAny(comment, "\r\n") {
+		return errors.Errorf("ssh key comment %q must be a single line", comment)
+	}
+	return nil
+}
+
+// keyComment returns the comment of the public key at path, or "" if it has none or can't be read
+func keyComment(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	_, comment, _, _, err := gossh.ParseAuthorizedKey(b)
+	if err != nil {
+		return ""
+	}
+	return comment
+}
+
+// keyBits returns the size of the RSA private key at path
+func keyBits(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return 0, errors.New("private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse private key")
+	}
+	return key.N.BitLen(), nil
+}
+
+// rotationKeyBits returns the size to generate a replacement for the key at path: the size of the
+// current key if it is still an allowed one, otherwise DefaultSSHKeyBits.
+func rotationKeyBits(path string) int {
+	bits, err := keyBits(path)
+	if err != nil {
+		glog.Warningf("unable to read the size of %s, using %d bits: %v", path, DefaultSSHKeyBits, err)
+		return DefaultSSHKeyBits
+	}
+	if err := validateSSHKeyBits(bits); err != nil {
+		glog.Warningf("replacing %s with a %d bit key: %v", path, DefaultSSHKeyBits, err)
+		return DefaultSSHKeyBits
+	}
+	return bits
+}
+
+// generateSSHKey creates an RSA key pair of the given size at path and path.pub, unless path already exists.
+// comment, if set, identifies the public key, e.g. in ssh-agent listings.
+func generateSSHKey(path string, bits int, comment string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "stat key")
+	}
+	return writeKeyPair(path, bits, comment)
+}
+
+// writeKeyPair writes a new RSA key pair of the given size to path and path.pub, commenting the public key
+func writeKeyPair(path string, bits int, comment string) error {
+	if err := validateSSHKeyBits(bits); err != nil {
+		return err
+	}
+	if err := validateSSHKeyComment(comment); err != nil {
+		return err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return errors.Wrap(err, "generate rsa key")
+	}
+	pub, err := gossh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "public key")
+	}
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(path, priv, privateKeyMode); err != nil {
+		return errors.Wrap(err, "write private key")
+	}
+	authorized := gossh.MarshalAuthorizedKey(pub)
+	if comment != "" {
+		authorized = append(authorized[:len(authorized)-1], []byte(" "+comment+"\n")...)
+	}
+	if err := ioutil.WriteFile(path+".pub", authorized, publicKeyMode); err != nil {
+		return errors.Wrap(err, "write public key")
+	}
+	return nil
+}
+
+// EnsureSSHKeyPermissions repairs the permissions of the machine key pair, e.g. a world-readable
+// private key left behind by an upgrade, which ssh would otherwise reject.
+func EnsureSSHKeyPermissions(d *drivers.BaseDriver) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	keyPath := d.GetSSHKeyPath()
+	for _, k := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{keyPath, privateKeyMode},
+		{keyPath + ".pub", publicKeyMode},
+	} {
+		fi, err := os.Stat(k.path)
+		if err != nil {
+			return errors.Wrapf(err, "stat %s", k.path)
+		}
+		if fi.Mode().Perm() == k.mode {
+			continue
+		}
+		glog.Warningf("%s has mode %v, fixing to %v", k.path, fi.Mode().Perm(), k.mode)
+		if err := os.Chmod(k.path, k.mode); err != nil {
+			return errors.Wrapf(err, "chmod %s", k.path)
+		}
+	}
+	return nil
+}
+
+// sshRunner runs commands on a machine over SSH
+type sshRunner interface {
+	Output(command string) (string, error)
+}
+
+// newSSHRunner connects to the machine using the private key at keyPath, replaceable for testing
+var newSSHRunner = func(d *drivers.BaseDriver, keyPath string) (sshRunner, error) {
+	ip, err := d.GetIP()
+	if err != nil {
+		return nil, errors.Wrap(err, "get ip")
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return nil, errors.Wrap(err, "get ssh port")
+	}
+	return ssh.NewClient(d.GetSSHUsername(), ip, port, &ssh.Auth{Keys: []string{keyPath}})
+}
+
+// authorizedKeysFiles are the guest files that boot2docker reads authorized keys from
+var authorizedKeysFiles = []string{"~/.ssh/authorized_keys", "~/.ssh/authorized_keys2"}
+
+// RotateSSHKey replaces the machine's SSH key with a newly generated one of the same size, authorizing it
+// on the guest using the current key. Other authorized keys, e.g. ExtraAuthorizedKeys, are kept.
+// If any step fails, both the guest and the local key are left as they were.
+func RotateSSHKey(d *drivers.BaseDriver) error {
+	keyPath := d.GetSSHKeyPath()
+	newKeyPath := keyPath + ".new"
+	defer os.Remove(newKeyPath)
+	defer os.Remove(newKeyPath + ".pub")
+
+	glog.Infof("Generating replacement ssh key: %s...", newKeyPath)
+	// keep identifying the machine the same way
+	if err := writeKeyPair(newKeyPath, rotationKeyBits(keyPath), keyComment(publicSSHKeyPath(d))); err != nil {
+		return errors.Wrap(err, "generate ssh key")
+	}
+	pub, err := ioutil.ReadFile(newKeyPath + ".pub")
+	if err != nil {
+		return errors.Wrap(err, "read public key")
+	}
+	pubKey := strings.TrimSpace(string(pub))
+	oldPub, err := ioutil.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return errors.Wrap(err, "read current public key")
+	}
+	oldPubKey := strings.TrimSpace(string(oldPub))
+
+	old, err := newSSHRunner(d, keyPath)
+	if err != nil {
+		return errors.Wrap(err, "connect with current key")
+	}
+	// Add the new key alongside the old one, so that a failure from here on cannot lock us out
+	cmd, err := appendKeyCmd(pubKey)
+	if err != nil {
+		return err
+	}
+	if _, err := old.Output(cmd); err != nil {
+		return errors.Wrap(err, "authorize new key")
+	}
+	c, err := deauthorizeWith(d, newKeyPath, oldPubKey)
+	if err != nil {
+		if rerr := runKeyCmd(old, removeKeyCmd, pubKey); rerr != nil {
+			glog.Errorf("unable to deauthorize new key after failure: %v", rerr)
+		}
+		return err
+	}
+
+	if err := swapKeyFiles(keyPath, newKeyPath); err != nil {
+		// The guest now only accepts the new key, so re-authorize the one we are keeping
+		if rerr := runKeyCmd(c, appendKeyCmd, oldPubKey); rerr != nil {
+			glog.Errorf("unable to re-authorize current key after failure: %v", rerr)
+		}
+		return errors.Wrap(err, "install new key")
+	}
+	return nil
+}
+
+// deauthorizeWith connects using the key at keyPath, then removes oldPubKey from the guest
+func deauthorizeWith(d *drivers.BaseDriver, keyPath, oldPubKey string) (sshRunner, error) {
+	c, err := newSSHRunner(d, keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect with new key")
+	}
+	if err := runKeyCmd(c, removeKeyCmd, oldPubKey); err != nil {
+		return nil, errors.Wrap(err, "deauthorize current key")
+	}
+	return c, nil
+}
+
+// runKeyCmd builds the command for pubKey with build, and runs it with c
+func runKeyCmd(c sshRunner, build func(string) (string, error), pubKey string) error {
+	cmd, err := build(pubKey)
+	if err != nil {
+		return err
+	}
+	_, err = c.Output(cmd)
+	return err
+}
+
+// shellQuote quotes s as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// keyBlob returns the base64 key field of pubKey, which identifies it whatever its options or comment
+func keyBlob(pubKey string) (string, error) {
+	key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(pubKey))
+	if err != nil {
+		return "", errors.Wrap(err, "parse public key")
+	}
+	return base64.StdEncoding.EncodeToString(key.Marshal()), nil
+}
+
+// appendKeyCmd returns a shell command that adds pubKey to the guest's authorized keys
+func appendKeyCmd(pubKey string) (string, error) {
+	if err := validateAuthorizedKeys([]string{pubKey}); err != nil {
+		return "", err
+	}
+	cmds := []string{"mkdir -p ~/.ssh"}
+	for _, f := range authorizedKeysFiles {
+		cmds = append(cmds, fmt.Sprintf("printf '%%s\\n' %s >> %s", shellQuote(pubKey), f))
+	}
+	return strings.Join(cmds, " && "), nil
+}
+
+// removeKeyCmd returns a shell command that removes every line authorizing pubKey from the guest's
+// authorized keys, leaving any other keys in place
+func removeKeyCmd(pubKey string) (string, error) {
+	blob, err := keyBlob(pubKey)
+	if err != nil {
+		return "", err
+	}
+	var cmds []string
+	for _, f := range authorizedKeysFiles {
+		cmds = append(cmds, fmt.Sprintf("{ grep -vF -- %s %s > %s.tmp; mv %s.tmp %s; }", shellQuote(blob), f, f, f, f))
+	}
+	return strings.Join(cmds, " && "), nil
+}
+
+// swapKeyFiles replaces the key pair at keyPath with the one at newKeyPath, restoring the original on failure
+func swapKeyFiles(keyPath, newKeyPath string) error {
+	pairs := [][2]string{{keyPath, newKeyPath}, {keyPath + ".pub", newKeyPath + ".pub"}}
+	for _, p := range pairs {
+		if err := os.Rename(p[0], p[0]+".old"); err != nil {
+			restoreKeyFiles(keyPath)
+			return err
+		}
+	}
+	for _, p := range pairs {
+		if err := os.Rename(p[1], p[0]); err != nil {
+			restoreKeyFiles(keyPath)
+			return err
+		}
+	}
+	for _, p := range pairs {
+		if err := os.Remove(p[0] + ".old"); err != nil {
+			glog.Warningf("unable to remove old key %s.old: %v", p[0], err)
+		}
+	}
+	return nil
+}
+
+// restoreKeyFiles moves back any key files that swapKeyFiles set aside
+func restoreKeyFiles(keyPath string) {
+	for _, p := range []string{keyPath, keyPath + ".pub"} {
+		if _, err := os.Stat(p + ".old"); err != nil {
+			continue
+		}
+		if err := os.Rename(p+".old", p); err != nil {
+			glog.Errorf("unable to restore %s: %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/ssh"
+	"k8s.io/minikube/pkg/minikube/tests"
+)
+
+// fakeGuest is an SSH target whose home directory is a local directory, so that the key
+// management commands are run by a real shell
+type fakeGuest struct {
+	home string
+	// failOn makes any command containing this string fail
+	failOn string
+}
+
+// newFakeGuest returns a guest that authorizes keys, and a func to remove it
+func newFakeGuest(t *testing.T, keys ...string) (*fakeGuest, func()) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the key management commands need a POSIX shell")
+	}
+	home := tests.MakeTempDir()
+	g := &fakeGuest{home: home}
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	content := authorizedKeysContent([]byte(keys[0]+"\n"), keys[1:])
+	for _, f := range []string{"authorized_keys", "authorized_keys2"} {
+		if err := ioutil.WriteFile(filepath.Join(home, ".ssh", f), content, 0644); err != nil {
+			t.Fatalf("writefile: %v", err)
+		}
+	}
+	return g, func() { os.RemoveAll(home) }
+}
+
+// authorized returns the lines of the guest's authorized_keys, failing unless authorized_keys2 matches
+func (g *fakeGuest) authorized(t *testing.T) []string {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(g.home, ".ssh", "authorized_keys"))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	b2, err := ioutil.ReadFile(filepath.Join(g.home, ".ssh", "authorized_keys2"))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if string(b) != string(b2) {
+		t.Errorf("authorized_keys2 = %q, want it to match authorized_keys %q", b2, b)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+// fakeRunner runs the key management commands against a fakeGuest
+type fakeRunner struct {
+	g *fakeGuest
+}
+
+func (r *fakeRunner) Output(cmd string) (string, error) {
+	if r.g.failOn != "" && strings.Contains(cmd, r.g.failOn) {
+		return "", fmt.Errorf("command failed: %s", cmd)
+	}
+	c := exec.Command("sh", "-c", cmd)
+	c.Env = append(os.Environ(), "HOME="+r.g.home)
+	out, err := c.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v: %s", cmd, err, out)
+	}
+	return string(out), nil
+}
+
+// useFakeGuest routes newSSHRunner to g, refusing keys that g does not authorize
+func useFakeGuest(g *fakeGuest) func() {
+	orig := newSSHRunner
+	newSSHRunner = func(d *drivers.BaseDriver, keyPath string) (sshRunner, error) {
+		pub, err := ioutil.ReadFile(keyPath + ".pub")
+		if err != nil {
+			return nil, err
+		}
+		blob, err := keyBlob(string(pub))
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadFile(filepath.Join(g.home, ".ssh", "authorized_keys"))
+		if err != nil || !strings.Contains(string(b), blob) {
+			return nil, fmt.Errorf("permission denied (publickey)")
+		}
+		return &fakeRunner{g: g}, nil
+	}
+	return func() { newSSHRunner = orig }
+}
+
+// newKeyedMachine returns a machine with a generated ssh key, and its public key
+func newKeyedMachine(t *testing.T) (*drivers.BaseDriver, string, func()) {
+	t.Helper()
+	tmpdir := tests.MakeTempDir()
+	d := &drivers.BaseDriver{MachineName: "minikube", StorePath: tmpdir}
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
+		t.Fatalf("GenerateSSHKey() error = %v", err)
+	}
+	pub, err := ioutil.ReadFile(publicSSHKeyPath(d))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	return d, strings.TrimSpace(string(pub)), func() { os.RemoveAll(tmpdir) }
+}
+
+// newKeyPair writes a key pair at path and returns its public key
+func newKeyPair(t *testing.T, path string, bits int, comment string) string {
+	t.Helper()
+	if err := writeKeyPair(path, bits, comment); err != nil {
+		t.Fatalf("writeKeyPair() error = %v", err)
+	}
+	pub, err := ioutil.ReadFile(path + ".pub")
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	return strings.TrimSpace(string(pub))
+}
+
+func TestRotateSSHKey(t *testing.T) {
+	d, oldPub, cleanup := newKeyedMachine(t)
+	defer cleanup()
+	// an operator key, as added by ExtraAuthorizedKeys, must survive the rotation
+	extra := newKeyPair(t, d.ResolveStorePath("operator"), DefaultSSHKeyBits, "operator@example.com")
+	g, gcleanup := newFakeGuest(t, oldPub, extra)
+	defer gcleanup()
+	defer useFakeGuest(g)()
+
+	if err := RotateSSHKey(d); err != nil {
+		t.Fatalf("RotateSSHKey() error = %v", err)
+	}
+	pub, err := ioutil.ReadFile(publicSSHKeyPath(d))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	newPub := strings.TrimSpace(string(pub))
+	if newPub == oldPub {
+		t.Fatal("public key was not rotated")
+	}
+	if got, want := g.authorized(t), []string{extra, newPub}; !reflect.DeepEqual(got, want) {
+		t.Errorf("guest authorized keys = %q, want %q", got, want)
+	}
+	for _, p := range []string{d.GetSSHKeyPath() + ".new", d.GetSSHKeyPath() + ".old"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, stat error = %v", p, err)
+		}
+	}
+}
+
+func TestRotateSSHKeyKeepsSizeAndComment(t *testing.T) {
+	d, _, cleanup := newKeyedMachine(t)
+	defer cleanup()
+	// the comment is passed to the guest's shell, so it must be quoted
+	const comment = `o'brien "$(touch pwned)" ; touch pwned`
+	keyPath := d.GetSSHKeyPath()
+	os.Remove(keyPath)
+	os.Remove(keyPath + ".pub")
+	oldPub := newKeyPair(t, keyPath, 3072, comment)
+	g, gcleanup := newFakeGuest(t, oldPub)
+	defer gcleanup()
+	defer useFakeGuest(g)()
+
+	if err := RotateSSHKey(d); err != nil {
+		t.Fatalf("RotateSSHKey() error = %v", err)
+	}
+	if bits, err := keyBits(keyPath); err != nil || bits != 3072 {
+		t.Errorf("keyBits() = %d, %v, want 3072 bits", bits, err)
+	}
+	if got := keyComment(keyPath + ".pub"); got != comment {
+		t.Errorf("keyComment() = %q, want %q", got, comment)
+	}
+	pub, err := ioutil.ReadFile(keyPath + ".pub")
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if got, want := g.authorized(t), []string{strings.TrimSpace(string(pub))}; !reflect.DeepEqual(got, want) {
+		t.Errorf("guest authorized keys = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(g.home, "pwned")); !os.IsNotExist(err) {
+		t.Errorf("the key comment was run by the guest's shell, stat error = %v", err)
+	}
+}
+
+func TestRotateSSHKeyRollback(t *testing.T) {
+	d, oldPub, cleanup := newKeyedMachine(t)
+	defer cleanup()
+	g, gcleanup := newFakeGuest(t, oldPub)
+	defer gcleanup()
+	blob, err := keyBlob(oldPub)
+	if err != nil {
+		t.Fatalf("keyBlob() error = %v", err)
+	}
+	// fail deauthorizing the current key, after the new one was authorized
+	g.failOn = "grep -vF -- '" + blob
+	defer useFakeGuest(g)()
+
+	if err := RotateSSHKey(d); err == nil {
+		t.Fatal("RotateSSHKey() expected an error")
+	}
+	pub, err := ioutil.ReadFile(publicSSHKeyPath(d))
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if strings.TrimSpace(string(pub)) != oldPub {
+		t.Error("local key changed despite the failed rotation")
+	}
+	if got, want := g.authorized(t), []string{oldPub}; !reflect.DeepEqual(got, want) {
+		t.Errorf("guest authorized keys = %q, want %q", got, want)
+	}
+}
+
+func TestGenerateSSHKeyBits(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	keyPath := filepath.Join(tmpdir, "id_rsa")
+	if err := generateSSHKey(keyPath, 4096, ""); err != nil {
+		t.Fatalf("generateSSHKey() error = %v", err)
+	}
+	b, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatal("private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParsePKCS1PrivateKey() error = %v", err)
+	}
+	if got := key.N.BitLen(); got != 4096 {
+		t.Errorf("modulus is %d bits, want 4096", got)
+	}
+
+	if err := generateSSHKey(filepath.Join(tmpdir, "id_small"), 1024, ""); err == nil {
+		t.Error("generateSSHKey() expected an error for a 1024 bit key")
+	}
+}
+
+func TestGenerateSSHKeyComment(t *testing.T) {
+	tmpdir := tests.MakeTempDir()
+	defer os.RemoveAll(tmpdir)
+
+	const comment = "minikube-profile@host"
+	keyPath := filepath.Join(tmpdir, "id_rsa")
+	if err := generateSSHKey(keyPath, DefaultSSHKeyBits, comment); err != nil {
+		t.Fatalf("generateSSHKey() error = %v", err)
+	}
+	b, err := ioutil.ReadFile(keyPath + ".pub")
+	if err != nil {
+		t.Fatalf("readfile: %v", err)
+	}
+	if !strings.HasSuffix(string(b), " "+comment+"\n") {
+		t.Errorf("public key %q doesn't end with the comment %q", b, comment)
+	}
+	if got := keyComment(keyPath + ".pub"); got != comment {
+		t.Errorf("keyComment() = %q, want %q", got, comment)
+	}
+
+	if err := generateSSHKey(filepath.Join(tmpdir, "id_multiline"), DefaultSSHKeyBits, "one\ntwo"); err == nil {
+		t.Error("generateSSHKey() expected an error for a multi-line comment")
+	}
+}
+
+func TestEnsureSSHKeyPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on windows")
+	}
+	d, _, cleanup := newKeyedMachine(t)
+	defer cleanup()
+	keyPath := d.GetSSHKeyPath()
+	if err := os.Chmod(keyPath, 0644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := os.Chmod(keyPath+".pub", 0600); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+
+	if err := EnsureSSHKeyPermissions(d); err != nil {
+		t.Fatalf("EnsureSSHKeyPermissions() error = %v", err)
+	}
+	for path, want := range map[string]os.FileMode{keyPath: 0600, keyPath + ".pub": 0644} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		if got := fi.Mode().Perm(); got != want {
+			t.Errorf("%s mode = %v, want %v", path, got, want)
+		}
+	}
+}
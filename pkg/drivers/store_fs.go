@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import "fmt"
+
+// Warning describes a problem that doesn't stop minikube, but that the user should know about.
+// The zero Warning means there is nothing to warn about.
+type Warning struct {
+	// Filesystem is the type of the filesystem the problem is with, e.g. vfat
+	Filesystem string
+	// Message explains the problem
+	Message string
+}
+
+// problemFilesystems are the filesystem types that machine disks are known to misbehave on,
+// by their linux and darwin names, with the reason why
+var problemFilesystems = map[string]string{
+	"vfat":   "which can't hold sparse files, nor files over 4GB",
+	"msdos":  "which can't hold sparse files, nor files over 4GB",
+	"exfat":  "which can't hold sparse files, so disks take up their full size",
+	"nfs":    "a network filesystem, where disks are slow and file locking is unreliable",
+	"smbfs":  "a network filesystem, where disks are slow and file locking is unreliable",
+	"cifs":   "a network filesystem, where disks are slow and file locking is unreliable",
+	"smb2":   "a network filesystem, where disks are slow and file locking is unreliable",
+	"webdav": "a network filesystem, where disks are slow and file locking is unreliable",
+	"afpfs":  "a network filesystem, where disks are slow and file locking is unreliable",
+}
+
+// CheckStoreFilesystem returns a Warning if storePath is on a filesystem that machine disks are
+// known to misbehave on, such as FAT or a network share. Filesystems that can't be identified
+// are not warned about.
+func CheckStoreFilesystem(storePath string) (Warning, error) {
+	fs, err := storeFilesystem(storePath)
+	if err != nil {
+		return Warning{}, err
+	}
+	reason, ok := problemFilesystems[fs]
+	if !ok {
+		return Warning{}, nil
+	}
+	return Warning{Filesystem: fs, Message: fmt.Sprintf("%s is on %s, %s", storePath, fs, reason)}, nil
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// storeFilesystem returns the type of the filesystem holding path, e.g. apfs
+func storeFilesystem(path string) (string, error) {
+	var st syscall.Statfs_t
+	if err := statfs(path, &st); err != nil {
+		return "", errors.Wrapf(err, "statfs %s", path)
+	}
+	var name []byte
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return string(name), nil
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// linuxFilesystems names the statfs magic numbers of the filesystems minikube knows about
+var linuxFilesystems = map[uint32]string{
+	0xEF53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683E: "btrfs",
+	0x01021994: "tmpfs",
+	0x4d44:     "vfat",
+	0x2011BAB0: "exfat",
+	0x6969:     "nfs",
+	0x517B:     "smbfs",
+	0xFF534D42: "cifs",
+	0xFE534D42: "smb2",
+}
+
+// storeFilesystem returns the type of the filesystem holding path, or "" if it is not known
+func storeFilesystem(path string) (string, error) {
+	var st syscall.Statfs_t
+	if err := statfs(path, &st); err != nil {
+		return "", errors.Wrapf(err, "statfs %s", path)
+	}
+	// Type is a different width on each architecture, but magic numbers fit in 32 bits
+	return linuxFilesystems[uint32(st.Type)], nil
+}
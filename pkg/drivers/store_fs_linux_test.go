@@ -0,0 +1,60 @@
+// +build linux,amd64 linux,arm64
+
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCheckStoreFilesystem(t *testing.T) {
+	orig := statfs
+	defer func() { statfs = orig }()
+
+	var tcs = []struct {
+		desc    string
+		magic   uint32
+		wantFS  string
+		wantMsg string
+	}{
+		{desc: "fat", magic: 0x4d44, wantFS: "vfat", wantMsg: "4GB"},
+		{desc: "nfs", magic: 0x6969, wantFS: "nfs", wantMsg: "network"},
+		{desc: "ext4", magic: 0xEF53},
+		{desc: "unknown", magic: 0x1234},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			statfs = func(path string, st *syscall.Statfs_t) error {
+				st.Type = int64(tc.magic)
+				return nil
+			}
+			w, err := CheckStoreFilesystem("/home/user/.minikube")
+			if err != nil {
+				t.Fatalf("CheckStoreFilesystem() error = %v", err)
+			}
+			if w.Filesystem != tc.wantFS || !strings.Contains(w.Message, tc.wantMsg) {
+				t.Errorf("CheckStoreFilesystem() = %+v, want a %q warning mentioning %q", w, tc.wantFS, tc.wantMsg)
+			}
+			if tc.wantFS == "" && w != (Warning{}) {
+				t.Errorf("CheckStoreFilesystem() = %+v, want no warning", w)
+			}
+		})
+	}
+}
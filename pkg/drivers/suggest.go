@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/minikube/pkg/minikube/constants"
+)
+
+// The host facts SuggestDriver relies on, replaceable for testing
+var (
+	hostOS = runtime.GOOS
+	// hasKVM reports whether the kvm kernel modules are loaded
+	hasKVM = func() bool {
+		_, err := os.Stat("/dev/kvm")
+		return err == nil
+	}
+	// hasHyperkit reports whether the hyperkit binary, e.g. from Docker Desktop, is installed
+	hasHyperkit = func() bool {
+		_, err := exec.LookPath("hyperkit")
+		return err == nil
+	}
+	// hasDocker reports whether a docker daemon socket is available
+	hasDocker = func() bool {
+		_, err := os.Stat("/var/run/docker.sock")
+		return err == nil
+	}
+	// hasVirtualBox reports whether VirtualBox is installed
+	hasVirtualBox = func() bool {
+		_, err := exec.LookPath("VBoxManage")
+		return err == nil
+	}
+)
+
+// SuggestDriver recommends the VM driver that best suits this host, and why
+func SuggestDriver() (string, string) {
+	switch hostOS {
+	case "linux":
+		if hasKVM() {
+			return constants.DriverKvm2, "KVM is available"
+		}
+		if hasDocker() {
+			return constants.DriverNone, "KVM is not available, but docker is, so Kubernetes can run directly on the host"
+		}
+	case "darwin":
+		if hasHyperkit() {
+			return constants.DriverHyperkit, "hyperkit is installed"
+		}
+	}
+	return constants.DefaultVMDriver, "no native hypervisor was found, " + constants.DefaultVMDriver + " runs on every platform"
+}
+
+// hypervisor is what a driver needs on the host to run VMs
+type hypervisor struct {
+	// name describes the hypervisor in messages
+	name string
+	// os is the only host OS the driver runs on, if it is limited to one
+	os string
+	// present reports whether the hypervisor is installed
+	present func() bool
+	// hint tells the user how to install the hypervisor
+	hint string
+}
+
+// hypervisors are the requirements of the drivers CheckHypervisor knows how to check
+var hypervisors = map[string]hypervisor{
+	constants.DriverKvm2: {
+		name:    "KVM",
+		os:      "linux",
+		present: func() bool { return hasKVM() },
+		hint:    "install libvirt and QEMU/KVM, load the kvm module for your CPU, and make sure /dev/kvm exists: " + constants.DriverDocumentation + "kvm2/",
+	},
+	constants.DriverHyperkit: {
+		name:    "hyperkit",
+		os:      "darwin",
+		present: func() bool { return hasHyperkit() },
+		hint:    "install Docker Desktop, or hyperkit with 'brew install hyperkit': " + constants.DriverDocumentation + "hyperkit/",
+	},
+	constants.DriverVirtualbox: {
+		name:    "VirtualBox",
+		present: func() bool { return hasVirtualBox() },
+		hint:    "install VirtualBox from https://www.virtualbox.org/wiki/Downloads and make sure VBoxManage is on your PATH",
+	},
+	constants.DriverNone: {
+		name:    "docker",
+		os:      "linux",
+		present: func() bool { return hasDocker() },
+		hint:    "install docker and start its daemon: " + constants.DriverDocumentation + "none/",
+	},
+}
+
+// CheckHypervisor returns an error saying how to install the hypervisor driver needs, if it is
+// missing, so that the user isn't left with a confusing error when the VM is created. driver
+// may be a driver name, e.g. kvm2, or the name of its binary. Drivers it doesn't know how to
+// check are assumed to be fine.
+func CheckHypervisor(driver string) error {
+	name := strings.TrimPrefix(driver, "docker-machine-driver-")
+	h, ok := hypervisors[name]
+	if !ok {
+		return nil
+	}
+	if h.os != "" && h.os != hostOS {
+		suggested, _ := SuggestDriver()
+		return errors.Errorf("the %s driver only runs on %s, not %s. To fix this, use --vm-driver=%s instead", name, h.os, hostOS, suggested)
+	}
+	if !h.present() {
+		return errors.Errorf("the %s driver needs %s, which was not found. To fix this, %s", name, h.name, h.hint)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/constants"
+)
+
+func TestSuggestDriver(t *testing.T) {
+	origOS, origKVM, origHyperkit, origDocker := hostOS, hasKVM, hasHyperkit, hasDocker
+	defer func() {
+		hostOS, hasKVM, hasHyperkit, hasDocker = origOS, origKVM, origHyperkit, origDocker
+	}()
+
+	var tcs = []struct {
+		os       string
+		kvm      bool
+		hyperkit bool
+		docker   bool
+		want     string
+	}{
+		{os: "linux", kvm: true, docker: true, want: constants.DriverKvm2},
+		{os: "linux", docker: true, want: constants.DriverNone},
+		{os: "linux", want: constants.DriverVirtualbox},
+		{os: "darwin", hyperkit: true, want: constants.DriverHyperkit},
+		{os: "darwin", kvm: true, want: constants.DriverVirtualbox},
+		{os: "windows", want: constants.DriverVirtualbox},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		hostOS = tc.os
+		hasKVM = func() bool { return tc.kvm }
+		hasHyperkit = func() bool { return tc.hyperkit }
+		hasDocker = func() bool { return tc.docker }
+
+		got, reason := SuggestDriver()
+		if got != tc.want {
+			t.Errorf("SuggestDriver() on %+v = %q, want %q", tc, got, tc.want)
+		}
+		if reason == "" {
+			t.Errorf("SuggestDriver() on %+v gave no reason", tc)
+		}
+	}
+}
+
+func TestCheckHypervisor(t *testing.T) {
+	origOS, origKVM, origHyperkit, origDocker, origVBox := hostOS, hasKVM, hasHyperkit, hasDocker, hasVirtualBox
+	defer func() {
+		hostOS, hasKVM, hasHyperkit, hasDocker, hasVirtualBox = origOS, origKVM, origHyperkit, origDocker, origVBox
+	}()
+
+	var tcs = []struct {
+		driver  string
+		os      string
+		present bool
+		// wantErr is part of the message expected, if any
+		wantErr string
+	}{
+		{driver: constants.DriverKvm2, os: "linux", present: true},
+		{driver: "docker-machine-driver-kvm2", os: "linux", present: true},
+		{driver: constants.DriverKvm2, os: "linux", wantErr: "/dev/kvm"},
+		{driver: "docker-machine-driver-kvm2", os: "linux", wantErr: "needs KVM"},
+		{driver: constants.DriverKvm2, os: "darwin", present: true, wantErr: "only runs on linux"},
+		{driver: constants.DriverHyperkit, os: "darwin", present: true},
+		{driver: constants.DriverHyperkit, os: "darwin", wantErr: "brew install hyperkit"},
+		{driver: constants.DriverHyperkit, os: "windows", present: true, wantErr: "only runs on darwin"},
+		{driver: constants.DriverVirtualbox, os: "windows", present: true},
+		{driver: constants.DriverVirtualbox, os: "linux", wantErr: "install VirtualBox"},
+		{driver: constants.DriverNone, os: "linux", present: true},
+		{driver: constants.DriverNone, os: "linux", wantErr: "install docker"},
+		{driver: constants.DriverParallels, os: "darwin"},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		hostOS = tc.os
+		present := func() bool { return tc.present }
+		hasKVM, hasHyperkit, hasDocker, hasVirtualBox = present, present, present, present
+
+		err := CheckHypervisor(tc.driver)
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("CheckHypervisor(%s) on %+v error = %v", tc.driver, tc, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("CheckHypervisor(%s) on %+v error = %v, want one mentioning %q", tc.driver, tc, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// transientErrno returns whether a command failed to start for a reason worth retrying.
+// CreateProcess reports low memory or a driver still held open by a scanner as these codes.
+func transientErrno(e syscall.Errno) bool {
+	switch e {
+	case windows.ERROR_NOT_ENOUGH_MEMORY, windows.ERROR_OUTOFMEMORY, windows.ERROR_COMMITMENT_LIMIT,
+		windows.ERROR_NO_SYSTEM_RESOURCES, windows.ERROR_SHARING_VIOLATION, windows.ERROR_LOCK_VIOLATION:
+		return true
+	}
+	return false
+}
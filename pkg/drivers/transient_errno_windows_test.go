@@ -0,0 +1,44 @@
+/*
+Copyright 2019 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestTransientExecError(t *testing.T) {
+	var tcs = []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{desc: "out of memory", err: &os.PathError{Op: "fork/exec", Path: "driver", Err: windows.ERROR_NOT_ENOUGH_MEMORY}, want: true},
+		{desc: "out of resources", err: &os.PathError{Op: "fork/exec", Path: "driver", Err: windows.ERROR_NO_SYSTEM_RESOURCES}, want: true},
+		{desc: "held open", err: &exec.Error{Name: "driver", Err: windows.ERROR_SHARING_VIOLATION}, want: true},
+		{desc: "missing", err: &os.PathError{Op: "fork/exec", Path: "driver", Err: windows.ERROR_FILE_NOT_FOUND}},
+		{desc: "access denied", err: &os.PathError{Op: "fork/exec", Path: "driver", Err: windows.ERROR_ACCESS_DENIED}},
+	}
+	for _, tc := range tcs {
+		if got := transientExecError(tc.err); got != tc.want {
+			t.Errorf("%s: transientExecError(%v) = %v, want %v", tc.desc, tc.err, got, tc.want)
+		}
+	}
+}
@@ -145,8 +145,6 @@ const (
 	MinimumCPUS = 2
 	// DefaultDiskSize is the default disk image size, in megabytes
 	DefaultDiskSize = "20000mb"
-	// MinimumDiskSize is the minimum disk image size, in megabytes
-	MinimumDiskSize = "2000mb"
 	// DefaultVMDriver is the default virtual machine driver name
 	DefaultVMDriver = DriverVirtualbox
 	// DefaultStatusFormat is the default format of a host